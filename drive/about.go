@@ -0,0 +1,89 @@
+// Package drive implements a Google Drive client for standalone usage
+//
+// This file contains the quota reporting
+package drive
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/standalone-gdrive/fs"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Abouter = (*Fs)(nil)
+)
+
+// About gets quota information.
+//
+// For My Drive the storage quota of the user is returned: Used is the
+// space taken by files in Drive, Other the space taken by other
+// services such as Gmail and Photos, and Total and Free are only set
+// if the account has a limit.
+//
+// Shared drives don't have a quota of their own, so their usage is
+// computed by adding up the files in the drive and Total and Free are
+// left unset.
+func (f *Fs) About(ctx context.Context) (_ *fs.Usage, err error) {
+	defer translateErrorp(&err, fs.EntryDirectory)
+	if f.isTeamDrive {
+		return f.aboutTeamDrive(ctx)
+	}
+
+	var about *drive.About
+	err = f.pacer.Call(ctx, func() (err error) {
+		about, err = f.svc.About.Get().Fields("storageQuota").Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read quota: %w", err)
+	}
+	q := about.StorageQuota
+	if q == nil {
+		return &fs.Usage{}, nil
+	}
+
+	usage := &fs.Usage{
+		Used:    newInt64(q.UsageInDrive),
+		Trashed: newInt64(q.UsageInDriveTrash),
+		Other:   newInt64(q.Usage - q.UsageInDrive),
+	}
+	if q.Limit > 0 {
+		usage.Total = newInt64(q.Limit)
+		usage.Free = newInt64(q.Limit - q.Usage)
+	}
+	return usage, nil
+}
+
+// aboutTeamDrive computes the usage of a shared drive from its files
+func (f *Fs) aboutTeamDrive(ctx context.Context) (*fs.Usage, error) {
+	var used, trashed, objects int64
+	query := "mimeType!=" + quoteQuery(driveFolderType)
+	err := f.listPages(ctx, query, func(files []*drive.File) error {
+		for _, file := range files {
+			if file.Trashed {
+				trashed += file.QuotaBytesUsed
+			} else {
+				used += file.QuotaBytesUsed
+				objects++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't compute shared drive usage: %w", err)
+	}
+	return &fs.Usage{
+		Used:    newInt64(used),
+		Trashed: newInt64(trashed),
+		Objects: newInt64(objects),
+	}, nil
+}
+
+// newInt64 returns a pointer to n for filling in fs.Usage
+func newInt64(n int64) *int64 {
+	return &n
+}
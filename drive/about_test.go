@@ -0,0 +1,87 @@
+package drive
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func TestAbout(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/about", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, &drive.About{StorageQuota: &drive.AboutStorageQuota{
+			Limit:             1000,
+			Usage:             600,
+			UsageInDrive:      400,
+			UsageInDriveTrash: 50,
+		}})
+	})
+	f := newTestFs(t, Options{}, mux)
+	if f.Features().About == nil {
+		t.Fatal("Features().About should be set")
+	}
+
+	usage, err := f.About(context.Background())
+	if err != nil {
+		t.Fatalf("About failed: %v", err)
+	}
+	for name, test := range map[string]struct {
+		got  *int64
+		want int64
+	}{
+		"total":   {usage.Total, 1000},
+		"used":    {usage.Used, 400},
+		"trashed": {usage.Trashed, 50},
+		"other":   {usage.Other, 200},
+		"free":    {usage.Free, 400},
+	} {
+		if test.got == nil || *test.got != test.want {
+			t.Errorf("%s: got %v, want %d", name, test.got, test.want)
+		}
+	}
+}
+
+func TestAboutTeamDrive(t *testing.T) {
+	f := newTestFs(t, Options{TeamDriveID: "td"}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, &drive.FileList{Files: []*drive.File{
+			{Id: "1", QuotaBytesUsed: 100},
+			{Id: "2", QuotaBytesUsed: 200},
+			{Id: "3", QuotaBytesUsed: 50, Trashed: true},
+		}})
+	}))
+	f.isTeamDrive = true
+
+	usage, err := f.About(context.Background())
+	if err != nil {
+		t.Fatalf("About failed: %v", err)
+	}
+	if usage.Total != nil || usage.Free != nil {
+		t.Errorf("shared drive should have no total or free")
+	}
+	if *usage.Used != 300 || *usage.Trashed != 50 || *usage.Objects != 2 {
+		t.Errorf("got used %d trashed %d objects %d", *usage.Used, *usage.Trashed, *usage.Objects)
+	}
+}
+
+func TestSizeAsQuota(t *testing.T) {
+	f := newTestFs(t, Options{SizeAsQuota: true}, http.NotFoundHandler())
+	o := f.newObjectWithInfo("file", &drive.File{Id: "id", Size: 10, QuotaBytesUsed: 30})
+	if o.Size() != 30 {
+		t.Errorf("got size %d, want the quota used 30", o.Size())
+	}
+
+	// Transfers use the size of the content, even for files whose
+	// quota isn't counted against the user
+	var requests int
+	f = newTestFs(t, Options{SizeAsQuota: true, V2DownloadMinSize: -1, MultiThreadStreams: 2}, rangeServer(t, false, &requests))
+	o = f.newObjectWithInfo("file", &drive.File{Id: "id", Size: int64(len(rangeContent))})
+	w := &writerAt{buf: make([]byte, len(rangeContent))}
+	if err := o.MultiThreadDownload(context.Background(), w); err != nil {
+		t.Fatalf("MultiThreadDownload failed: %v", err)
+	}
+	if string(w.buf) != rangeContent {
+		t.Errorf("got %q", w.buf)
+	}
+}
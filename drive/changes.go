@@ -0,0 +1,253 @@
+// Package drive implements a Google Drive client for standalone usage
+//
+// This file contains change notification using the Drive Changes API
+package drive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/standalone-gdrive/fs"
+
+	"google.golang.org/api/drive/v3"
+)
+
+const (
+	defaultPollInterval = fs.Duration(time.Minute)
+	changeFields        = "nextPageToken,newStartPageToken,changes(fileId,removed,file(name,parents,mimeType,trashed))"
+	maxChangeDepth      = 100 // the most parents followed to find where a change is
+)
+
+// Check the interfaces are satisfied
+var (
+	_ fs.ChangeNotifier = (*Fs)(nil)
+)
+
+// ChangeNotify calls notifyFunc with the path of every object or
+// directory which changes on the remote.
+//
+// Changes are polled with the Changes API every PollInterval. File
+// IDs are turned back into paths using the directory cache, looking up
+// the parents of changes under directories which aren't cached. Drive
+// doesn't say where a deleted file was, so deleting a file which isn't
+// a cached directory is reported as a change to the root directory.
+//
+// Polling stops when the returned channel is closed or sent a value,
+// or when ctx is cancelled. A PollInterval of 0 disables polling.
+func (f *Fs) ChangeNotify(ctx context.Context, notifyFunc func(string, fs.EntryType)) chan bool {
+	quit := make(chan bool)
+	go func() {
+		interval := time.Duration(f.opt.PollInterval)
+		if interval <= 0 {
+			select {
+			case <-quit:
+			case <-ctx.Done():
+			}
+			return
+		}
+
+		// Make sure the root can be found from its ID, including the
+		// real ID of the "root" alias which parents are given as
+		if rootID, err := f.dirCache.FindDir(ctx, ""); err == nil {
+			f.dirCache.Put("", rootID)
+			if rootID == "root" {
+				var info *drive.File
+				err := f.pacer.Call(ctx, func() (err error) {
+					info, err = f.svc.Files.Get(rootID).Fields("id").Context(ctx).Do()
+					return err
+				})
+				if err == nil {
+					f.dirCache.Put("", info.Id)
+				}
+			}
+		}
+
+		pageToken, err := f.changeNotifyStartPageToken(ctx)
+		if err != nil {
+			f.LogError("change notify: couldn't read start page token: %v", err)
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if pageToken == "" {
+				pageToken, err = f.changeNotifyStartPageToken(ctx)
+				if err != nil {
+					f.LogError("change notify: couldn't read start page token: %v", err)
+				}
+				continue
+			}
+			pageToken, err = f.changeNotifyRunner(ctx, notifyFunc, pageToken)
+			if err != nil {
+				f.LogError("change notify: %v", err)
+			}
+		}
+	}()
+	return quit
+}
+
+// changeNotifyStartPageToken returns the token to start reading
+// changes from
+func (f *Fs) changeNotifyStartPageToken(ctx context.Context) (string, error) {
+	var startPageToken *drive.StartPageToken
+	err := f.pacer.Call(ctx, func() (err error) {
+		call := f.svc.Changes.GetStartPageToken().SupportsAllDrives(f.isTeamDrive)
+		if f.isTeamDrive {
+			call.DriveId(f.opt.TeamDriveID)
+		}
+		startPageToken, err = call.Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return startPageToken.StartPageToken, nil
+}
+
+// changedPath is a path reported by the Changes API
+type changedPath struct {
+	path      string
+	entryType fs.EntryType
+}
+
+// changeNotifyRunner reads every page of changes since pageToken,
+// calling notifyFunc with the paths which changed.
+//
+// It returns the token to use for the next poll. On error the original
+// token is returned so no changes are lost.
+func (f *Fs) changeNotifyRunner(ctx context.Context, notifyFunc func(string, fs.EntryType), pageToken string) (string, error) {
+	startToken := pageToken
+	var changed []changedPath
+	for {
+		var changeList *drive.ChangeList
+		err := f.pacer.Call(ctx, func() (err error) {
+			call := f.svc.Changes.List(pageToken).
+				Fields(changeFields).
+				PageSize(int64(f.opt.ListChunk)).
+				IncludeRemoved(true).
+				SupportsAllDrives(f.isTeamDrive).
+				IncludeItemsFromAllDrives(f.isTeamDrive)
+			if f.isTeamDrive {
+				call.DriveId(f.opt.TeamDriveID)
+			}
+			changeList, err = call.Context(ctx).Do()
+			return err
+		})
+		if err != nil {
+			return startToken, fmt.Errorf("couldn't list changes: %w", err)
+		}
+
+		for _, change := range changeList.Changes {
+			changed = append(changed, f.changePaths(ctx, change)...)
+		}
+
+		if changeList.NewStartPageToken != "" {
+			pageToken = changeList.NewStartPageToken
+			break
+		}
+		if changeList.NextPageToken == "" {
+			break
+		}
+		pageToken = changeList.NextPageToken
+	}
+
+	// Notify each path once, and forget directories which changed
+	// so they are looked up again
+	seen := make(map[changedPath]struct{}, len(changed))
+	for _, c := range changed {
+		if _, ok := seen[c]; ok {
+			continue
+		}
+		seen[c] = struct{}{}
+		if c.entryType == fs.EntryDirectory && c.path != "" {
+			f.dirCache.FlushDir(c.path)
+		}
+		notifyFunc(c.path, c.entryType)
+	}
+	return pageToken, nil
+}
+
+// changePaths works out which paths a change affects
+func (f *Fs) changePaths(ctx context.Context, change *drive.Change) (paths []changedPath) {
+	entryType := fs.EntryObject
+	if change.File != nil && change.File.MimeType == driveFolderType {
+		entryType = fs.EntryDirectory
+	}
+
+	// The old location of a directory we know about
+	if oldPath, ok := f.dirCache.GetInv(change.FileId); ok {
+		paths = append(paths, changedPath{path: oldPath, entryType: fs.EntryDirectory})
+	}
+
+	// Drive doesn't say where a deleted file was, so report the root
+	// to make sure the removal isn't lost
+	if change.File == nil {
+		if change.Removed && len(paths) == 0 {
+			paths = append(paths, changedPath{path: "", entryType: fs.EntryDirectory})
+		}
+		return paths
+	}
+
+	// The new location under any parents which are under the root
+	for _, parent := range change.File.Parents {
+		parentPath, ok, err := f.changeDirPath(ctx, parent)
+		if err != nil {
+			f.LogError("change notify: couldn't find where %q is: %v", change.File.Name, err)
+			paths = append(paths, changedPath{path: "", entryType: fs.EntryDirectory})
+			continue
+		}
+		if ok {
+			paths = append(paths, changedPath{path: path.Join(parentPath, change.File.Name), entryType: entryType})
+		}
+	}
+	return paths
+}
+
+// changeDirPath returns the path of the directory with id, looking up
+// the directories above it which aren't in the directory cache and
+// caching them. ok is false if the directory isn't under the root.
+func (f *Fs) changeDirPath(ctx context.Context, id string) (dirPath string, ok bool, err error) {
+	var leaves, ids []string
+	for depth := 0; depth < maxChangeDepth; depth++ {
+		if dirPath, ok := f.dirCache.GetInv(id); ok {
+			for i := len(leaves) - 1; i >= 0; i-- {
+				dirPath = path.Join(dirPath, leaves[i])
+				f.dirCache.Put(dirPath, ids[i])
+			}
+			return dirPath, true, nil
+		}
+		var info *drive.File
+		err := f.pacer.Call(ctx, func() (err error) {
+			info, err = f.svc.Files.Get(id).
+				Fields("name,parents").
+				SupportsAllDrives(f.isTeamDrive).
+				Context(ctx).
+				Do()
+			return err
+		})
+		if errors.Is(translateError(err, fs.EntryDirectory), fs.ErrorDirNotFound) {
+			return "", false, nil
+		}
+		if err != nil {
+			return "", false, err
+		}
+		if len(info.Parents) == 0 {
+			// The top of a drive which isn't the root
+			return "", false, nil
+		}
+		leaves = append(leaves, info.Name)
+		ids = append(ids, id)
+		id = info.Parents[0]
+	}
+	return "", false, nil
+}
@@ -0,0 +1,83 @@
+package drive
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/standalone-gdrive/fs"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func TestChangeNotify(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/changes/startPageToken", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, &drive.StartPageToken{StartPageToken: "1"})
+	})
+	mux.HandleFunc("/changes", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("pageToken") {
+		case "1":
+			writeJSON(w, &drive.ChangeList{
+				NextPageToken: "2",
+				Changes: []*drive.Change{
+					{FileId: "f1", File: &drive.File{Name: "a.txt", Parents: []string{"id-dir"}}},
+					{FileId: "unknown", File: &drive.File{Name: "b.txt", Parents: []string{"elsewhere"}}},
+				},
+			})
+		case "2":
+			writeJSON(w, &drive.ChangeList{
+				NewStartPageToken: "3",
+				Changes: []*drive.Change{
+					{FileId: "id-sub", File: &drive.File{Name: "renamed", Parents: []string{"id-dir"}, MimeType: driveFolderType}},
+					{FileId: "f2", Removed: true},
+					{FileId: "f3", File: &drive.File{Name: "c.txt", Parents: []string{"id-deep"}, Trashed: true}},
+				},
+			})
+		default:
+			writeJSON(w, &drive.ChangeList{NewStartPageToken: r.URL.Query().Get("pageToken")})
+		}
+	})
+	mux.HandleFunc("/files/id-deep", func(w http.ResponseWriter, r *http.Request) {
+		// A directory which hasn't been listed
+		writeJSON(w, &drive.File{Name: "deep", Parents: []string{"id-dir"}})
+	})
+	f := newTestFs(t, Options{PollInterval: fs.Duration(10 * time.Millisecond)}, mux)
+	f.dirCache.Put("dir", "id-dir")
+	f.dirCache.Put("dir/sub", "id-sub")
+
+	type change struct {
+		path      string
+		entryType fs.EntryType
+	}
+	changes := make(chan change, 10)
+	quit := f.ChangeNotify(context.Background(), func(path string, entryType fs.EntryType) {
+		changes <- change{path, entryType}
+	})
+	defer close(quit)
+
+	want := []change{
+		{"dir/a.txt", fs.EntryObject},
+		{"dir/sub", fs.EntryDirectory},
+		{"dir/renamed", fs.EntryDirectory},
+		{"", fs.EntryDirectory},
+		{"dir/deep/c.txt", fs.EntryObject},
+	}
+	for _, w := range want {
+		select {
+		case got := <-changes:
+			if got != w {
+				t.Errorf("got %+v, want %+v", got, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %+v", w)
+		}
+	}
+	if _, ok := f.dirCache.Get("dir/sub"); ok {
+		t.Error("changed directory should have been flushed from the cache")
+	}
+	if id, ok := f.dirCache.Get("dir/deep"); !ok || id != "id-deep" {
+		t.Errorf("looked up directory wasn't cached: got %q, %v", id, ok)
+	}
+}
@@ -13,6 +13,8 @@ import (
 	"hash"
 	"io"
 	"os"
+
+	fshash "github.com/standalone-gdrive/fs/hash"
 )
 
 var (
@@ -103,3 +105,85 @@ func CalculateFileChecksum(filePath string, checksumType ChecksumType) (string,
 
 	return hex.EncodeToString(h.Sum(nil)), nil
 }
+
+// checksumReader calculates the MD5 and SHA-256 checksums of the data
+// read through it, so transfers can be checked against the checksums
+// Drive reports without reading the data twice
+type checksumReader struct {
+	in     io.Reader
+	md5    hash.Hash
+	sha256 hash.Hash
+}
+
+// newChecksumReader returns a checksumReader reading from in
+func newChecksumReader(in io.Reader) *checksumReader {
+	return &checksumReader{
+		in:     in,
+		md5:    fshash.MD5.New(),
+		sha256: fshash.SHA256.New(),
+	}
+}
+
+// Read implements io.Reader
+func (r *checksumReader) Read(p []byte) (n int, err error) {
+	n, err = r.in.Read(p)
+	if n > 0 {
+		_, _ = r.md5.Write(p[:n])
+		_, _ = r.sha256.Write(p[:n])
+	}
+	return n, err
+}
+
+// check compares the checksums of the data read so far with md5sum
+// and sha256sum, skipping any which are empty
+func (r *checksumReader) check(md5sum, sha256sum string) error {
+	if md5sum != "" {
+		if got := hex.EncodeToString(r.md5.Sum(nil)); got != md5sum {
+			return fmt.Errorf("%w: expected %s but got %s (type: %s)", ErrChecksumMismatch, md5sum, got, ChecksumMD5)
+		}
+	}
+	if sha256sum != "" {
+		if got := hex.EncodeToString(r.sha256.Sum(nil)); got != sha256sum {
+			return fmt.Errorf("%w: expected %s but got %s (type: %s)", ErrChecksumMismatch, sha256sum, got, ChecksumSHA256)
+		}
+	}
+	return nil
+}
+
+// checkingReadCloser checks the checksums of a download when it
+// reaches the end, returning the mismatch instead of io.EOF
+type checkingReadCloser struct {
+	*checksumReader
+	rc        io.ReadCloser
+	remote    string
+	md5sum    string
+	sha256sum string
+}
+
+// newCheckingReadCloser wraps rc to check the data read against md5sum
+// and sha256sum
+func newCheckingReadCloser(rc io.ReadCloser, remote, md5sum, sha256sum string) io.ReadCloser {
+	return &checkingReadCloser{
+		checksumReader: newChecksumReader(rc),
+		rc:             rc,
+		remote:         remote,
+		md5sum:         md5sum,
+		sha256sum:      sha256sum,
+	}
+}
+
+// Read implements io.Reader
+func (r *checkingReadCloser) Read(p []byte) (n int, err error) {
+	n, err = r.checksumReader.Read(p)
+	if err == io.EOF {
+		if checkErr := r.check(r.md5sum, r.sha256sum); checkErr != nil {
+			return n, fmt.Errorf("download of %q corrupted: %w", r.remote, checkErr)
+		}
+	}
+	return n, err
+}
+
+// Close implements io.Closer
+func (r *checkingReadCloser) Close() error {
+	return r.rc.Close()
+}
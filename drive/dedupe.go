@@ -0,0 +1,387 @@
+// Package drive implements a Google Drive client for standalone usage
+//
+// This file contains the removal of duplicate files and directories
+package drive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/standalone-gdrive/fs"
+	"github.com/standalone-gdrive/fs/hash"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// Check the interfaces are satisfied
+var (
+	_ fs.MergeDirser = (*Fs)(nil)
+)
+
+// DedupeMode says what Dedupe does with files which have the same name
+type DedupeMode int
+
+// Dedupe modes
+const (
+	DedupeInteractive DedupeMode = iota // ask the DedupeChooser for each set of duplicates
+	DedupeSkip                          // leave the duplicates alone
+	DedupeFirst                         // keep the first one listed
+	DedupeNewest                        // keep the most recently modified
+	DedupeOldest                        // keep the least recently modified
+	DedupeLargest                       // keep the largest
+	DedupeSmallest                      // keep the smallest
+	DedupeRename                        // rename all but the first so the names are unique
+	DedupeIdentical                     // only remove copies with the same content as another
+	DedupeKeep                          // keep the object the DedupeChooser picked
+)
+
+// DedupeChooser is called in DedupeInteractive mode with the duplicates
+// found at remote, and returns the mode to deal with them in. It can
+// also pick the object to keep by returning DedupeKeep and one of
+// objects, which are all removed but that one.
+type DedupeChooser func(ctx context.Context, remote string, objects []fs.Object) (mode DedupeMode, keep fs.Object)
+
+// Duplicate policies choosing which of several files with the same name
+// FindLeaf and NewObject use
+const (
+	duplicateError    = "error"
+	duplicateFirst    = "first"
+	duplicateNewest   = "newest"
+	duplicateOldest   = "oldest"
+	duplicateLargest  = "largest"
+	duplicateSmallest = "smallest"
+)
+
+// checkDuplicatePolicy returns an error if policy isn't known
+func checkDuplicatePolicy(policy string) error {
+	switch policy {
+	case "", duplicateError, duplicateFirst, duplicateNewest, duplicateOldest, duplicateLargest, duplicateSmallest:
+		return nil
+	}
+	return fmt.Errorf("unknown duplicate policy %q", policy)
+}
+
+// pickDuplicate chooses one of the files with the same name using the
+// DuplicatePolicy, or returns an error if the policy is to fail
+func (f *Fs) pickDuplicate(name string, files []*drive.File) (*drive.File, error) {
+	policy := f.opt.DuplicatePolicy
+	if policy == "" || policy == duplicateError {
+		return nil, fmt.Errorf("found multiple files with the same name: %q", name)
+	}
+	files = append([]*drive.File(nil), files...)
+	modTime := func(i int) time.Time {
+		t, _ := time.Parse(timeFormatIn, files[i].ModifiedTime)
+		return t
+	}
+	switch policy {
+	case duplicateNewest:
+		sort.SliceStable(files, func(i, j int) bool { return modTime(i).After(modTime(j)) })
+	case duplicateOldest:
+		sort.SliceStable(files, func(i, j int) bool { return modTime(i).Before(modTime(j)) })
+	case duplicateLargest:
+		sort.SliceStable(files, func(i, j int) bool { return files[i].Size > files[j].Size })
+	case duplicateSmallest:
+		sort.SliceStable(files, func(i, j int) bool { return files[i].Size < files[j].Size })
+	}
+	f.LogWarn("%q: found %d files with the same name, using the %s one", name, len(files), policy)
+	return files[0], nil
+}
+
+// MergeDirs merges the contents of all the directories passed in into
+// the first one and removes the others.
+func (f *Fs) MergeDirs(ctx context.Context, dirs []fs.Directory) (err error) {
+	defer translateErrorp(&err, fs.EntryDirectory)
+	if len(dirs) < 2 {
+		return nil
+	}
+	// A folder shortcut has the ID of its target, which lives elsewhere
+	for _, dir := range dirs {
+		if d, ok := dir.(*Directory); ok && d.shortcutID != "" {
+			return fmt.Errorf("can't merge the folder shortcut %q", dir.Remote())
+		}
+	}
+	dst := dirs[0]
+	dstID := dst.ID()
+	for _, src := range dirs[1:] {
+		srcID := src.ID()
+
+		// Read everything first as moving changes the listing
+		var children []*drive.File
+		query := "trashed=false and " + quoteQuery(srcID) + " in parents"
+		err := f.listPages(ctx, query, func(files []*drive.File) error {
+			children = append(children, files...)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("couldn't list %q to merge: %w", src.Remote(), err)
+		}
+
+		for _, child := range children {
+			f.LogInfo("%q: merging %q into the directory with ID %q", src.Remote(), child.Name, dstID)
+			err := f.pacer.Call(ctx, func() error {
+				_, err := f.svc.Files.Update(child.Id, &drive.File{}).
+					AddParents(dstID).
+					RemoveParents(srcID).
+					Fields("").
+					SupportsAllDrives(f.isTeamDrive).
+					Context(ctx).
+					Do()
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("couldn't merge %q into %q: %w", path.Join(src.Remote(), child.Name), dst.Remote(), err)
+			}
+		}
+
+		if err := f.delete(ctx, srcID, f.opt.UseTrash); err != nil {
+			return fmt.Errorf("couldn't remove merged directory %q: %w", src.Remote(), err)
+		}
+	}
+	f.dirCache.FlushDir(dst.Remote())
+	f.dirCache.Put(dst.Remote(), dstID)
+	return nil
+}
+
+// Dedupe finds files and directories with the same name in dir and
+// its subdirectories and deals with them.
+//
+// Duplicate directories are merged into one, except in DedupeSkip
+// mode. Duplicate files are dealt with according to mode, and in every
+// mode but DedupeSkip copies with the same MD5 as another are removed
+// first. choose must be set in DedupeInteractive mode.
+func (f *Fs) Dedupe(ctx context.Context, dir string, mode DedupeMode, choose DedupeChooser) (err error) {
+	defer translateErrorp(&err, fs.EntryDirectory)
+	if mode == DedupeInteractive && choose == nil {
+		return errors.New("interactive dedupe needs a chooser")
+	}
+	if mode == DedupeKeep {
+		return errors.New("only the dedupe chooser can pick the object to keep")
+	}
+	dirID, err := f.dirCache.FindDir(ctx, dir)
+	if err != nil {
+		return err
+	}
+	return f.dedupeDir(ctx, dir, dirID, mode, choose)
+}
+
+// dedupeDir dedupes the directory with dirID found at dir and then its
+// subdirectories.
+//
+// Folder shortcuts are neither merged nor descended into, as their
+// targets are somewhere else and may even contain dir.
+func (f *Fs) dedupeDir(ctx context.Context, dir, dirID string, mode DedupeMode, choose DedupeChooser) error {
+	var entries fs.DirEntries
+	err := f.listDirID(ctx, dir, dirID, func(page fs.DirEntries) error {
+		entries = append(entries, page...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Group the entries by their names on Drive, keeping the listing
+	// order
+	var dirNames, objectKeys []string
+	dirs := map[string][]fs.Directory{}
+	objects := map[string][]fs.Object{}
+	taken := map[string]bool{}
+	for _, entry := range entries {
+		taken[entry.Remote()] = true
+		if d, ok := entry.(*Directory); ok && d.shortcutID != "" {
+			continue
+		}
+		switch entry := entry.(type) {
+		case fs.Directory:
+			remote := entry.Remote()
+			if len(dirs[remote]) == 0 {
+				dirNames = append(dirNames, remote)
+			}
+			dirs[remote] = append(dirs[remote], entry)
+		case fs.Object:
+			key := dedupeKey(entry)
+			if len(objects[key]) == 0 {
+				objectKeys = append(objectKeys, key)
+			}
+			objects[key] = append(objects[key], entry)
+		}
+	}
+
+	var subdirs []fs.Directory
+	for _, remote := range dirNames {
+		group := dirs[remote]
+		if len(group) > 1 && mode != DedupeSkip {
+			if err := f.MergeDirs(ctx, group); err != nil {
+				return err
+			}
+			group = group[:1]
+		}
+		subdirs = append(subdirs, group...)
+	}
+	for _, key := range objectKeys {
+		group := objects[key]
+		if len(group) > 1 {
+			if err := f.dedupeObjects(ctx, group[0].Remote(), group, mode, choose, taken); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, subdir := range subdirs {
+		if err := f.dedupeDir(ctx, subdir.Remote(), subdir.ID(), mode, choose); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dedupeKey returns the key grouping objects which are duplicates on
+// Drive: the name without any export extension and the kind of object,
+// so an exported document is never grouped with a file with the name
+// it is exported as
+func dedupeKey(o fs.Object) string {
+	remote := o.Remote()
+	name := remote[:len(remote)-exportExtLen(o)]
+	switch o := o.(type) {
+	case *documentObject:
+		return name + "\x00document\x00" + o.documentMimeType
+	case *linkObject:
+		return name + "\x00link\x00" + remote[len(name):]
+	}
+	return name + "\x00file"
+}
+
+// dedupeObjects deals with the objects which all have the name remote.
+// taken holds the names in use in the directory.
+func (f *Fs) dedupeObjects(ctx context.Context, remote string, objects []fs.Object, mode DedupeMode, choose DedupeChooser, taken map[string]bool) error {
+	var keep fs.Object
+	if mode == DedupeInteractive {
+		mode, keep = choose(ctx, remote, objects)
+	}
+	if mode == DedupeSkip || mode == DedupeInteractive {
+		f.LogInfo("%q: skipping %d duplicates", remote, len(objects))
+		return nil
+	}
+	if mode == DedupeKeep {
+		for i, o := range objects {
+			if o == keep {
+				objects[0], objects[i] = objects[i], objects[0]
+				return f.removeDuplicates(ctx, remote, objects[1:])
+			}
+		}
+		return fmt.Errorf("%q: the object chosen to keep isn't one of the duplicates", remote)
+	}
+
+	objects, err := f.removeIdentical(ctx, remote, objects)
+	if err != nil || len(objects) < 2 || mode == DedupeIdentical {
+		return err
+	}
+
+	switch mode {
+	case DedupeRename:
+		return f.renameDuplicates(ctx, objects[1:], taken)
+	case DedupeNewest:
+		sort.SliceStable(objects, func(i, j int) bool { return objects[i].ModTime(ctx).After(objects[j].ModTime(ctx)) })
+	case DedupeOldest:
+		sort.SliceStable(objects, func(i, j int) bool { return objects[i].ModTime(ctx).Before(objects[j].ModTime(ctx)) })
+	case DedupeLargest:
+		sort.SliceStable(objects, func(i, j int) bool { return objects[i].Size() > objects[j].Size() })
+	case DedupeSmallest:
+		sort.SliceStable(objects, func(i, j int) bool { return objects[i].Size() < objects[j].Size() })
+	case DedupeFirst:
+	default:
+		return fmt.Errorf("unknown dedupe mode %d", mode)
+	}
+	return f.removeDuplicates(ctx, remote, objects[1:])
+}
+
+// removeDuplicates removes objects, which are duplicates at remote
+func (f *Fs) removeDuplicates(ctx context.Context, remote string, objects []fs.Object) error {
+	for _, o := range objects {
+		f.LogInfo("%q: removing duplicate with ID %q", remote, o.(fs.IDer).ID())
+		if err := o.Remove(ctx); err != nil {
+			return fmt.Errorf("couldn't remove duplicate %q: %w", remote, err)
+		}
+	}
+	return nil
+}
+
+// removeIdentical removes objects with the same MD5 as an earlier one,
+// returning the objects left. Objects without an MD5, such as Google
+// documents, are never removed.
+func (f *Fs) removeIdentical(ctx context.Context, remote string, objects []fs.Object) ([]fs.Object, error) {
+	seen := map[string]bool{}
+	kept := objects[:0:0]
+	for _, o := range objects {
+		sum, err := o.Hash(ctx, hash.MD5)
+		if err != nil {
+			return nil, err
+		}
+		if sum == "" || !seen[sum] {
+			seen[sum] = true
+			kept = append(kept, o)
+			continue
+		}
+		f.LogInfo("%q: removing identical duplicate with ID %q", remote, o.(fs.IDer).ID())
+		if err := o.Remove(ctx); err != nil {
+			return nil, fmt.Errorf("couldn't remove duplicate %q: %w", remote, err)
+		}
+	}
+	return kept, nil
+}
+
+// renameDuplicates renames objects by adding a number to their names,
+// avoiding the names in taken
+func (f *Fs) renameDuplicates(ctx context.Context, objects []fs.Object, taken map[string]bool) error {
+	n := 1
+	for _, o := range objects {
+		remote := o.Remote()
+
+		// Exported documents are stored without the export extension,
+		// so the number goes before it
+		extLen := exportExtLen(o)
+		if extLen == 0 {
+			extLen = len(path.Ext(remote))
+		}
+		base, ext := remote[:len(remote)-extLen], remote[len(remote)-extLen:]
+		var newRemote string
+		for {
+			newRemote = fmt.Sprintf("%s-%d%s", base, n, ext)
+			n++
+			if !taken[newRemote] {
+				break
+			}
+		}
+		taken[newRemote] = true
+		_, newLeaf := splitPath(newRemote)
+		newLeaf = newLeaf[:len(newLeaf)-exportExtLen(o)]
+		f.LogInfo("%q: renaming duplicate with ID %q to %q", remote, o.(fs.IDer).ID(), newRemote)
+		err := f.pacer.Call(ctx, func() error {
+			_, err := f.svc.Files.Update(o.(fs.IDer).ID(), &drive.File{Name: newLeaf}).
+				Fields("").
+				SupportsAllDrives(f.isTeamDrive).
+				Context(ctx).
+				Do()
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("couldn't rename duplicate %q: %w", remote, err)
+		}
+	}
+	return nil
+}
+
+// exportExtLen returns the length of the export extension added to the
+// name of o, or 0 if it isn't an exported document
+func exportExtLen(o fs.Object) int {
+	switch o := o.(type) {
+	case *documentObject:
+		return o.extLen
+	case *linkObject:
+		return o.extLen
+	}
+	return 0
+}
@@ -0,0 +1,300 @@
+package drive
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/standalone-gdrive/fs"
+
+	"google.golang.org/api/drive/v3"
+)
+
+var (
+	parentQueryRe = regexp.MustCompile(`'([^']+)' in parents`)
+	nameQueryRe   = regexp.MustCompile(`name='([^']+)'`)
+)
+
+// fakeTree is a Drive holding files, which may have duplicate names
+type fakeTree struct {
+	mu    sync.Mutex
+	t     *testing.T
+	files []*drive.File
+}
+
+func (ft *fakeTree) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	id := strings.TrimPrefix(r.URL.Path, "/files/")
+	switch {
+	case r.URL.Path == "/files":
+		q := r.URL.Query().Get("q")
+		parent := parentQueryRe.FindStringSubmatch(q)[1]
+		name := nameQueryRe.FindStringSubmatch(q)
+		list := &drive.FileList{Files: []*drive.File{}}
+		for _, file := range ft.files {
+			if file.Parents[0] == parent && (name == nil || name[1] == file.Name) {
+				list.Files = append(list.Files, file)
+			}
+		}
+		writeJSON(w, list)
+	case r.Method == http.MethodGet:
+		writeJSON(w, ft.find(id))
+	case r.Method == http.MethodPatch:
+		var update drive.File
+		_ = json.NewDecoder(r.Body).Decode(&update)
+		file := ft.find(id)
+		if update.Name != "" {
+			file.Name = update.Name
+		}
+		if add := r.URL.Query().Get("addParents"); add != "" {
+			file.Parents = []string{add}
+		}
+		writeJSON(w, file)
+	case r.Method == http.MethodDelete:
+		for i, file := range ft.files {
+			if file.Id == id {
+				ft.files = append(ft.files[:i], ft.files[i+1:]...)
+				break
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		ft.t.Errorf("unexpected %s %s", r.Method, r.URL)
+		http.Error(w, "unexpected", http.StatusBadRequest)
+	}
+}
+
+func (ft *fakeTree) find(id string) *drive.File {
+	for _, file := range ft.files {
+		if file.Id == id {
+			return file
+		}
+	}
+	ft.t.Errorf("no file with ID %q", id)
+	return &drive.File{}
+}
+
+// paths returns "parent/name:id" for every file, sorted
+func (ft *fakeTree) paths() string {
+	var paths []string
+	for _, file := range ft.files {
+		paths = append(paths, file.Parents[0]+"/"+file.Name+":"+file.Id)
+	}
+	sort.Strings(paths)
+	return strings.Join(paths, " ")
+}
+
+func newFakeTree(t *testing.T, files ...*drive.File) *fakeTree {
+	for _, file := range files {
+		if file.ModifiedTime == "" {
+			file.ModifiedTime = "2024-01-01T00:00:00Z"
+		}
+	}
+	return &fakeTree{t: t, files: files}
+}
+
+func TestDedupeMergesAndKeepsNewest(t *testing.T) {
+	ft := newFakeTree(t,
+		&drive.File{Id: "d1", Name: "dir", MimeType: driveFolderType, Parents: []string{"root"}},
+		&drive.File{Id: "d2", Name: "dir", MimeType: driveFolderType, Parents: []string{"root"}},
+		&drive.File{Id: "old", Name: "a.txt", Md5Checksum: "aaa", Parents: []string{"d1"}, ModifiedTime: "2024-01-01T00:00:00Z"},
+		&drive.File{Id: "new", Name: "a.txt", Md5Checksum: "bbb", Parents: []string{"d2"}, ModifiedTime: "2024-02-01T00:00:00Z"},
+		&drive.File{Id: "b", Name: "b.txt", Md5Checksum: "ccc", Parents: []string{"d2"}},
+		&drive.File{Id: "c1", Name: "c.txt", Md5Checksum: "ddd", Parents: []string{"root"}, ModifiedTime: "2024-01-01T00:00:00Z"},
+		&drive.File{Id: "c2", Name: "c.txt", Md5Checksum: "ddd", Parents: []string{"root"}, ModifiedTime: "2024-03-01T00:00:00Z"},
+	)
+	f := newTestFs(t, Options{}, ft)
+
+	if err := f.Dedupe(context.Background(), "", DedupeNewest, nil); err != nil {
+		t.Fatalf("Dedupe failed: %v", err)
+	}
+	want := "d1/a.txt:new d1/b.txt:b root/c.txt:c1 root/dir:d1"
+	if got := ft.paths(); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if id, _ := f.dirCache.Get("dir"); id != "d1" {
+		t.Errorf("dir cached as %q, want d1", id)
+	}
+}
+
+func TestDedupeRename(t *testing.T) {
+	ft := newFakeTree(t,
+		&drive.File{Id: "x1", Name: "x.txt", Md5Checksum: "1", Parents: []string{"root"}},
+		&drive.File{Id: "x2", Name: "x.txt", Md5Checksum: "2", Parents: []string{"root"}},
+		&drive.File{Id: "x3", Name: "x.txt", Md5Checksum: "3", Parents: []string{"root"}},
+		&drive.File{Id: "x4", Name: "x.txt", Md5Checksum: "3", Parents: []string{"root"}},
+		&drive.File{Id: "taken", Name: "x-1.txt", Md5Checksum: "4", Parents: []string{"root"}},
+	)
+	f := newTestFs(t, Options{}, ft)
+
+	if err := f.Dedupe(context.Background(), "", DedupeRename, nil); err != nil {
+		t.Fatalf("Dedupe failed: %v", err)
+	}
+	want := "root/x-1.txt:taken root/x-2.txt:x2 root/x-3.txt:x3 root/x.txt:x1"
+	if got := ft.paths(); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestDedupeInteractive(t *testing.T) {
+	ft := newFakeTree(t,
+		&drive.File{Id: "s1", Name: "small.txt", Size: 1, Md5Checksum: "1", Parents: []string{"root"}},
+		&drive.File{Id: "s2", Name: "small.txt", Size: 2, Md5Checksum: "2", Parents: []string{"root"}},
+		&drive.File{Id: "k1", Name: "keep.txt", Md5Checksum: "1", Parents: []string{"root"}},
+		&drive.File{Id: "k2", Name: "keep.txt", Md5Checksum: "1", Parents: []string{"root"}},
+		&drive.File{Id: "p1", Name: "pick.txt", Md5Checksum: "1", Parents: []string{"root"}},
+		&drive.File{Id: "p2", Name: "pick.txt", Md5Checksum: "2", Parents: []string{"root"}},
+		&drive.File{Id: "p3", Name: "pick.txt", Md5Checksum: "3", Parents: []string{"root"}},
+	)
+	f := newTestFs(t, Options{}, ft)
+
+	if err := f.Dedupe(context.Background(), "", DedupeInteractive, nil); err == nil {
+		t.Error("expected an error without a chooser")
+	}
+
+	var asked []string
+	err := f.Dedupe(context.Background(), "", DedupeInteractive, func(ctx context.Context, remote string, objects []fs.Object) (DedupeMode, fs.Object) {
+		asked = append(asked, remote)
+		switch remote {
+		case "small.txt":
+			return DedupeSmallest, nil
+		case "pick.txt":
+			return DedupeKeep, objects[1]
+		}
+		return DedupeSkip, nil
+	})
+	if err != nil {
+		t.Fatalf("Dedupe failed: %v", err)
+	}
+	if strings.Join(asked, ",") != "small.txt,keep.txt,pick.txt" {
+		t.Errorf("asked about %v", asked)
+	}
+	want := "root/keep.txt:k1 root/keep.txt:k2 root/pick.txt:p2 root/small.txt:s1"
+	if got := ft.paths(); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestDedupeKeepsDocumentsAndFilesApart(t *testing.T) {
+	doc := func(id string) *drive.File {
+		return &drive.File{Id: id, Name: "x", MimeType: "application/vnd.google-apps.document", Parents: []string{"root"},
+			ExportLinks: map[string]string{docxMimeType: "http://example.com/" + id}}
+	}
+	ft := newFakeTree(t,
+		doc("doc1"),
+		&drive.File{Id: "file", Name: "x.docx", Md5Checksum: "1", Parents: []string{"root"}},
+		doc("doc2"),
+	)
+	f := newTestFs(t, Options{ExportFormats: "docx"}, ft)
+
+	if err := f.Dedupe(context.Background(), "", DedupeFirst, nil); err != nil {
+		t.Fatalf("Dedupe failed: %v", err)
+	}
+	want := "root/x.docx:file root/x:doc1"
+	if got := ft.paths(); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestDedupeLeavesFolderShortcutsAlone(t *testing.T) {
+	ft := newFakeTree(t,
+		&drive.File{Id: "x1", Name: "x", MimeType: driveFolderType, Parents: []string{"root"}},
+		&drive.File{Id: "a", Name: "a.txt", Md5Checksum: "aaa", Parents: []string{"x1"}},
+		// A shortcut with the same name as x to a folder elsewhere
+		&drive.File{Id: "s1", Name: "x", MimeType: shortcutMimeType, Parents: []string{"root"},
+			ShortcutDetails: &drive.FileShortcutDetails{TargetId: "other", TargetMimeType: driveFolderType}},
+		&drive.File{Id: "other", Name: "other", MimeType: driveFolderType, Parents: []string{"elsewhere"}},
+		&drive.File{Id: "b", Name: "b.txt", Md5Checksum: "bbb", Parents: []string{"other"}},
+		// A shortcut inside x back to its parent
+		&drive.File{Id: "top", Name: "top", MimeType: driveFolderType, Parents: []string{"root"}},
+		&drive.File{Id: "s2", Name: "up", MimeType: shortcutMimeType, Parents: []string{"top"},
+			ShortcutDetails: &drive.FileShortcutDetails{TargetId: "top", TargetMimeType: driveFolderType}},
+	)
+	f := newTestFs(t, Options{}, ft)
+	want := ft.paths()
+
+	if err := f.Dedupe(context.Background(), "", DedupeNewest, nil); err != nil {
+		t.Fatalf("Dedupe failed: %v", err)
+	}
+	if got := ft.paths(); got != want {
+		t.Errorf("got %s, want nothing changed from %s", got, want)
+	}
+
+	// Merging a shortcut is refused rather than emptying its target
+	entries, err := f.List(context.Background(), "")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	var dirs []fs.Directory
+	for _, entry := range entries {
+		if d, ok := entry.(fs.Directory); ok && d.Remote() == "x" {
+			dirs = append(dirs, d)
+		}
+	}
+	if err := f.MergeDirs(context.Background(), dirs); err == nil {
+		t.Error("merging a folder shortcut succeeded")
+	}
+	if got := ft.paths(); got != want {
+		t.Errorf("got %s after MergeDirs, want nothing changed", got)
+	}
+}
+
+func TestFindDirIgnoresFiles(t *testing.T) {
+	ft := newFakeTree(t,
+		&drive.File{Id: "file", Name: "dir", Parents: []string{"root"}},
+		&drive.File{Id: "dir", Name: "dir", MimeType: driveFolderType, Parents: []string{"root"}},
+		&drive.File{Id: "a", Name: "a.txt", Parents: []string{"dir"}},
+	)
+	f := newTestFs(t, Options{}, ft)
+
+	o, err := f.NewObject(context.Background(), "dir/a.txt")
+	if err != nil {
+		t.Fatalf("NewObject failed: %v", err)
+	}
+	if id := o.(fs.IDer).ID(); id != "a" {
+		t.Errorf("got %q, want a", id)
+	}
+}
+
+func TestNewObjectDuplicatePolicy(t *testing.T) {
+	for _, test := range []struct {
+		policy string
+		wantID string
+	}{
+		{"", ""},
+		{duplicateError, ""},
+		{duplicateFirst, "small"},
+		{duplicateLargest, "big"},
+		{duplicateNewest, "small"},
+		{duplicateOldest, "big"},
+	} {
+		ft := newFakeTree(t,
+			&drive.File{Id: "small", Name: "file.txt", Size: 1, Parents: []string{"root"}, ModifiedTime: "2024-02-01T00:00:00Z"},
+			&drive.File{Id: "big", Name: "file.txt", Size: 2, Parents: []string{"root"}, ModifiedTime: "2024-01-01T00:00:00Z"},
+		)
+		f := newTestFs(t, Options{DuplicatePolicy: test.policy}, ft)
+
+		o, err := f.NewObject(context.Background(), "file.txt")
+		if test.wantID == "" {
+			if err == nil {
+				t.Errorf("%q: expected an error", test.policy)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: NewObject failed: %v", test.policy, err)
+		}
+		if id := o.(fs.IDer).ID(); id != test.wantID {
+			t.Errorf("%q: got %q, want %q", test.policy, id, test.wantID)
+		}
+	}
+	if err := checkDuplicatePolicy("random"); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
@@ -2,6 +2,7 @@
 package drive
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"errors"
@@ -11,14 +12,17 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/standalone-gdrive/fs"
 	"github.com/standalone-gdrive/fs/hash"
+	"github.com/standalone-gdrive/lib/bwlimit"
 	"github.com/standalone-gdrive/lib/dircache"
 	"github.com/standalone-gdrive/lib/oauthutil"
+	"github.com/standalone-gdrive/lib/pacer"
 
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
@@ -46,11 +50,15 @@ const (
 	// 1<<18 is the minimum size supported by the Google uploader, and there is no maximum.
 	minChunkSize     = fs.SizeSuffix(googleapi.MinUploadChunkSize)
 	defaultChunkSize = 8 * fs.MiByte
-	partialFields    = "id,name,size,md5Checksum,sha1Checksum,sha256Checksum,trashed,explicitlyTrashed,modifiedTime,createdTime,mimeType,parents,webViewLink,shortcutDetails,exportLinks,resourceKey"
+	partialFields    = "id,name,size,md5Checksum,sha1Checksum,sha256Checksum,trashed,explicitlyTrashed,modifiedTime,createdTime,mimeType,parents,webViewLink,shortcutDetails,exportLinks,resourceKey,quotaBytesUsed"
 )
 
 // Globals
 var (
+	// The limiter shared by the transfers of every Fs, so opening
+	// several remotes doesn't multiply the bandwidth allowed
+	sharedBwLimiter = bwlimit.New(nil)
+
 	// Description of how to auth for this app
 	driveConfig = &oauthutil.Config{
 		Scopes:       []string{scopePrefix + "drive"},
@@ -116,22 +124,30 @@ type Options struct {
 	AlternateExport           bool          `json:"alternate_export"`
 	UploadCutoff              fs.SizeSuffix `json:"upload_cutoff"`
 	ChunkSize                 fs.SizeSuffix `json:"chunk_size"`
+	UploadSessionDir          string        `json:"upload_session_dir"` // directory to save resumable upload sessions in, empty to disable
 	AcknowledgeAbuse          bool          `json:"acknowledge_abuse"`
 	KeepRevisionForever       bool          `json:"keep_revision_forever"`
 	SizeAsQuota               bool          `json:"size_as_quota"`
 	PacerMinSleep             fs.Duration   `json:"pacer_min_sleep"`
 	PacerBurst                int           `json:"pacer_burst"`
+	PacerAdaptive             bool          `json:"pacer_adaptive"` // adapt the connections to rate limiting
+	BwLimit                   string        `json:"bwlimit"`        // bandwidth limits or timetable, eg "08:00,512k 19:00,off"
 	ServerSideAcrossConfigs   bool          `json:"server_side_across_configs"`
 	DisableHTTP2              bool          `json:"disable_http2"`
 	StopOnUploadLimit         bool          `json:"stop_on_upload_limit"`
 	StopOnDownloadLimit       bool          `json:"stop_on_download_limit"`
 	SkipShortcuts             bool          `json:"skip_shortcuts"`
 	SkipDanglingShortcuts     bool          `json:"skip_dangling_shortcuts"`
+	DuplicatePolicy           string        `json:"duplicate_policy"` // which of several files with the same name to use, or "error"
 	ResourceKey               string        `json:"resource_key"`
 	V2DownloadMinSize         fs.SizeSuffix `json:"v2_download_min_size"`
+	MultiThreadStreams        int           `json:"multi_thread_streams"` // number of ranges to download at once
+	MultiThreadCutoff         fs.SizeSuffix `json:"multi_thread_cutoff"`  // size above which downloads use several streams
+	DisableChecksum           bool          `json:"disable_checksum"`     // don't check transfers against the checksums from Drive
 	EnvAuth                   bool          `json:"env_auth"`
 	LogLevel                  string        `json:"log_level"`
 	LogOutput                 string        `json:"log_output"` // path to log file, empty for stderr
+	PollInterval              fs.Duration   `json:"poll_interval"`
 }
 
 // Fs represents a remote drive server
@@ -145,13 +161,15 @@ type Fs struct {
 	client           *http.Client                 // authorized client
 	rootFolderID     string                       // the id of the root folder
 	dirCache         *dircache.DirCache           // Map of directory path to directory id
-	pacer            *fs.Pacer                    // To pace the API calls
+	pacer            *pacer.Pacer                 // To pace the API calls
+	bwLimiter        *bwlimit.Limiter             // limits the bandwidth of transfers
 	exportExtensions []string                     // preferred extensions to download docs
 	importMimeTypes  []string                     // MIME types to convert to docs
 	isTeamDrive      bool                         // true if this is a team drive
 	dirResourceKeys  *sync.Map                    // map directory ID to resource key
+	shortcutDirs     *sync.Map                    // map parent ID and leaf of folder shortcuts to shortcut ID
 	permissionsMu    *sync.Mutex                  // protect the below
-	permissions      map[string]*drive.Permission // map permission IDs to Permissions
+	permissions      map[string]*drive.Permission // map file and permission IDs to Permissions
 	logger           *Logger                      // logging system
 }
 
@@ -164,6 +182,7 @@ type baseObject struct {
 	bytes        int64    // size of the object
 	parents      []string // IDs of the parent directories
 	resourceKey  *string  // resourceKey is needed for link shared objects
+	shortcutID   string   // ID of the shortcut this was found through, if any
 }
 
 type documentObject struct {
@@ -183,6 +202,7 @@ type linkObject struct {
 type Object struct {
 	baseObject
 	url        string // Download URL of this object
+	quota      int64  // storage used including revisions, reported as the size if SizeAsQuota is set
 	md5sum     string // md5sum of the object
 	sha1sum    string // sha1sum of the object
 	sha256sum  string // sha256sum of the object
@@ -194,6 +214,45 @@ type Directory struct {
 	baseObject
 }
 
+// newObjectWithInfo creates an Object from a drive.File
+//
+// If SizeAsQuota is set Size reports the storage used by the file,
+// including all its revisions, but transfers still use the size of its
+// content.
+func (f *Fs) newObjectWithInfo(remote string, info *drive.File) *Object {
+	return &Object{
+		baseObject: baseObject{
+			fs:           f,
+			remote:       remote,
+			id:           info.Id,
+			modifiedDate: info.ModifiedTime,
+			mimeType:     info.MimeType,
+			bytes:        info.Size,
+			parents:      info.Parents,
+		},
+		quota:      info.QuotaBytesUsed,
+		md5sum:     info.Md5Checksum,
+		sha1sum:    info.Sha1Checksum,
+		sha256sum:  info.Sha256Checksum,
+		v2Download: f.opt.V2DownloadMinSize >= 0 && info.Size >= int64(f.opt.V2DownloadMinSize),
+	}
+}
+
+// newDirectory creates a Directory from a drive.File
+func (f *Fs) newDirectory(remote string, info *drive.File) *Directory {
+	return &Directory{
+		baseObject: baseObject{
+			fs:           f,
+			remote:       remote,
+			id:           info.Id,
+			modifiedDate: info.ModifiedTime,
+			mimeType:     info.MimeType,
+			bytes:        0,
+			parents:      info.Parents,
+		},
+	}
+}
+
 // ------------------------------------------------------------
 
 // Name of the remote (as passed into NewFs)
@@ -216,30 +275,41 @@ func (f *Fs) Features() *fs.Features {
 	return f.features
 }
 
-// shouldRetry determines whether a given err rates being retried
-func (f *Fs) shouldRetry(ctx context.Context, err error) (bool, error) {
-	if err == nil {
-		return false, nil
-	}
-
-	switch gerr := err.(type) {
-	case *googleapi.Error:
-		if gerr.Code >= 500 && gerr.Code < 600 {
-			// All 5xx errors should be retried
-			return true, err
-		}
-		if len(gerr.Errors) > 0 {
-			reason := gerr.Errors[0].Reason
-			if reason == "rateLimitExceeded" || reason == "userRateLimitExceeded" {
-				return true, err
-			} else if reason == "downloadQuotaExceeded" {
-				return false, errors.New("download quota exceeded")
-			} else if reason == "quotaExceeded" || reason == "storageQuotaExceeded" {
-				return false, errors.New("storage quota exceeded")
-			}
-		}
+// newPacer makes the pacer for the API calls from opt and the
+// MaxConnections and NoRetries of the config in ctx
+func newPacer(ctx context.Context, opt *Options) *pacer.Pacer {
+	ci := fs.GetConfig(ctx)
+	options := []pacer.Option{
+		pacer.MinSleep(time.Duration(opt.PacerMinSleep)),
+		pacer.Burst(opt.PacerBurst),
+		pacer.MaxConnectionsOption(ci.MaxConnections),
+		pacer.ShouldRetryOption(shouldRetry),
+	}
+	if ci.NoRetries {
+		options = append(options, pacer.RetriesOption(0))
+	}
+	if opt.PacerAdaptive {
+		options = append(options, pacer.AdaptiveOption(1))
 	}
-	return false, err
+	return pacer.NewGoogleDrive(options...)
+}
+
+// BwLimiter returns the limiter all the transfers go through, so the
+// bandwidth limits can be changed while they are running. It is
+// shared by every Fs, so changing it changes the limits of them all.
+func (f *Fs) BwLimiter() *bwlimit.Limiter {
+	return f.bwLimiter
+}
+
+// PacerStats returns the state of the pacer for monitoring
+func (f *Fs) PacerStats() pacer.Stats {
+	return f.pacer.Stats()
+}
+
+// SetMaxConnections sets the maximum number of API calls made at once.
+// It can be changed while calls are running.
+func (f *Fs) SetMaxConnections(n int) {
+	f.pacer.SetMaxConnections(n)
 }
 
 // parseDrivePath parses a drive 'url' and validates the path
@@ -363,6 +433,23 @@ func newFs(ctx context.Context, name, path string, opt *Options) (*Fs, error) {
 	if opt.ChunkSize < minChunkSize {
 		return nil, fmt.Errorf("chunk size must be at least %s", minChunkSize)
 	}
+	if opt.ChunkSize%minChunkSize != 0 {
+		return nil, fmt.Errorf("chunk size must be a multiple of %s", minChunkSize)
+	}
+	if opt.ListChunk < 1 || opt.ListChunk > maxListChunk {
+		return nil, fmt.Errorf("list chunk must be between 1 and %d", maxListChunk)
+	}
+	if err := checkDuplicatePolicy(opt.DuplicatePolicy); err != nil {
+		return nil, err
+	}
+	timetable, err := bwlimit.Parse(opt.BwLimit)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bwlimit: %w", err)
+	}
+	if opt.BwLimit != "" {
+		// The limits are global, so the last Fs to set them wins
+		sharedBwLimiter.SetTimetable(timetable)
+	}
 
 	// Set default config directory if not provided
 	if opt.ConfigDir == "" {
@@ -407,26 +494,32 @@ func newFs(ctx context.Context, name, path string, opt *Options) (*Fs, error) {
 
 	// Create the Fs object
 	f := &Fs{
-		name: name,
-		root: root,
-		opt:  *opt,
-		pacer: fs.NewPacer(ctx, func(state fs.PacerState) time.Duration {
-			return time.Millisecond * 100
-		}),
+		name:            name,
+		root:            root,
+		opt:             *opt,
+		pacer:           newPacer(ctx, opt),
 		dirResourceKeys: new(sync.Map),
+		shortcutDirs:    new(sync.Map),
 		permissionsMu:   new(sync.Mutex),
 		permissions:     make(map[string]*drive.Permission),
+		bwLimiter:       sharedBwLimiter,
 		logger:          NewLogger(logLevel, logWriter),
 	}
 
 	// Set up features
-	f.features = &fs.Features{
+	f.features = (&fs.Features{
 		DuplicateFiles:          true,
 		ReadMimeType:            true,
 		WriteMimeType:           true,
 		CanHaveEmptyDirectories: true,
 		ServerSideAcrossConfigs: opt.ServerSideAcrossConfigs,
-	}
+		ReadMetadata:            true,
+		WriteMetadata:           true,
+		UserMetadata:            true,
+		ReadDirMetadata:         true,
+		WriteDirMetadata:        true,
+		UserDirMetadata:         true,
+	}).Fill(ctx, f)
 
 	// Set if this is a team drive
 	f.isTeamDrive = opt.TeamDriveID != ""
@@ -476,6 +569,12 @@ func newFs(ctx context.Context, name, path string, opt *Options) (*Fs, error) {
 		f.exportExtensions = strings.Split(opt.ExportFormats, ",")
 	}
 
+	// Parse import formats
+	f.importMimeTypes, err = parseImportFormats(opt.ImportFormats)
+	if err != nil {
+		return nil, err
+	}
+
 	return f, nil
 }
 
@@ -483,14 +582,19 @@ func newFs(ctx context.Context, name, path string, opt *Options) (*Fs, error) {
 func NewFs(ctx context.Context, name, path string, m map[string]string) (fs.Fs, error) {
 	// Parse config into Options struct
 	opt := &Options{
-		Scope:             "drive",
-		ChunkSize:         defaultChunkSize,
-		UploadCutoff:      defaultChunkSize,
-		ExportFormats:     defaultExportExtensions,
-		UseTrash:          true,
-		PacerMinSleep:     defaultMinSleep,
-		PacerBurst:        defaultBurst,
-		V2DownloadMinSize: -1, // Disabled initially
+		Scope:              "drive",
+		ChunkSize:          defaultChunkSize,
+		UploadCutoff:       defaultChunkSize,
+		ExportFormats:      defaultExportExtensions,
+		UseTrash:           true,
+		PacerMinSleep:      defaultMinSleep,
+		PacerBurst:         defaultBurst,
+		ListChunk:          defaultListChunk,
+		PollInterval:       defaultPollInterval,
+		V2DownloadMinSize:  -1, // Disabled initially
+		MultiThreadStreams: defaultMultiThreadStreams,
+		MultiThreadCutoff:  defaultMultiThreadCutoff,
+		DuplicatePolicy:    duplicateError,
 	}
 	// Override with provided config if any
 	if m != nil {
@@ -525,51 +629,187 @@ func NewFs(ctx context.Context, name, path string, m map[string]string) (fs.Fs,
 		if teamDriveID, ok := m["team_drive"]; ok {
 			opt.TeamDriveID = teamDriveID
 		}
+		if listChunk, ok := m["list_chunk"]; ok {
+			n, err := strconv.Atoi(listChunk)
+			if err != nil {
+				return nil, fmt.Errorf("invalid list_chunk %q: %w", listChunk, err)
+			}
+			opt.ListChunk = n
+		}
+		if exportFormats, ok := m["export_formats"]; ok {
+			opt.ExportFormats = exportFormats
+		}
+		if importFormats, ok := m["import_formats"]; ok {
+			opt.ImportFormats = importFormats
+		}
+		if allowImportNameChange, ok := m["allow_import_name_change"]; ok {
+			b, err := strconv.ParseBool(allowImportNameChange)
+			if err != nil {
+				return nil, fmt.Errorf("invalid allow_import_name_change %q: %w", allowImportNameChange, err)
+			}
+			opt.AllowImportNameChange = b
+		}
+		if skipShortcuts, ok := m["skip_shortcuts"]; ok {
+			b, err := strconv.ParseBool(skipShortcuts)
+			if err != nil {
+				return nil, fmt.Errorf("invalid skip_shortcuts %q: %w", skipShortcuts, err)
+			}
+			opt.SkipShortcuts = b
+		}
+		if skipDangling, ok := m["skip_dangling_shortcuts"]; ok {
+			b, err := strconv.ParseBool(skipDangling)
+			if err != nil {
+				return nil, fmt.Errorf("invalid skip_dangling_shortcuts %q: %w", skipDangling, err)
+			}
+			opt.SkipDanglingShortcuts = b
+		}
+		if alternateExport, ok := m["alternate_export"]; ok {
+			b, err := strconv.ParseBool(alternateExport)
+			if err != nil {
+				return nil, fmt.Errorf("invalid alternate_export %q: %w", alternateExport, err)
+			}
+			opt.AlternateExport = b
+		}
+		if streams, ok := m["multi_thread_streams"]; ok {
+			n, err := strconv.Atoi(streams)
+			if err != nil {
+				return nil, fmt.Errorf("invalid multi_thread_streams %q: %w", streams, err)
+			}
+			opt.MultiThreadStreams = n
+		}
+		if cutoff, ok := m["multi_thread_cutoff"]; ok {
+			size, err := fs.ParseSizeSuffix(cutoff)
+			if err != nil {
+				return nil, fmt.Errorf("invalid multi_thread_cutoff %q: %w", cutoff, err)
+			}
+			opt.MultiThreadCutoff = size
+		}
+		if disableChecksum, ok := m["disable_checksum"]; ok {
+			b, err := strconv.ParseBool(disableChecksum)
+			if err != nil {
+				return nil, fmt.Errorf("invalid disable_checksum %q: %w", disableChecksum, err)
+			}
+			opt.DisableChecksum = b
+		}
+		if useTrash, ok := m["use_trash"]; ok {
+			b, err := strconv.ParseBool(useTrash)
+			if err != nil {
+				return nil, fmt.Errorf("invalid use_trash %q: %w", useTrash, err)
+			}
+			opt.UseTrash = b
+		}
+		if trashedOnly, ok := m["trashed_only"]; ok {
+			b, err := strconv.ParseBool(trashedOnly)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trashed_only %q: %w", trashedOnly, err)
+			}
+			opt.TrashedOnly = b
+		}
+		if keepRevision, ok := m["keep_revision_forever"]; ok {
+			b, err := strconv.ParseBool(keepRevision)
+			if err != nil {
+				return nil, fmt.Errorf("invalid keep_revision_forever %q: %w", keepRevision, err)
+			}
+			opt.KeepRevisionForever = b
+		}
+		if duplicatePolicy, ok := m["duplicate_policy"]; ok {
+			opt.DuplicatePolicy = duplicatePolicy
+		}
+		if minSleep, ok := m["pacer_min_sleep"]; ok {
+			d, err := time.ParseDuration(minSleep)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pacer_min_sleep %q: %w", minSleep, err)
+			}
+			opt.PacerMinSleep = fs.Duration(d)
+		}
+		if burst, ok := m["pacer_burst"]; ok {
+			n, err := strconv.Atoi(burst)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pacer_burst %q: %w", burst, err)
+			}
+			opt.PacerBurst = n
+		}
+		if adaptive, ok := m["pacer_adaptive"]; ok {
+			b, err := strconv.ParseBool(adaptive)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pacer_adaptive %q: %w", adaptive, err)
+			}
+			opt.PacerAdaptive = b
+		}
+		if bwLimit, ok := m["bwlimit"]; ok {
+			opt.BwLimit = bwLimit
+		}
+		if sizeAsQuota, ok := m["size_as_quota"]; ok {
+			b, err := strconv.ParseBool(sizeAsQuota)
+			if err != nil {
+				return nil, fmt.Errorf("invalid size_as_quota %q: %w", sizeAsQuota, err)
+			}
+			opt.SizeAsQuota = b
+		}
+		if sessionDir, ok := m["upload_session_dir"]; ok {
+			opt.UploadSessionDir = sessionDir
+		}
+		if pollInterval, ok := m["poll_interval"]; ok {
+			d, err := time.ParseDuration(pollInterval)
+			if err != nil {
+				return nil, fmt.Errorf("invalid poll_interval %q: %w", pollInterval, err)
+			}
+			opt.PollInterval = fs.Duration(d)
+		}
 	}
 
 	return newFs(ctx, name, path, opt)
 }
 
-// FindLeaf implements dircache.DirCacher
+// FindLeaf implements dircache.DirCacher, finding the directory called
+// name in the directory with directoryID
 func (f *Fs) FindLeaf(ctx context.Context, directoryID, name string) (string, bool, error) {
+	return f.findLeaf(ctx, directoryID, name, true)
+}
+
+// isDirItem returns true if item is a directory or a shortcut to one
+// which is followed
+func (f *Fs) isDirItem(item *drive.File) bool {
+	return item.MimeType == driveFolderType ||
+		(item.MimeType == shortcutMimeType && !f.opt.SkipShortcuts &&
+			item.ShortcutDetails != nil && item.ShortcutDetails.TargetMimeType == driveFolderType)
+}
+
+// findLeaf finds the item called name in the directory with
+// directoryID, only looking at directories if dirsOnly is set, so
+// files with the same name as a directory don't count as duplicates
+func (f *Fs) findLeaf(ctx context.Context, directoryID, name string, dirsOnly bool) (string, bool, error) {
 	var query string
 	if directoryID == "" {
 		return "", false, errors.New("internal error: directory ID is blank")
 	}
 
 	// Make the query
-	if f.opt.TrashedOnly {
-		query = fmt.Sprintf("name=%q and trashed=true", name)
-	} else {
-		query = fmt.Sprintf("name=%q and trashed=false", name)
-	}
+	query = fmt.Sprintf("name=%s and %s", quoteQuery(name), f.trashedQuery())
 
 	// Add parent directory filter
-	query = fmt.Sprintf("%s and %q in parents", query, directoryID)
+	query = fmt.Sprintf("%s and %s in parents", query, quoteQuery(directoryID))
 
-	// Add team drive filter if needed
-	if f.isTeamDrive {
-		query = fmt.Sprintf("%s and driveId=%q", query, f.opt.TeamDriveID)
-		if f.opt.SharedWithMe {
-			query = fmt.Sprintf("%s and sharedWithMe=true", query)
-		}
+	// Add shared with me filter if needed
+	if f.isTeamDrive && f.opt.SharedWithMe {
+		query = fmt.Sprintf("%s and sharedWithMe=true", query)
 	}
-	// Search for the file/directory
-	var fields = partialFields
-	var files []*drive.File
-	err := f.pacer.Call(ctx, func() error {
-		var fileList *drive.FileList
-		var err error
 
-		// List the files with the specified query
-		fileList, err = f.svc.Files.List().Q(query).Fields(googleapi.Field(fields)).SupportsAllDrives(f.isTeamDrive).IncludeItemsFromAllDrives(f.isTeamDrive).Do()
-		if err != nil {
-			return err
+	// Search for the file/directory, stopping as soon as a duplicate is
+	// seen unless one of them is to be picked
+	pickDuplicates := f.opt.DuplicatePolicy != "" && f.opt.DuplicatePolicy != duplicateError
+	var files []*drive.File
+	err := f.listPages(ctx, query, func(page []*drive.File) error {
+		for _, item := range page {
+			if !dirsOnly || f.isDirItem(item) {
+				files = append(files, item)
+			}
+		}
+		if len(files) > 1 && !pickDuplicates {
+			return errStopListing
 		}
-		files = fileList.Files
 		return nil
 	})
-
 	if err != nil {
 		return "", false, err
 	}
@@ -578,11 +818,23 @@ func (f *Fs) FindLeaf(ctx context.Context, directoryID, name string) (string, bo
 		return "", false, nil
 	}
 
+	item := files[0]
 	if len(files) > 1 {
-		return "", false, fmt.Errorf("found multiple files with the same name: %q", name)
+		item, err = f.pickDuplicate(name, files)
+		if err != nil {
+			return "", false, err
+		}
 	}
 
-	return files[0].Id, true, nil
+	// Look inside the target of folder shortcuts
+	if item.MimeType == shortcutMimeType && f.isDirItem(item) {
+		f.noteShortcutDir(item)
+		return item.ShortcutDetails.TargetId, true, nil
+	}
+	if item.MimeType == driveFolderType {
+		f.forgetShortcutDir(directoryID, name)
+	}
+	return item.Id, true, nil
 }
 
 // CreateDir makes a directory with pathID as parent and name leaf
@@ -606,110 +858,51 @@ func (f *Fs) CreateDir(ctx context.Context, parentID, leaf string) (id string, e
 
 // List the objects and directories in dir into entries
 func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
-	directoryID, err := f.dirCache.FindDir(ctx, dir)
-	if err != nil {
-		return nil, err
-	}
-
-	var query string
-	if f.opt.TrashedOnly {
-		query = "trashed=true"
-	} else {
-		query = "trashed=false"
-	}
-
-	// Add parent directory filter
-	query = fmt.Sprintf("%s and %q in parents", query, directoryID)
-
-	// Add team drive filter if needed
-	if f.isTeamDrive {
-		query = fmt.Sprintf("%s and driveId=%q", query, f.opt.TeamDriveID)
-	}
-
-	// Add starred filter if needed
-	if f.opt.StarredOnly {
-		query = fmt.Sprintf("%s and starred=true", query)
-	}
-	// Search for files and directories
-	var files []*drive.File
-	err = f.pacer.Call(ctx, func() error {
-		var fileList *drive.FileList
-		var err error
-
-		// List the files with the specified query
-		fileList, err = f.svc.Files.List().Q(query).Fields(googleapi.Field(partialFields)).SupportsAllDrives(f.isTeamDrive).IncludeItemsFromAllDrives(f.isTeamDrive).Do()
-		if err != nil {
-			return err
-		}
-		files = fileList.Files
+	defer translateErrorp(&err, fs.EntryDirectory)
+	err = f.ListP(ctx, dir, func(page fs.DirEntries) error {
+		entries = append(entries, page...)
 		return nil
 	})
-
 	if err != nil {
 		return nil, err
 	}
+	return entries, nil
+}
 
-	// Process the files
-	for _, file := range files {
-		remote := path.Join(dir, file.Name)
-
-		// Skip files we don't want
-		if f.opt.SkipGdocs && isGoogleDocument(file) {
-			continue
-		}
-
-		if file.MimeType == driveFolderType {
-			// Directory
-			d := &Directory{
-				baseObject: baseObject{
-					fs:           f,
-					remote:       remote,
-					id:           file.Id,
-					modifiedDate: file.ModifiedTime,
-					mimeType:     file.MimeType,
-					bytes:        0,
-					parents:      file.Parents,
-				},
-			}
-			entries = append(entries, d)
-		} else {
-			// File
-			o := &Object{
-				baseObject: baseObject{
-					fs:           f,
-					remote:       remote,
-					id:           file.Id,
-					modifiedDate: file.ModifiedTime,
-					mimeType:     file.MimeType,
-					bytes:        file.Size,
-					parents:      file.Parents,
-				},
-				md5sum:     file.Md5Checksum,
-				sha1sum:    file.Sha1Checksum,
-				sha256sum:  file.Sha256Checksum,
-				v2Download: f.opt.V2DownloadMinSize >= 0 && file.Size >= int64(f.opt.V2DownloadMinSize),
-			}
-			entries = append(entries, o)
-		}
+// leafAndDirectoryID splits remote into its leaf name and the ID of
+// the directory containing it
+func (f *Fs) leafAndDirectoryID(ctx context.Context, remote string) (leaf, directoryID string, err error) {
+	dir, leaf := splitPath(remote)
+	directoryID, err = f.dirCache.FindDir(ctx, dir)
+	if err != nil {
+		return "", "", err
 	}
-
-	return entries, nil
+	return leaf, directoryID, nil
 }
 
 // NewObject finds the Object at remote
-func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+func (f *Fs) NewObject(ctx context.Context, remote string) (_ fs.Object, err error) {
+	defer translateErrorp(&err, fs.EntryObject)
 	// Find directory containing the object
-	leaf := path.Base(remote)
-	directoryID, err := f.dirCache.FindDir(ctx, path.Dir(remote))
+	leaf, directoryID, err := f.leafAndDirectoryID(ctx, remote)
 	if err != nil {
 		return nil, err
 	}
 
-	// Find the object in the directory
-	id, found, err := f.FindLeaf(ctx, directoryID, leaf)
+	// Find the object in the directory, or the document it was
+	// exported from
+	id, found, err := f.findLeaf(ctx, directoryID, leaf, false)
 	if err != nil {
 		return nil, err
 	}
+	if !found {
+		if base, ok := f.trimExportExtension(leaf); ok {
+			id, found, err = f.findLeaf(ctx, directoryID, base, false)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
 	if !found {
 		return nil, fs.ErrorObjectNotFound
 	}
@@ -724,96 +917,114 @@ func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
 	}
 
 	// Create the object
-	if info.MimeType == driveFolderType {
-		return nil, fs.ErrorIsDir
+	dir, _ := splitPath(remote)
+	entry, err := f.itemToDirEntry(ctx, path.Join(dir, info.Name), info, nil)
+	if err != nil {
+		return nil, err
 	}
-
-	// Create the object
-	o := &Object{
-		baseObject: baseObject{
-			fs:           f,
-			remote:       remote,
-			id:           info.Id,
-			modifiedDate: info.ModifiedTime,
-			mimeType:     info.MimeType,
-			bytes:        info.Size,
-			parents:      info.Parents,
-		},
-		md5sum:     info.Md5Checksum,
-		sha1sum:    info.Sha1Checksum,
-		sha256sum:  info.Sha256Checksum,
-		v2Download: f.opt.V2DownloadMinSize >= 0 && info.Size >= int64(f.opt.V2DownloadMinSize),
+	switch entry := entry.(type) {
+	case *Directory:
+		return nil, fs.ErrorIsDir
+	case fs.Object:
+		if entry.Remote() == remote {
+			return entry, nil
+		}
 	}
-
-	return o, nil
+	return nil, fs.ErrorObjectNotFound
 }
 
 // Put uploads a file
-func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
-	// Create temporary file to upload
+func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (_ fs.Object, err error) {
+	defer translateErrorp(&err, fs.EntryObject)
 	size := src.Size()
-	if size < 0 {
-		size = 0
+
+	remote := src.Remote()
+	srcMimeType := mimeTypeOf(ctx, src)
+
+	// Convert into a Google document if required, dropping the extension
+	importMimeType, importSrcMimeType, extLen := f.findImportFormat(ctx, src)
+	if importMimeType != "" {
+		remote = remote[:len(remote)-extLen]
+		srcMimeType = importSrcMimeType
 	}
 
 	// Get the directory to upload to
-	leaf := path.Base(src.Remote())
-	directoryID, err := f.dirCache.FindDir(ctx, path.Dir(src.Remote()))
+	leaf, directoryID, err := f.leafAndDirectoryID(ctx, remote)
 	if err != nil {
 		return nil, err
 	}
 
 	createInfo := &drive.File{
-		Name:    leaf,
-		Parents: []string{directoryID},
+		Name:     leaf,
+		Parents:  []string{directoryID},
+		MimeType: importMimeType,
 	}
 
 	// Set modification time
 	modTime := src.ModTime(ctx)
 	createInfo.ModifiedTime = modTime.Format(timeFormatOut)
 
+	// Apply any metadata passed in, keeping the document type of imports
+	permissions, err := updateInfoFromMetadata(createInfo, fs.GetMetadataOptions(options))
+	if err != nil {
+		return nil, err
+	}
+	if importMimeType != "" {
+		createInfo.MimeType = importMimeType
+	}
+
+	in = f.bwLimiter.Upload(ctx, in)
+
+	// Calculate the checksums as the data is sent. Imports are
+	// converted so can't be checked.
+	var sums *checksumReader
+	if !f.opt.DisableChecksum && importMimeType == "" {
+		sums = newChecksumReader(in)
+		in = sums
+	}
+
 	// Determine upload strategy based on file size
 	var info *drive.File
-	if size > int64(f.opt.UploadCutoff) {
-		// Upload in chunks
-		info, err = f.uploadChunked(ctx, in, size, createInfo)
+	if size < 0 || size > int64(f.opt.UploadCutoff) {
+		// Upload in chunks, streaming until EOF if the size isn't known
+		info, err = f.uploadResumable(ctx, in, size, srcMimeType, "", src.Remote(), createInfo)
 	} else {
 		// Simple upload
-		info, err = f.upload(ctx, in, size, createInfo)
+		info, err = f.upload(ctx, in, srcMimeType, createInfo)
 	}
 
 	if err != nil {
 		return nil, err
 	}
+	if sums != nil {
+		if err := f.checkUpload(ctx, sums, src.Remote(), info); err != nil {
+			return nil, err
+		}
+	}
+	if err := f.addPermissions(ctx, info.Id, permissions); err != nil {
+		return nil, err
+	}
 
 	// Create a new object from the response
-	o := &Object{
-		baseObject: baseObject{
-			fs:           f,
-			remote:       src.Remote(),
-			id:           info.Id,
-			modifiedDate: info.ModifiedTime,
-			mimeType:     info.MimeType,
-			bytes:        info.Size,
-			parents:      info.Parents,
-		},
-		md5sum:     info.Md5Checksum,
-		sha1sum:    info.Sha1Checksum,
-		sha256sum:  info.Sha256Checksum,
-		v2Download: f.opt.V2DownloadMinSize >= 0 && info.Size >= int64(f.opt.V2DownloadMinSize),
+	o := f.newObjectFromInfo(remote, info)
+	if o == nil {
+		return nil, fmt.Errorf("uploaded %q but it can't be read back", src.Remote())
 	}
-
 	return o, nil
 }
 
 // upload uploads a file using a simple method
-func (f *Fs) upload(ctx context.Context, in io.Reader, size int64, createInfo *drive.File) (*drive.File, error) {
-	var info *drive.File
-	var err error
+func (f *Fs) upload(ctx context.Context, in io.Reader, contentType string, createInfo *drive.File) (*drive.File, error) {
+	// Read it all first so a retry can send the data again
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read upload: %w", err)
+	}
 
+	var info *drive.File
 	err = f.pacer.Call(ctx, func() error {
 		info, err = f.svc.Files.Create(createInfo).
-			Media(in, googleapi.ContentType("")).
+			Media(bytes.NewReader(data), googleapi.ContentType(contentType)).
 			Fields(googleapi.Field(partialFields)).
 			SupportsAllDrives(f.isTeamDrive).
 			KeepRevisionForever(f.opt.KeepRevisionForever).
@@ -828,20 +1039,15 @@ func (f *Fs) upload(ctx context.Context, in io.Reader, size int64, createInfo *d
 	return info, nil
 }
 
-// uploadChunked uploads a file using a chunked upload protocol
-func (f *Fs) uploadChunked(ctx context.Context, in io.Reader, size int64, createInfo *drive.File) (*drive.File, error) {
-	// Call the detailed chunked upload implementation
-	return f.uploadChunkedDetailed(ctx, in, size, createInfo)
-}
-
 // Mkdir creates a directory
-func (f *Fs) Mkdir(ctx context.Context, dir string) error {
-	_, err := f.dirCache.FindDir(ctx, dir)
+func (f *Fs) Mkdir(ctx context.Context, dir string) (err error) {
+	defer translateErrorp(&err, fs.EntryDirectory)
+	_, err = f.dirCache.FindDir(ctx, dir)
 	if err == nil {
 		// Directory already exists
 		return nil
 	}
-	if err != fs.ErrorDirNotFound {
+	if !errors.Is(err, fs.ErrorDirNotFound) {
 		return err
 	}
 
@@ -851,7 +1057,16 @@ func (f *Fs) Mkdir(ctx context.Context, dir string) error {
 }
 
 // Rmdir removes a directory
-func (f *Fs) Rmdir(ctx context.Context, dir string) error {
+func (f *Fs) Rmdir(ctx context.Context, dir string) (err error) {
+	defer translateErrorp(&err, fs.EntryDirectory)
+	// Remove shortcuts to directories whatever they point at
+	shortcutID, err := f.dirShortcutID(ctx, dir)
+	if err != nil {
+		return err
+	}
+	if shortcutID != "" {
+		return f.removeDirShortcut(ctx, dir, shortcutID)
+	}
 	directoryID, err := f.dirCache.FindDir(ctx, dir)
 	if err != nil {
 		return err
@@ -877,7 +1092,7 @@ func (f *Fs) Rmdir(ctx context.Context, dir string) error {
 	}
 
 	// Remove from directory cache
-	f.dirCache.Put("", "")
+	f.dirCache.FlushDir(dir)
 	return nil
 }
 
@@ -62,6 +62,7 @@ func createTestFs(t *testing.T) (fs.Fs, func()) {
 		if purger, ok := driveFs.(fs.Purger); ok {
 			_ = purger.Purge(ctx, "")
 		}
+		_ = driveFs.Rmdir(ctx, "")
 	}
 
 	return driveFs, cleanup
@@ -8,6 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
@@ -33,91 +34,176 @@ var (
 	ErrorAuthorizationFailed   = errors.New("authorization failed")
 )
 
-// shouldRetry returns a boolean as to whether this err deserves to be retried
-func shouldRetry(ctx context.Context, err error) (bool, error) {
-	if err == nil {
-		return false, nil
+// ErrorClass says how an error from Drive should be dealt with
+type ErrorClass int
+
+// Error classes
+const (
+	ClassNoRetry     ErrorClass = iota // this call failed but others may work
+	ClassRetriable                     // the call may work if it is tried again
+	ClassRateLimited                   // the call may work if it is tried again after a delay
+	ClassFatal                         // no call will work until something is fixed, eg the quota
+)
+
+// Errors to test the class of an error against with errors.Is
+var (
+	ErrNoRetry     = errors.New("not retriable")
+	ErrRetriable   = errors.New("retriable")
+	ErrRateLimited = errors.New("rate limited")
+	ErrFatal       = errors.New("fatal")
+)
+
+// Error is an error from Drive with its class.
+//
+// errors.Is matches it with the sentinel for its class, and rate
+// limited errors also match ErrRetriable.
+type Error struct {
+	Class      ErrorClass
+	RetryAfter time.Duration // how long Drive asked us to wait, if it said
+	Err        error         // the error classified
+}
+
+// Error returns the message of the error classified
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the error classified
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is returns true if target is the sentinel for the class of e
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrNoRetry:
+		return e.Class == ClassNoRetry
+	case ErrRetriable:
+		return e.Class == ClassRetriable || e.Class == ClassRateLimited
+	case ErrRateLimited:
+		return e.Class == ClassRateLimited
+	case ErrFatal:
+		return e.Class == ClassFatal
 	}
+	return false
+}
 
-	// Check for specific Google API errors
-	var gerr *googleapi.Error
-	if errors.As(err, &gerr) {
-		// Rate limiting errors
-		if gerr.Code == 403 && strings.Contains(gerr.Message, "User Rate Limit Exceeded") {
-			return true, err
-		}
-		if gerr.Code == 403 && strings.Contains(gerr.Message, "Rate Limit Exceeded") {
-			return true, err
-		}
-		if gerr.Code == 403 && strings.Contains(gerr.Message, "rateLimitExceeded") {
-			return true, err
-		}
+// Retry returns true if the call which returned e should be retried
+func (e *Error) Retry() bool {
+	return e.Class == ClassRetriable || e.Class == ClassRateLimited
+}
 
-		// Quota exceeded errors
-		if gerr.Code == 403 && strings.Contains(gerr.Message, "userRateLimitExceeded") {
-			return false, fs.ErrorLimitExceeded
-		}
-		if gerr.Code == 403 && strings.Contains(gerr.Message, "Quota exceeded") {
-			return false, fs.ErrorLimitExceeded
-		}
+// RetryDelay returns how long Drive asked us to wait before retrying,
+// or 0 if it didn't say, so the pacer can honour it
+func (e *Error) RetryDelay() time.Duration {
+	return e.RetryAfter
+}
 
-		// Server error codes
-		if gerr.Code == 500 || gerr.Code == 502 || gerr.Code == 503 || gerr.Code == 504 {
-			return true, err
-		}
+// RateLimited returns true if e is a rate limit, so the pacer can cut
+// the connections in adaptive mode
+func (e *Error) RateLimited() bool {
+	return e.Class == ClassRateLimited
+}
 
-		// Additional specific error handling
-		if gerr.Code == 404 && strings.Contains(gerr.Message, "File not found") {
-			return false, fs.ErrorObjectNotFound
-		}
-		if gerr.Code == 400 && strings.Contains(gerr.Message, "Bad Request") {
-			return false, err
-		}
-		if gerr.Code == 401 {
-			return false, err
-		}
+// Reasons given by Drive for errors
+var (
+	rateLimitReasons = map[string]bool{
+		"rateLimitExceeded":     true,
+		"userRateLimitExceeded": true,
+	}
+	quotaReasons = map[string]bool{
+		"quotaExceeded":                     true,
+		"storageQuotaExceeded":              true,
+		"downloadQuotaExceeded":             true,
+		"dailyLimitExceeded":                true,
+		"teamDriveFileLimitExceeded":        true,
+		"numChildrenInNonRootLimitExceeded": true,
 	}
+)
+
+// Messages of network errors which are worth retrying
+var retriableMessages = []string{
+	"http: can't write HTTP request on broken connection",
+	"net/http: timeout awaiting response headers",
+	"net/http: TLS handshake timeout",
+	"connection reset by peer",
+	"broken pipe",
+	"unexpected EOF",
+}
 
-	// Check context errors
-	if errors.Is(err, context.Canceled) {
-		return false, err
+// classifyError returns err wrapped in an *Error saying how to deal
+// with it, or nil if err is nil. Errors which are already classified
+// are returned as they are.
+func classifyError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	var classified *Error
+	if errors.As(err, &classified) {
+		return err
 	}
-	if errors.Is(err, context.DeadlineExceeded) {
-		return true, err
+	class := func(class ErrorClass) error {
+		return &Error{Class: class, Err: err}
 	}
 
-	// Check for IO timeouts
-	if errors.Is(err, io.EOF) {
-		return true, err
+	// Nothing is worth retrying once the context is done
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return class(ClassNoRetry)
 	}
 
-	// Generic HTTP errors
-	if strings.Contains(err.Error(), "429 Too Many Requests") {
-		return true, err
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		reason := ""
+		if len(gerr.Errors) > 0 {
+			reason = gerr.Errors[0].Reason
+		}
+		switch {
+		case rateLimitReasons[reason] || gerr.Code == http.StatusTooManyRequests:
+			return &Error{Class: ClassRateLimited, RetryAfter: parseRateLimit(gerr.Header), Err: err}
+		case quotaReasons[reason]:
+			return &Error{Class: ClassFatal, Err: fmt.Errorf("%w: %w", fs.ErrorLimitExceeded, err)}
+		case gerr.Code == http.StatusUnauthorized:
+			return class(ClassFatal)
+		case gerr.Code >= 500:
+			return &Error{Class: ClassRetriable, RetryAfter: parseRateLimit(gerr.Header), Err: err}
+		}
+		return class(ClassNoRetry)
 	}
-	if strings.Contains(err.Error(), "http: can't write HTTP request on broken connection") {
-		return true, err
+
+	if errors.Is(err, ErrorGoogleDriveTokenEmpty) || strings.Contains(err.Error(), "invalid_grant") {
+		return class(ClassFatal)
 	}
-	if strings.Contains(err.Error(), "net/http: timeout awaiting response headers") {
-		return true, err
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return class(ClassRetriable)
 	}
-	if strings.Contains(err.Error(), "net/http: TLS handshake timeout") {
-		return true, err
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return class(ClassRetriable)
 	}
-	if strings.Contains(err.Error(), "connection reset by peer") {
-		return true, err
+	for _, message := range retriableMessages {
+		if strings.Contains(err.Error(), message) {
+			return class(ClassRetriable)
+		}
 	}
-
-	// Default: don't retry
-	return false, err
+	return class(ClassNoRetry)
 }
 
-// parseRateLimit parses a rate limit header returning the duration to wait or 0 if not parsed
-func parseRateLimit(resp *http.Response) time.Duration {
-	if resp == nil {
-		return 0
+// shouldRetry returns whether the call which returned err should be
+// retried, and err classified
+func shouldRetry(ctx context.Context, err error) (bool, error) {
+	if err == nil {
+		return false, nil
 	}
-	retryAfter := resp.Header.Get("Retry-After")
+	err = classifyError(ctx, err)
+	var classified *Error
+	errors.As(err, &classified)
+	return classified.Retry(), err
+}
+
+// parseRateLimit parses the Retry-After header returning the duration
+// to wait or 0 if not parsed
+func parseRateLimit(header http.Header) time.Duration {
+	retryAfter := header.Get("Retry-After")
 	if retryAfter == "" {
 		return 0
 	}
@@ -129,7 +215,7 @@ func parseRateLimit(resp *http.Response) time.Duration {
 
 	// Try parsing as a date format
 	if date, err := http.ParseTime(retryAfter); err == nil {
-		waitTime := date.Sub(time.Now())
+		waitTime := time.Until(date)
 		if waitTime > 0 {
 			return waitTime
 		}
@@ -139,47 +225,48 @@ func parseRateLimit(resp *http.Response) time.Duration {
 	return 0
 }
 
-// translateError converts API errors into standard fs errors if possible
-func translateError(err error, item string) error {
+// translateError converts API errors into standard fs errors if
+// possible, keeping the original error and its class so both can be
+// found with errors.Is and errors.As.
+//
+// A 404 becomes fs.ErrorDirNotFound if entryType is fs.EntryDirectory
+// and fs.ErrorObjectNotFound otherwise.
+func translateError(err error, entryType fs.EntryType) error {
 	if err == nil {
 		return nil
 	}
-
-	// Check for Google API errors
 	var gerr *googleapi.Error
-	if errors.As(err, &gerr) {
-		switch gerr.Code {
-		case 400:
-			return fmt.Errorf("%s: bad request: %w", item, err)
-		case 401:
-			return fmt.Errorf("%s: unauthorized: %w", item, fs.ErrorPermissionDenied)
-		case 403:
-			// Check for specific error messages
-			if strings.Contains(gerr.Message, "Rate Limit Exceeded") {
-				return fmt.Errorf("%s: rate limit exceeded: %w", item, err)
-			}
-			if strings.Contains(gerr.Message, "User Rate Limit Exceeded") {
-				return fmt.Errorf("%s: user rate limit exceeded: %w", item, err)
-			}
-			if strings.Contains(gerr.Message, "Quota exceeded") {
-				return fmt.Errorf("%s: quota exceeded: %w", item, fs.ErrorLimitExceeded)
-			}
-			return fmt.Errorf("%s: access denied: %w", item, fs.ErrorPermissionDenied)
-		case 404:
-			return fs.ErrorObjectNotFound
-		case 409:
-			return fmt.Errorf("%s: conflict: %w", item, err)
-		case 410:
-			return fmt.Errorf("%s: item gone: %w", item, err)
-		case 500:
-			return fmt.Errorf("%s: internal server error: %w", item, err)
-		}
+	if !errors.As(err, &gerr) {
+		return err
 	}
 
-	// Keep the original error if no translation
+	// Classify errors which didn't come through the pacer, so rate
+	// limits aren't taken for permission problems
+	err = classifyError(context.Background(), err)
+	for _, translated := range []error{fs.ErrorObjectNotFound, fs.ErrorDirNotFound, fs.ErrorPermissionDenied, fs.ErrorLimitExceeded} {
+		if errors.Is(err, translated) {
+			return err
+		}
+	}
+	switch {
+	case gerr.Code == http.StatusNotFound && entryType == fs.EntryDirectory:
+		return fmt.Errorf("%w: %w", fs.ErrorDirNotFound, err)
+	case gerr.Code == http.StatusNotFound:
+		return fmt.Errorf("%w: %w", fs.ErrorObjectNotFound, err)
+	case gerr.Code == http.StatusUnauthorized:
+		return fmt.Errorf("%w: %w", fs.ErrorPermissionDenied, err)
+	case gerr.Code == http.StatusForbidden && !errors.Is(err, ErrRetriable):
+		return fmt.Errorf("%w: %w", fs.ErrorPermissionDenied, err)
+	}
 	return err
 }
 
+// translateErrorp translates the error *perr points to, so methods
+// can translate all the errors they return with a defer
+func translateErrorp(perr *error, entryType fs.EntryType) {
+	*perr = translateError(*perr, entryType)
+}
+
 // ProcessError extracts a more meaningful error from the googleapi error
 func ProcessError(err error) error {
 	if err == nil {
@@ -0,0 +1,172 @@
+package drive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/standalone-gdrive/fs"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// apiError makes an error like the ones the Drive client returns
+func apiError(code int, reason string, header http.Header) error {
+	gerr := &googleapi.Error{Code: code, Message: reason, Header: header}
+	if reason != "" {
+		gerr.Errors = []googleapi.ErrorItem{{Reason: reason}}
+	}
+	return fmt.Errorf("wrapped: %w", gerr)
+}
+
+func TestClassifyError(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, test := range []struct {
+		ctx   context.Context
+		err   error
+		class error
+	}{
+		{context.Background(), apiError(403, "userRateLimitExceeded", nil), ErrRateLimited},
+		{context.Background(), apiError(429, "", nil), ErrRateLimited},
+		{context.Background(), apiError(503, "backendError", nil), ErrRetriable},
+		{context.Background(), apiError(403, "storageQuotaExceeded", nil), ErrFatal},
+		{context.Background(), apiError(401, "authError", nil), ErrFatal},
+		{context.Background(), apiError(404, "notFound", nil), ErrNoRetry},
+		{context.Background(), apiError(403, "insufficientFilePermissions", nil), ErrNoRetry},
+		{context.Background(), io.ErrUnexpectedEOF, ErrRetriable},
+		{context.Background(), errors.New("read: connection reset by peer"), ErrRetriable},
+		{context.Background(), errors.New("something else"), ErrNoRetry},
+		{cancelled, apiError(503, "backendError", nil), ErrNoRetry},
+	} {
+		err := classifyError(test.ctx, test.err)
+		if !errors.Is(err, test.class) {
+			t.Errorf("%v: got %#v, want %v", test.err, err, test.class)
+		}
+		if err.Error() != test.err.Error() && !errors.Is(err, fs.ErrorLimitExceeded) {
+			t.Errorf("%v: message changed to %v", test.err, err)
+		}
+		var gerr *googleapi.Error
+		if errors.As(test.err, &gerr) && !errors.As(err, &gerr) {
+			t.Errorf("%v: lost the API error", test.err)
+		}
+	}
+	if err := classifyError(context.Background(), apiError(403, "quotaExceeded", nil)); !errors.Is(err, fs.ErrorLimitExceeded) {
+		t.Errorf("quota error %v isn't fs.ErrorLimitExceeded", err)
+	}
+	if classifyError(context.Background(), nil) != nil {
+		t.Error("nil was classified")
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	err := classifyError(context.Background(), apiError(429, "", http.Header{"Retry-After": {"7"}}))
+	if got := err.(*Error).RetryDelay(); got != 7*time.Second {
+		t.Errorf("got %v, want 7s", got)
+	}
+	when := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if got := parseRateLimit(http.Header{"Retry-After": {when}}); got < 50*time.Second || got > time.Minute {
+		t.Errorf("got %v for %s", got, when)
+	}
+	if got := parseRateLimit(http.Header{}); got != 0 {
+		t.Errorf("got %v without a header", got)
+	}
+}
+
+func TestTranslateError(t *testing.T) {
+	notFound := apiError(404, "notFound", nil)
+	if err := translateError(notFound, fs.EntryDirectory); !errors.Is(err, fs.ErrorDirNotFound) {
+		t.Errorf("directory 404 gave %v", err)
+	}
+	err := translateError(classifyError(context.Background(), notFound), fs.EntryObject)
+	if !errors.Is(err, fs.ErrorObjectNotFound) || !errors.Is(err, ErrNoRetry) {
+		t.Errorf("object 404 gave %v", err)
+	}
+	if err := translateError(apiError(403, "insufficientFilePermissions", nil), fs.EntryObject); !errors.Is(err, fs.ErrorPermissionDenied) {
+		t.Errorf("403 gave %v", err)
+	}
+	limited := classifyError(context.Background(), apiError(403, "rateLimitExceeded", nil))
+	if err := translateError(limited, fs.EntryObject); errors.Is(err, fs.ErrorPermissionDenied) {
+		t.Errorf("rate limit gave %v", err)
+	}
+
+	// Errors which didn't come through the pacer are classified first
+	err = translateError(apiError(403, "userRateLimitExceeded", nil), fs.EntryObject)
+	if errors.Is(err, fs.ErrorPermissionDenied) || !errors.Is(err, ErrRateLimited) {
+		t.Errorf("unclassified rate limit gave %v", err)
+	}
+	err = translateError(apiError(403, "storageQuotaExceeded", nil), fs.EntryObject)
+	if errors.Is(err, fs.ErrorPermissionDenied) || !errors.Is(err, fs.ErrorLimitExceeded) {
+		t.Errorf("unclassified quota error gave %v", err)
+	}
+}
+
+func TestTeamDriveCallsArePaced(t *testing.T) {
+	calls := 0
+	f := newTestFs(t, Options{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			http.Error(w, `{"error":{"code":403,"errors":[{"reason":"userRateLimitExceeded"}]}}`, http.StatusForbidden)
+			return
+		}
+		writeJSON(w, &drive.Drive{Id: "td", Name: "Team"})
+	}))
+	td, err := f.GetTeamDrive(context.Background(), "td")
+	if err != nil {
+		t.Fatalf("GetTeamDrive failed: %v", err)
+	}
+	if td.Name != "Team" || calls != 2 {
+		t.Errorf("got %+v after %d calls, want the rate limit retried", td, calls)
+	}
+}
+
+func TestPacerHonoursRetryAfter(t *testing.T) {
+	var calls []time.Time
+	f := newTestFs(t, Options{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, time.Now())
+		if len(calls) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		writeJSON(w, &drive.File{Id: "id"})
+	}))
+	o := f.newObjectWithInfo("file.txt", &drive.File{Id: "id"})
+
+	if err := o.SetModTime(context.Background(), time.Now()); err != nil {
+		t.Fatalf("SetModTime failed: %v", err)
+	}
+	if len(calls) != 2 {
+		t.Fatalf("got %d calls, want 2", len(calls))
+	}
+	if wait := calls[1].Sub(calls[0]); wait < time.Second {
+		t.Errorf("retried after %v, want at least 1s", wait)
+	}
+}
+
+func TestNotFoundIsNotRetried(t *testing.T) {
+	calls := 0
+	f := newTestFs(t, Options{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, `{"error":{"code":404,"errors":[{"reason":"notFound"}]}}`, http.StatusNotFound)
+	}))
+
+	o := f.newObjectWithInfo("file.txt", &drive.File{Id: "gone"})
+	err := o.SetModTime(context.Background(), time.Now())
+	if !errors.Is(err, fs.ErrorObjectNotFound) || !errors.Is(err, ErrNoRetry) {
+		t.Errorf("got %v, want object not found", err)
+	}
+	if calls != 1 {
+		t.Errorf("got %d calls, want 1", calls)
+	}
+
+	d := f.newDirectory("dir", &drive.File{Id: "gone"})
+	if _, err := d.Metadata(context.Background()); !errors.Is(err, fs.ErrorDirNotFound) {
+		t.Errorf("got %v, want directory not found", err)
+	}
+}
@@ -0,0 +1,275 @@
+// Package drive implements a Google Drive client for standalone usage
+//
+// This file contains the export of Google Workspace documents
+package drive
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/standalone-gdrive/fs"
+	"github.com/standalone-gdrive/fs/hash"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Object    = (*documentObject)(nil)
+	_ fs.MimeTyper = (*documentObject)(nil)
+	_ fs.IDer      = (*documentObject)(nil)
+	_ fs.Object    = (*linkObject)(nil)
+	_ fs.MimeTyper = (*linkObject)(nil)
+	_ fs.IDer      = (*linkObject)(nil)
+)
+
+// extensionToMimeType maps the extensions in ExportFormats to the MIME
+// type requested from the export
+var extensionToMimeType = map[string]string{
+	"bmp":  "image/bmp",
+	"csv":  "text/csv",
+	"doc":  "application/msword",
+	"docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	"epub": "application/epub+zip",
+	"html": "text/html",
+	"jpg":  "image/jpeg",
+	"json": "application/vnd.google-apps.script+json",
+	"md":   "text/markdown",
+	"odp":  "application/vnd.oasis.opendocument.presentation",
+	"ods":  "application/vnd.oasis.opendocument.spreadsheet",
+	"odt":  "application/vnd.oasis.opendocument.text",
+	"pdf":  "application/pdf",
+	"png":  "image/png",
+	"pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	"rtf":  "application/rtf",
+	"svg":  "image/svg+xml",
+	"tsv":  "text/tab-separated-values",
+	"txt":  "text/plain",
+	"xls":  "application/vnd.ms-excel",
+	"xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	"zip":  "application/zip",
+}
+
+// linkTemplate makes the content of a link file pointing at a URL
+type linkTemplate struct {
+	mimeType string
+	make     func(name, url string) string
+}
+
+// linkTemplates are the link files which can be made for documents
+// which can't be exported, keyed by extension
+var linkTemplates = map[string]linkTemplate{
+	"url": {"application/x-url", func(name, url string) string {
+		return "[InternetShortcut]\r\nURL=" + url + "\r\n"
+	}},
+	"desktop": {"application/x-freedesktop", func(name, url string) string {
+		return "[Desktop Entry]\nEncoding=UTF-8\nName=" + name + "\nURL=" + url + "\nIcon=text-html\nType=Link\n"
+	}},
+	"link.html": {"text/html", func(name, url string) string {
+		url = html.EscapeString(url)
+		return "<html><head><meta http-equiv=\"refresh\" content=\"0; url=" + url + "\" /><title>" +
+			html.EscapeString(name) + "</title></head><body>Loading <a href=\"" + url + "\">" +
+			html.EscapeString(name) + "</a></body></html>\n"
+	}},
+}
+
+// findExportFormat returns the extension and MIME type to export the
+// document info as, using the first of the configured export
+// extensions it supports. isLink is set if a link file should be made
+// instead. extension is "" if the document can't be exported.
+func (f *Fs) findExportFormat(info *drive.File) (extension, exportMimeType string, isLink bool) {
+	for _, extension := range f.exportExtensions {
+		if mimeType, ok := extensionToMimeType[extension]; ok {
+			if _, ok := info.ExportLinks[mimeType]; ok {
+				return extension, mimeType, false
+			}
+		}
+		if link, ok := linkTemplates[extension]; ok && info.WebViewLink != "" {
+			return extension, link.mimeType, true
+		}
+	}
+	return "", "", false
+}
+
+// newDocumentOrLink creates an object for the Google document info
+// found at remote, adding the export extension to its name.
+//
+// It returns nil if the document can't be exported in any of the
+// configured formats.
+func (f *Fs) newDocumentOrLink(remote string, info *drive.File) fs.Object {
+	extension, exportMimeType, isLink := f.findExportFormat(info)
+	if extension == "" {
+		f.LogDebug("%q: no export format for %q, skipping", remote, info.MimeType)
+		return nil
+	}
+	base := baseObject{
+		fs:           f,
+		remote:       remote + "." + extension,
+		id:           info.Id,
+		modifiedDate: info.ModifiedTime,
+		mimeType:     exportMimeType,
+		bytes:        -1,
+		parents:      info.Parents,
+	}
+	if isLink {
+		content := []byte(linkTemplates[extension].make(info.Name, info.WebViewLink))
+		base.bytes = int64(len(content))
+		return &linkObject{
+			baseObject: base,
+			content:    content,
+			extLen:     len(extension) + 1,
+		}
+	}
+	return &documentObject{
+		baseObject:       base,
+		url:              info.ExportLinks[exportMimeType],
+		documentMimeType: info.MimeType,
+		extLen:           len(extension) + 1,
+	}
+}
+
+// newObjectFromInfo creates the right kind of object for info found at
+// remote, returning nil if it should be skipped
+func (f *Fs) newObjectFromInfo(remote string, info *drive.File) fs.Object {
+	if isGoogleDocument(info) && !isShortcutType(info.MimeType) {
+		if f.opt.SkipGdocs {
+			return nil
+		}
+		return f.newDocumentOrLink(remote, info)
+	}
+	return f.newObjectWithInfo(remote, info)
+}
+
+// trimExportExtension returns leaf without an export extension, and
+// true if it had one
+func (f *Fs) trimExportExtension(leaf string) (string, bool) {
+	for _, extension := range f.exportExtensions {
+		if base := strings.TrimSuffix(leaf, "."+extension); base != leaf && base != "" {
+			return base, true
+		}
+	}
+	return leaf, false
+}
+
+// errDocumentUpdate is returned when trying to update an exported
+// document with something which can't be imported into it
+var errDocumentUpdate = errors.New("can't update an exported google document without importing it")
+
+// ------------------------------------------------------------
+// documentObject methods
+
+// String returns a description of the object
+func (o *documentObject) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Size returns -1 as the size of an export isn't known until it is
+// downloaded
+func (o *documentObject) Size() int64 {
+	return o.bytes
+}
+
+// Hash returns "" as exports have no checksums
+func (o *documentObject) Hash(ctx context.Context, t hash.Type) (string, error) {
+	return "", nil
+}
+
+// MimeType returns the MIME type the document is exported as
+func (o *documentObject) MimeType(ctx context.Context) string {
+	return o.mimeType
+}
+
+// Storable returns whether this object is storable
+func (o *documentObject) Storable() bool {
+	return true
+}
+
+// Open exports the document in the chosen format.
+//
+// The export link is used unless AlternateExport is set or there isn't
+// one, in which case the document is exported with Files.Export.
+func (o *documentObject) Open(ctx context.Context, options ...fs.OpenOption) (_ io.ReadCloser, err error) {
+	defer translateErrorp(&err, fs.EntryObject)
+	var resp *http.Response
+	if o.url == "" || o.fs.opt.AlternateExport {
+		err = o.fs.pacer.Call(ctx, func() (err error) {
+			resp, err = o.fs.svc.Files.Export(o.id, o.mimeType).Context(ctx).Download()
+			return err
+		})
+	} else {
+		err = o.fs.pacer.Call(ctx, func() (err error) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.url, nil)
+			if err != nil {
+				return err
+			}
+			resp, err = o.fs.client.Do(req)
+			if err != nil {
+				return err
+			}
+			if err := googleapi.CheckResponse(resp); err != nil {
+				_ = resp.Body.Close()
+				return err
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't export %q: %w", o.remote, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, fmt.Errorf("couldn't export %q: bad response: %d: %s", o.remote, resp.StatusCode, resp.Status)
+	}
+	return o.fs.bwLimiter.Download(ctx, resp.Body), nil
+}
+
+// ------------------------------------------------------------
+// linkObject methods
+
+// String returns a description of the object
+func (o *linkObject) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Size returns the size of the link file
+func (o *linkObject) Size() int64 {
+	return o.bytes
+}
+
+// Hash returns "" as link files have no checksums
+func (o *linkObject) Hash(ctx context.Context, t hash.Type) (string, error) {
+	return "", nil
+}
+
+// MimeType returns the MIME type of the link file
+func (o *linkObject) MimeType(ctx context.Context) string {
+	return o.mimeType
+}
+
+// Storable returns whether this object is storable
+func (o *linkObject) Storable() bool {
+	return true
+}
+
+// Open returns the generated link file
+func (o *linkObject) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(o.content)), nil
+}
+
+// Update isn't supported for link files
+func (o *linkObject) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	return errDocumentUpdate
+}
@@ -0,0 +1,123 @@
+package drive
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+)
+
+const docxMimeType = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+
+// exportServer serves a directory containing a document, a form and a
+// plain file, and the export of the document
+func exportServer(t *testing.T, exported *string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/files", func(w http.ResponseWriter, r *http.Request) {
+		files := []*drive.File{
+			{Id: "doc", Name: "report", MimeType: "application/vnd.google-apps.document",
+				ExportLinks: map[string]string{docxMimeType: "http://" + r.Host + "/export/doc"}},
+			{Id: "form", Name: "survey", MimeType: "application/vnd.google-apps.form",
+				WebViewLink: "https://docs.google.com/forms/d/form/edit"},
+			{Id: "file", Name: "a.txt", MimeType: "text/plain", Size: 3},
+		}
+		// Answer FindLeaf queries with the matching file only
+		if q := r.URL.Query().Get("q"); strings.Contains(q, "name=") {
+			var match []*drive.File
+			for _, file := range files {
+				if strings.Contains(q, "name='"+file.Name+"'") {
+					match = append(match, file)
+				}
+			}
+			files = match
+		}
+		writeJSON(w, &drive.FileList{Files: files})
+	})
+	mux.HandleFunc("/files/doc", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, &drive.File{Id: "doc", Name: "report", MimeType: "application/vnd.google-apps.document",
+			ExportLinks: map[string]string{docxMimeType: "http://" + r.Host + "/export/doc"}})
+	})
+	mux.HandleFunc("/export/doc", func(w http.ResponseWriter, r *http.Request) {
+		*exported = "link"
+		_, _ = io.WriteString(w, "docx data")
+	})
+	mux.HandleFunc("/files/doc/export", func(w http.ResponseWriter, r *http.Request) {
+		*exported = "api " + r.URL.Query().Get("mimeType")
+		_, _ = io.WriteString(w, "docx data")
+	})
+	return mux
+}
+
+func TestListExportsDocuments(t *testing.T) {
+	var exported string
+	f := newTestFs(t, Options{ExportFormats: "docx,url"}, exportServer(t, &exported))
+	ctx := context.Background()
+
+	entries, err := f.List(ctx, "")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Remote())
+	}
+	if strings.Join(names, ",") != "report.docx,survey.url,a.txt" {
+		t.Fatalf("got %v", names)
+	}
+
+	doc := entries[0].(*documentObject)
+	if doc.Size() != -1 {
+		t.Errorf("got document size %d, want -1", doc.Size())
+	}
+	rc, err := doc.Open(ctx)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	data, _ := io.ReadAll(rc)
+	_ = rc.Close()
+	if string(data) != "docx data" || exported != "link" {
+		t.Errorf("got %q via %q", data, exported)
+	}
+
+	link := entries[1].(*linkObject)
+	rc, _ = link.Open(ctx)
+	data, _ = io.ReadAll(rc)
+	if !strings.Contains(string(data), "URL=https://docs.google.com/forms/d/form/edit") || int64(len(data)) != link.Size() {
+		t.Errorf("bad link file %q", data)
+	}
+}
+
+func TestListSkipsUnexportableDocuments(t *testing.T) {
+	var exported string
+	f := newTestFs(t, Options{ExportFormats: "xlsx"}, exportServer(t, &exported))
+
+	entries, err := f.List(context.Background(), "")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Remote() != "a.txt" {
+		t.Errorf("got %v, want only a.txt", entries)
+	}
+}
+
+func TestNewObjectAlternateExport(t *testing.T) {
+	var exported string
+	f := newTestFs(t, Options{ExportFormats: "docx", AlternateExport: true}, exportServer(t, &exported))
+	ctx := context.Background()
+
+	o, err := f.NewObject(ctx, "report.docx")
+	if err != nil {
+		t.Fatalf("NewObject failed: %v", err)
+	}
+	rc, err := o.Open(ctx)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	_ = rc.Close()
+	if exported != "api "+docxMimeType {
+		t.Errorf("exported via %q, want Files.Export", exported)
+	}
+}
@@ -0,0 +1,180 @@
+// Package drive implements a Google Drive client for standalone usage
+//
+// This file contains the import of uploads into Google Workspace documents
+package drive
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/standalone-gdrive/fs"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// Google Workspace document types
+const (
+	documentMimeType     = "application/vnd.google-apps.document"
+	spreadsheetMimeType  = "application/vnd.google-apps.spreadsheet"
+	presentationMimeType = "application/vnd.google-apps.presentation"
+	drawingMimeType      = "application/vnd.google-apps.drawing"
+)
+
+// importTypes maps the MIME types which can be imported to the Google
+// document type they are converted into
+var importTypes = map[string]string{
+	extensionToMimeType["doc"]:  documentMimeType,
+	extensionToMimeType["docx"]: documentMimeType,
+	extensionToMimeType["html"]: documentMimeType,
+	extensionToMimeType["md"]:   documentMimeType,
+	extensionToMimeType["odt"]:  documentMimeType,
+	extensionToMimeType["rtf"]:  documentMimeType,
+	extensionToMimeType["txt"]:  documentMimeType,
+	extensionToMimeType["csv"]:  spreadsheetMimeType,
+	extensionToMimeType["ods"]:  spreadsheetMimeType,
+	extensionToMimeType["tsv"]:  spreadsheetMimeType,
+	extensionToMimeType["xls"]:  spreadsheetMimeType,
+	extensionToMimeType["xlsx"]: spreadsheetMimeType,
+	extensionToMimeType["odp"]:  presentationMimeType,
+	extensionToMimeType["pptx"]: presentationMimeType,
+}
+
+// exportTypes lists the extensions each Google document type can be
+// exported as
+var exportTypes = map[string][]string{
+	documentMimeType:     {"docx", "odt", "rtf", "pdf", "txt", "html", "epub", "md", "zip"},
+	spreadsheetMimeType:  {"xlsx", "ods", "pdf", "csv", "tsv", "zip"},
+	presentationMimeType: {"pptx", "odp", "pdf", "txt"},
+	drawingMimeType:      {"svg", "png", "jpg", "pdf"},
+}
+
+// parseImportFormats returns the MIME types of the comma separated
+// import extensions
+func parseImportFormats(formats string) ([]string, error) {
+	var mimeTypes []string
+	for _, extension := range strings.Split(formats, ",") {
+		extension = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(extension), "."))
+		if extension == "" {
+			continue
+		}
+		mimeType, ok := extensionToMimeType[extension]
+		if !ok || importTypes[mimeType] == "" {
+			return nil, fmt.Errorf("can't import %q files", extension)
+		}
+		mimeTypes = append(mimeTypes, mimeType)
+	}
+	return mimeTypes, nil
+}
+
+// exportExtensionFor returns the extension a document of docType is
+// exported with, or "" if none of the export formats match
+func (f *Fs) exportExtensionFor(docType string) string {
+	for _, extension := range f.exportExtensions {
+		for _, supported := range exportTypes[docType] {
+			if extension == supported {
+				return extension
+			}
+		}
+	}
+	return ""
+}
+
+// findImportFormat works out whether src should be converted into a
+// Google document on upload.
+//
+// It returns the document type to convert into, the MIME type of the
+// source and the length of the extension which is dropped from the
+// name, or "" if src shouldn't be converted.
+//
+// Unless AllowImportNameChange is set a file is only converted if the
+// document is exported with the same extension, so the name is the
+// same when it is listed again.
+func (f *Fs) findImportFormat(ctx context.Context, src fs.ObjectInfo) (importMimeType, srcMimeType string, extLen int) {
+	if len(f.importMimeTypes) == 0 {
+		return "", "", 0
+	}
+	extension := strings.ToLower(strings.TrimPrefix(path.Ext(src.Remote()), "."))
+	if extension == "" {
+		return "", "", 0
+	}
+	srcMimeType, ok := extensionToMimeType[extension]
+	if !ok {
+		srcMimeType = mimeTypeOf(ctx, src)
+	}
+	importable := false
+	for _, mimeType := range f.importMimeTypes {
+		if mimeType == srcMimeType {
+			importable = true
+			break
+		}
+	}
+	if !importable {
+		return "", "", 0
+	}
+
+	importMimeType = importTypes[srcMimeType]
+	exportExtension := f.exportExtensionFor(importMimeType)
+	if exportExtension == "" {
+		f.LogDebug("%q: not importing as the document couldn't be exported", src.Remote())
+		return "", "", 0
+	}
+	if exportExtension != extension && !f.opt.AllowImportNameChange {
+		f.LogDebug("%q: not importing as it would be exported as %q", src.Remote(), exportExtension)
+		return "", "", 0
+	}
+	return importMimeType, srcMimeType, len(extension) + 1
+}
+
+// Update replaces the content of the document by importing src.
+//
+// src must be importable into the same type of document, otherwise
+// the update is refused.
+func (o *documentObject) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (err error) {
+	defer translateErrorp(&err, fs.EntryObject)
+	importMimeType, srcMimeType, _ := o.fs.findImportFormat(ctx, src)
+	if importMimeType == "" || importMimeType != o.documentMimeType {
+		return errDocumentUpdate
+	}
+
+	updateInfo := &drive.File{
+		ModifiedTime: src.ModTime(ctx).Format(timeFormatOut),
+	}
+	in = o.fs.bwLimiter.Upload(ctx, in)
+	var info *drive.File
+	size := src.Size()
+	if size < 0 || size > int64(o.fs.opt.UploadCutoff) {
+		info, err = o.fs.uploadResumable(ctx, in, size, srcMimeType, o.id, o.remote, updateInfo)
+	} else {
+		// Read it all first so a retry can send the data again
+		var data []byte
+		data, err = io.ReadAll(in)
+		if err != nil {
+			return fmt.Errorf("couldn't read upload: %w", err)
+		}
+		err = o.fs.pacer.Call(ctx, func() (err error) {
+			info, err = o.fs.svc.Files.Update(o.id, updateInfo).
+				Media(bytes.NewReader(data), googleapi.ContentType(srcMimeType)).
+				Fields(googleapi.Field(partialFields)).
+				SupportsAllDrives(o.fs.isTeamDrive).
+				KeepRevisionForever(o.fs.opt.KeepRevisionForever).
+				Context(ctx).
+				Do()
+			return err
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	updated, ok := o.fs.newDocumentOrLink(o.remote[:len(o.remote)-o.extLen], info).(*documentObject)
+	if !ok {
+		return fmt.Errorf("couldn't export %q after update", o.remote)
+	}
+	*o = *updated
+	return nil
+}
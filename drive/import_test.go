@@ -0,0 +1,119 @@
+package drive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/standalone-gdrive/fs"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// importServer accepts multipart uploads, recording the metadata and
+// content type sent, and returns the created file
+func importServer(t *testing.T, meta *drive.File, contentType *string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/upload/drive/v3/files" {
+			// Nothing exists yet
+			writeJSON(w, &drive.FileList{})
+			return
+		}
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil {
+			t.Errorf("bad upload: %v", err)
+			return
+		}
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Errorf("no metadata part: %v", err)
+			return
+		}
+		_ = json.NewDecoder(part).Decode(meta)
+		part, err = mr.NextPart()
+		if err != nil {
+			t.Errorf("no media part: %v", err)
+			return
+		}
+		*contentType = part.Header.Get("Content-Type")
+
+		info := &drive.File{Id: "new", Name: meta.Name, MimeType: meta.MimeType, Size: 3}
+		if meta.MimeType != "" {
+			info.Size = 0
+			info.ExportLinks = map[string]string{}
+			for extension, mimeType := range extensionToMimeType {
+				for _, supported := range exportTypes[meta.MimeType] {
+					if extension == supported {
+						info.ExportLinks[mimeType] = "http://" + r.Host + "/export"
+					}
+				}
+			}
+		}
+		writeJSON(w, info)
+	})
+}
+
+func TestPutImports(t *testing.T) {
+	for _, test := range []struct {
+		remote     string
+		opt        Options
+		wantName   string
+		wantType   string
+		wantRemote string
+	}{
+		{"notes.docx", Options{ImportFormats: "docx"}, "notes", documentMimeType, "notes.docx"},
+		{"notes.docx", Options{}, "notes.docx", "", "notes.docx"},
+		{"data.csv", Options{ImportFormats: "csv"}, "data.csv", "", "data.csv"},
+		{"data.csv", Options{ImportFormats: "csv", AllowImportNameChange: true}, "data", spreadsheetMimeType, "data.xlsx"},
+	} {
+		var meta drive.File
+		var contentType string
+		test.opt.ExportFormats = defaultExportExtensions
+		test.opt.UploadCutoff = defaultChunkSize
+		f := newTestFs(t, test.opt, importServer(t, &meta, &contentType))
+		var err error
+		f.importMimeTypes, err = parseImportFormats(test.opt.ImportFormats)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		src := &fs.ObjectInfoImpl{RemoteName: test.remote, FileSize: 3, FileModTime: time.Now()}
+		o, err := f.Put(context.Background(), bytes.NewReader([]byte("abc")), src)
+		if err != nil {
+			t.Fatalf("%s: Put failed: %v", test.remote, err)
+		}
+		if meta.Name != test.wantName || meta.MimeType != test.wantType {
+			t.Errorf("%s: created %q type %q, want %q type %q", test.remote, meta.Name, meta.MimeType, test.wantName, test.wantType)
+		}
+		if test.wantType != "" && contentType != importSrcType(test.remote) {
+			t.Errorf("%s: uploaded as %q", test.remote, contentType)
+		}
+		if o.Remote() != test.wantRemote {
+			t.Errorf("%s: got remote %q, want %q", test.remote, o.Remote(), test.wantRemote)
+		}
+	}
+}
+
+// importSrcType returns the MIME type a test file is uploaded as
+func importSrcType(remote string) string {
+	if remote == "data.csv" {
+		return extensionToMimeType["csv"]
+	}
+	return extensionToMimeType["docx"]
+}
+
+func TestParseImportFormats(t *testing.T) {
+	mimeTypes, err := parseImportFormats("docx, .csv,")
+	if err != nil || len(mimeTypes) != 2 || mimeTypes[1] != "text/csv" {
+		t.Errorf("got %v, %v", mimeTypes, err)
+	}
+	if _, err := parseImportFormats("exe"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,268 @@
+// Package drive implements a Google Drive client for standalone usage
+//
+// This file contains the paginated listing implementation
+package drive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/standalone-gdrive/fs"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	defaultListChunk = 1000 // default number of items to fetch per page
+	maxListChunk     = 1000 // maximum page size accepted by the Drive API
+	listRGrouping    = 50   // number of directory IDs batched into one ListR query
+	listFields       = "nextPageToken,files(" + partialFields + ")"
+)
+
+// errStopListing can be returned from a listPages callback to stop
+// the listing early without returning an error
+var errStopListing = errors.New("stop listing")
+
+// listPages runs query against the Drive API and calls fn with the
+// files on each page of results, following NextPageToken until the
+// listing is exhausted.
+//
+// If fn returns errStopListing the listing stops and nil is returned.
+// Any other error stops the listing and is returned.
+func (f *Fs) listPages(ctx context.Context, query string, fn func(files []*drive.File) error) error {
+	list := f.svc.Files.List().
+		Q(query).
+		PageSize(int64(f.opt.ListChunk)).
+		Fields(googleapi.Field(listFields)).
+		SupportsAllDrives(f.isTeamDrive).
+		IncludeItemsFromAllDrives(f.isTeamDrive)
+	if f.isTeamDrive {
+		list.DriveId(f.opt.TeamDriveID)
+		list.Corpora("drive")
+	}
+
+	for {
+		var fileList *drive.FileList
+		err := f.pacer.Call(ctx, func() (err error) {
+			fileList, err = list.Context(ctx).Do()
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("couldn't list directory: %w", err)
+		}
+
+		err = fn(fileList.Files)
+		if err == errStopListing {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if fileList.NextPageToken == "" {
+			return nil
+		}
+		list.PageToken(fileList.NextPageToken)
+	}
+}
+
+// trashedQuery returns the part of a query selecting items by whether
+// they are trashed.
+//
+// With TrashedOnly set live directories are included too, so trashed
+// items inside directories which are still live can be reached.
+func (f *Fs) trashedQuery() string {
+	if f.opt.TrashedOnly {
+		return fmt.Sprintf("(trashed=true or mimeType=%s)", quoteQuery(driveFolderType))
+	}
+	return "trashed=false"
+}
+
+// listQuery returns the query used to list the children of all the
+// directories passed in
+func (f *Fs) listQuery(directoryIDs ...string) string {
+	query := f.trashedQuery()
+
+	// Add parent directory filter
+	parents := make([]string, 0, len(directoryIDs))
+	for _, directoryID := range directoryIDs {
+		parents = append(parents, fmt.Sprintf("%s in parents", quoteQuery(directoryID)))
+	}
+	if len(parents) == 1 {
+		query = fmt.Sprintf("%s and %s", query, parents[0])
+	} else {
+		query = fmt.Sprintf("%s and (%s)", query, strings.Join(parents, " or "))
+	}
+
+	// Add starred filter if needed
+	if f.opt.StarredOnly {
+		query = fmt.Sprintf("%s and starred=true", query)
+	}
+	return query
+}
+
+// ListP lists the objects and directories in dir, calling callback
+// with the entries from each page of results as they arrive.
+//
+// This allows huge directories to be processed without holding the
+// whole listing in memory. If callback returns an error the listing
+// stops and that error is returned.
+func (f *Fs) ListP(ctx context.Context, dir string, callback fs.ListCallback) (err error) {
+	defer translateErrorp(&err, fs.EntryDirectory)
+	directoryID, err := f.dirCache.FindDir(ctx, dir)
+	if err != nil {
+		return err
+	}
+	return f.listDirID(ctx, dir, directoryID, callback)
+}
+
+// listDirID lists the directory with directoryID found at dir, calling
+// callback with the entries from each page of results
+func (f *Fs) listDirID(ctx context.Context, dir, directoryID string, callback fs.ListCallback) error {
+	return f.listPages(ctx, f.listQuery(directoryID), func(files []*drive.File) error {
+		targets, err := f.findShortcutTargets(ctx, files)
+		if err != nil {
+			return err
+		}
+		entries := make(fs.DirEntries, 0, len(files))
+		for _, file := range files {
+			entry, err := f.itemToDirEntry(ctx, path.Join(dir, file.Name), file, targets)
+			if err != nil {
+				return err
+			}
+			if entry != nil {
+				entries = append(entries, entry)
+			}
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+		return callback(entries)
+	})
+}
+
+// ListR lists the objects and directories in dir and all its
+// subdirectories, calling callback with the entries from each page of
+// results as they arrive.
+//
+// Instead of one query per directory the IDs of up to listRGrouping
+// directories are batched into a single "'a' in parents or 'b' in
+// parents" query. Paths are rebuilt from each item's parent IDs using
+// the paths of the directories found so far.
+func (f *Fs) ListR(ctx context.Context, dir string, callback fs.ListCallback) (err error) {
+	defer translateErrorp(&err, fs.EntryDirectory)
+	directoryID, err := f.dirCache.FindDir(ctx, dir)
+	if err != nil {
+		return err
+	}
+	pending := []string{directoryID}
+	paths := map[string]string{directoryID: dir}
+	for len(pending) > 0 {
+		n := len(pending)
+		if n > listRGrouping {
+			n = listRGrouping
+		}
+		batch := pending[:n]
+		pending = pending[n:]
+
+		inBatch := make(map[string]struct{}, len(batch))
+		for _, id := range batch {
+			inBatch[id] = struct{}{}
+		}
+
+		err = f.listPages(ctx, f.listQuery(batch...), func(files []*drive.File) error {
+			targets, err := f.findShortcutTargets(ctx, files)
+			if err != nil {
+				return err
+			}
+			entries := make(fs.DirEntries, 0, len(files))
+			for _, file := range files {
+				for _, parent := range file.Parents {
+					if _, ok := inBatch[parent]; !ok {
+						continue
+					}
+					entry, err := f.itemToDirEntry(ctx, path.Join(paths[parent], file.Name), file, targets)
+					if err != nil {
+						return err
+					}
+					if entry == nil {
+						continue
+					}
+					if d, isDir := entry.(*Directory); isDir {
+						if _, ok := paths[d.id]; !ok {
+							paths[d.id] = d.Remote()
+							pending = append(pending, d.id)
+						}
+					}
+					entries = append(entries, entry)
+				}
+			}
+			if len(entries) == 0 {
+				return nil
+			}
+			return callback(entries)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// itemToDirEntry converts a drive.File into an fs.DirEntry
+//
+// It returns nil if the item should be skipped. Directories found are
+// added to the directory cache. Google documents are given the
+// extension of the format they are exported in. Shortcuts are
+// replaced by their targets from targets, which are looked up if
+// targets is nil.
+func (f *Fs) itemToDirEntry(ctx context.Context, remote string, file *drive.File, targets shortcutTargets) (fs.DirEntry, error) {
+	shortcutID := ""
+	if isShortcutType(file.MimeType) {
+		if f.opt.SkipShortcuts {
+			return nil, nil
+		}
+		shortcutID = file.Id
+		if targets == nil {
+			var err error
+			targets, err = f.findShortcutTargets(ctx, []*drive.File{file})
+			if err != nil {
+				return nil, err
+			}
+		}
+		resolved := resolveShortcut(file, targets)
+		if resolved.MimeType == shortcutMimeTypeDangling && f.opt.SkipDanglingShortcuts {
+			return nil, nil
+		}
+		file = resolved
+	}
+
+	if file.MimeType == driveFolderType {
+		if shortcutID != "" {
+			// The shortcut's path isn't where the target lives
+			f.noteShortcutDir(&drive.File{Id: shortcutID, Name: file.Name, Parents: file.Parents})
+			f.dirCache.PutAlias(remote, file.Id)
+		} else {
+			for _, parent := range file.Parents {
+				f.forgetShortcutDir(parent, file.Name)
+			}
+			f.dirCache.Put(remote, file.Id)
+		}
+		d := f.newDirectory(remote, file)
+		d.shortcutID = shortcutID
+		return d, nil
+	}
+
+	o := f.newObjectFromInfo(remote, file)
+	if o == nil {
+		return nil, nil
+	}
+	if shortcutID != "" {
+		setShortcutID(o, shortcutID)
+	}
+	return o, nil
+}
@@ -0,0 +1,220 @@
+package drive
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/standalone-gdrive/fs"
+	"github.com/standalone-gdrive/lib/dircache"
+	"github.com/standalone-gdrive/lib/pacer"
+
+	drive_v2 "google.golang.org/api/drive/v2"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+)
+
+// newTestFs returns an Fs talking to a fake Drive API served by handler
+func newTestFs(t *testing.T, opt Options, handler http.Handler) *Fs {
+	t.Helper()
+	ctx := context.Background()
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	if opt.ListChunk == 0 {
+		opt.ListChunk = defaultListChunk
+	}
+	f := &Fs{
+		name:         "test",
+		opt:          opt,
+		client:       srv.Client(),
+		rootFolderID: "root",
+		pacer: pacer.New(
+			pacer.MinSleep(time.Millisecond),
+			pacer.MaxSleep(time.Millisecond),
+			pacer.ShouldRetryOption(shouldRetry),
+		),
+		dirResourceKeys: new(sync.Map),
+		shortcutDirs:    new(sync.Map),
+		permissionsMu:   new(sync.Mutex),
+		permissions:     make(map[string]*drive.Permission),
+		logger:          NewLogger(LogLevelSilent, nil),
+	}
+	var err error
+	f.svc, err = drive.NewService(ctx,
+		option.WithHTTPClient(srv.Client()),
+		option.WithEndpoint(srv.URL+"/"))
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	f.v2Svc, err = drive_v2.NewService(ctx,
+		option.WithHTTPClient(srv.Client()),
+		option.WithEndpoint(srv.URL+"/v2/"))
+	if err != nil {
+		t.Fatalf("failed to create v2 service: %v", err)
+	}
+	if opt.ExportFormats != "" {
+		f.exportExtensions = strings.Split(opt.ExportFormats, ",")
+	}
+	f.dirCache = dircache.New("", f.rootFolderID, f)
+	if _, err := f.dirCache.FindRoot(ctx); err != nil {
+		t.Fatalf("failed to find root: %v", err)
+	}
+	f.features = (&fs.Features{}).Fill(ctx, f)
+	return f
+}
+
+// pagedFiles serves files from pages, one page per request
+func pagedFiles(t *testing.T, pages [][]*drive.File, pageSizes *[]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		*pageSizes = append(*pageSizes, q.Get("pageSize"))
+		page := 0
+		if token := q.Get("pageToken"); token != "" {
+			if err := json.Unmarshal([]byte(token), &page); err != nil {
+				t.Errorf("bad page token %q", token)
+			}
+		}
+		list := drive.FileList{Files: pages[page]}
+		if page+1 < len(pages) {
+			next, _ := json.Marshal(page + 1)
+			list.NextPageToken = string(next)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(&list)
+	})
+}
+
+func TestListFollowsPages(t *testing.T) {
+	pages := [][]*drive.File{
+		{{Id: "1", Name: "a.txt", MimeType: "text/plain"}, {Id: "2", Name: "dir", MimeType: driveFolderType}},
+		{{Id: "3", Name: "b.txt", MimeType: "text/plain"}},
+		{{Id: "4", Name: "c.txt", MimeType: "text/plain"}},
+	}
+	var pageSizes []string
+	f := newTestFs(t, Options{ListChunk: 2}, pagedFiles(t, pages, &pageSizes))
+
+	entries, err := f.List(context.Background(), "")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("got %d entries, want 4: %v", len(entries), entries)
+	}
+	if _, ok := entries[1].(*Directory); !ok {
+		t.Errorf("entry %q should be a directory", entries[1].Remote())
+	}
+	if id, ok := f.dirCache.Get("dir"); !ok || id != "2" {
+		t.Errorf("directory not cached: got %q, %v", id, ok)
+	}
+	if len(pageSizes) != 3 {
+		t.Fatalf("got %d requests, want 3", len(pageSizes))
+	}
+	for _, size := range pageSizes {
+		if size != "2" {
+			t.Errorf("got pageSize %q, want \"2\"", size)
+		}
+	}
+}
+
+func TestListPCallbackPerPage(t *testing.T) {
+	pages := [][]*drive.File{
+		{{Id: "1", Name: "a.txt"}, {Id: "2", Name: "b.txt"}},
+		{{Id: "3", Name: "c.txt"}},
+	}
+	var pageSizes []string
+	f := newTestFs(t, Options{}, pagedFiles(t, pages, &pageSizes))
+
+	var calls []int
+	err := f.ListP(context.Background(), "", func(entries fs.DirEntries) error {
+		calls = append(calls, len(entries))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ListP failed: %v", err)
+	}
+	if len(calls) != 2 || calls[0] != 2 || calls[1] != 1 {
+		t.Errorf("got callback sizes %v, want [2 1]", calls)
+	}
+	if f.Features().ListP == nil {
+		t.Error("Features().ListP should be set")
+	}
+}
+
+func TestFindLeafDuplicateAcrossPages(t *testing.T) {
+	pages := [][]*drive.File{
+		{{Id: "1", Name: "dir", MimeType: driveFolderType}},
+		{{Id: "2", Name: "dir", MimeType: driveFolderType}},
+		{{Id: "3", Name: "dir", MimeType: driveFolderType}},
+	}
+	var pageSizes []string
+	f := newTestFs(t, Options{ListChunk: 1}, pagedFiles(t, pages, &pageSizes))
+
+	_, _, err := f.FindLeaf(context.Background(), "root", "dir")
+	if err == nil {
+		t.Fatal("expected an error for duplicate names")
+	}
+	if len(pageSizes) != 2 {
+		t.Errorf("got %d requests, want listing to stop after 2", len(pageSizes))
+	}
+}
+
+func TestListRBatchesParents(t *testing.T) {
+	children := map[string][]*drive.File{
+		"root": {
+			{Id: "d1", Name: "one", MimeType: driveFolderType, Parents: []string{"root"}},
+			{Id: "d2", Name: "two", MimeType: driveFolderType, Parents: []string{"root"}},
+			{Id: "f0", Name: "top.txt", Parents: []string{"root"}},
+		},
+		"d1": {{Id: "f1", Name: "a.txt", Parents: []string{"d1"}}},
+		"d2": {
+			{Id: "d3", Name: "three", MimeType: driveFolderType, Parents: []string{"d2"}},
+			{Id: "f2", Name: "b.txt", Parents: []string{"d2"}},
+		},
+		"d3": {{Id: "f3", Name: "c.txt", Parents: []string{"d3"}}},
+	}
+	parentRe := regexp.MustCompile(`'([^']+)' in parents`)
+	var queries []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+		queries = append(queries, q)
+		var list drive.FileList
+		for _, m := range parentRe.FindAllStringSubmatch(q, -1) {
+			list.Files = append(list.Files, children[m[1]]...)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(&list)
+	})
+	f := newTestFs(t, Options{}, handler)
+
+	var remotes []string
+	err := f.ListR(context.Background(), "", func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			remotes = append(remotes, entry.Remote())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ListR failed: %v", err)
+	}
+	sort.Strings(remotes)
+	want := []string{"one", "one/a.txt", "top.txt", "two", "two/b.txt", "two/three", "two/three/c.txt"}
+	if strings.Join(remotes, ",") != strings.Join(want, ",") {
+		t.Errorf("got %v, want %v", remotes, want)
+	}
+	// root, then d1+d2 batched together, then d3
+	if len(queries) != 3 {
+		t.Errorf("got %d queries, want 3: %v", len(queries), queries)
+	}
+	if f.Features().ListR == nil {
+		t.Error("Features().ListR should be set")
+	}
+}
@@ -2,12 +2,31 @@ package drive
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/standalone-gdrive/fs"
 	"google.golang.org/api/drive/v3"
 	"google.golang.org/api/googleapi"
 )
 
+// Check the interfaces are satisfied
+var (
+	_ fs.Metadataer    = (*Object)(nil)
+	_ fs.SetMetadataer = (*Object)(nil)
+	_ fs.Metadataer    = (*Directory)(nil)
+	_ fs.SetMetadataer = (*Directory)(nil)
+)
+
+// Prefixes of the metadata keys stored in the properties and
+// appProperties of a file
+const (
+	userMetadataPrefix = "user."
+	appMetadataPrefix  = "app."
+)
+
 // system metadata keys which this backend owns
 var systemMetadataInfo = map[string]fs.MetadataHelp{
 	"content-type": {
@@ -35,13 +54,54 @@ var systemMetadataInfo = map[string]fs.MetadataHelp{
 		Type:    "boolean",
 		Example: "false",
 	},
+	"description": {
+		Help:    "A short description of the file.",
+		Type:    "string",
+		Example: "Contract for signing",
+	},
+	"starred": {
+		Help:    "Whether the user has starred the file.",
+		Type:    "boolean",
+		Example: "false",
+	},
+	"folder-color-rgb": {
+		Help:    "The color of a folder as an RGB hex string. Only used for directories.",
+		Type:    "string",
+		Example: "#ac725e",
+	},
+	"owner": {
+		Help:     "The email address of the owner of the file. Not set for items in shared drives.",
+		Type:     "string",
+		Example:  "user@example.com",
+		ReadOnly: true,
+	},
+	"last-modifying-user": {
+		Help:     "The email address of the user who last modified the file.",
+		Type:     "string",
+		Example:  "user@example.com",
+		ReadOnly: true,
+	},
+	"permissions": {
+		Help:    "The permissions of the file as a JSON list. When set, permissions other than owners are added to the file.",
+		Type:    "JSON",
+		Example: `[{"type":"user","role":"writer","emailAddress":"user@example.com"}]`,
+	},
 }
 
-// Metadata returns metadata for an object
-func (o *Object) Metadata(ctx context.Context) (metadata fs.Metadata, err error) {
+// metadataFields are the fields read to make the metadata
+const metadataFields = "id,mimeType,modifiedTime,createdTime,copyRequiresWriterPermission,writersCanShare," +
+	"description,starred,folderColorRgb,owners(emailAddress),lastModifyingUser(emailAddress,displayName)," +
+	"properties,appProperties,permissionIds,permissions(" + permissionFields + ")"
+
+// readMetadata reads the metadata of the file with id
+func (f *Fs) readMetadata(ctx context.Context, id string) (fs.Metadata, error) {
 	var info *drive.File
-	err = o.fs.pacer.Call(ctx, func() error {
-		info, err = o.fs.svc.Files.Get(o.id).Fields("*").SupportsAllDrives(o.fs.isTeamDrive).Do()
+	err := f.pacer.Call(ctx, func() (err error) {
+		info, err = f.svc.Files.Get(id).
+			Fields(googleapi.Field(metadataFields)).
+			SupportsAllDrives(f.isTeamDrive).
+			Context(ctx).
+			Do()
 		return err
 	})
 	if err != nil {
@@ -49,9 +109,13 @@ func (o *Object) Metadata(ctx context.Context) (metadata fs.Metadata, err error)
 	}
 
 	// Construct metadata
-	metadata = fs.Metadata{
-		"content-type": info.MimeType,
-		"mtime":        info.ModifiedTime,
+	metadata := fs.Metadata{
+		"mtime": info.ModifiedTime,
+	}
+	if info.MimeType != driveFolderType {
+		metadata["content-type"] = info.MimeType
+	} else if info.FolderColorRgb != "" {
+		metadata["folder-color-rgb"] = info.FolderColorRgb
 	}
 
 	// Add creation time if available
@@ -66,125 +130,235 @@ func (o *Object) Metadata(ctx context.Context) (metadata fs.Metadata, err error)
 	if info.WritersCanShare {
 		metadata["writers-can-share"] = "true"
 	}
+	if info.Description != "" {
+		metadata["description"] = info.Description
+	}
+	if info.Starred {
+		metadata["starred"] = "true"
+	}
+	if len(info.Owners) > 0 {
+		metadata["owner"] = info.Owners[0].EmailAddress
+	}
+	if user := info.LastModifyingUser; user != nil {
+		if user.EmailAddress != "" {
+			metadata["last-modifying-user"] = user.EmailAddress
+		} else if user.DisplayName != "" {
+			metadata["last-modifying-user"] = user.DisplayName
+		}
+	}
 
-	// Add user metadata from properties
-	if info.Properties != nil {
-		for k, v := range info.Properties {
-			metadata["user."+k] = v
+	permissions, err := f.filePermissions(ctx, info)
+	if err != nil {
+		return nil, err
+	}
+	if len(permissions) > 0 {
+		blob, err := json.Marshal(permissions)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't encode permissions: %w", err)
 		}
+		metadata["permissions"] = string(blob)
+	}
+
+	// Add user metadata from properties and appProperties
+	for k, v := range info.Properties {
+		metadata[userMetadataPrefix+k] = v
+	}
+	for k, v := range info.AppProperties {
+		metadata[appMetadataPrefix+k] = v
 	}
 
 	return metadata, nil
 }
 
-// SetMetadata sets metadata for an object
-func (o *Object) SetMetadata(ctx context.Context, metadata fs.Metadata) error {
-	// Create update info
-	updateInfo := &drive.File{}
-
-	// Process system metadata
-	for k, v := range metadata {
-		switch k {
-		case "content-type":
-			updateInfo.MimeType = v
-		case "mtime":
-			updateInfo.ModifiedTime = v
-		case "btime":
-			updateInfo.CreatedTime = v
-		case "copy-requires-writer-permission":
-			updateInfo.CopyRequiresWriterPermission = v == "true"
-		case "writers-can-share":
-			updateInfo.WritersCanShare = v == "true"
+// filePermissions returns the permissions of info.
+//
+// Items in shared drives only list their permission IDs, so the
+// permissions are read one by one, using the permissions map to save
+// reading the same permission on every file.
+func (f *Fs) filePermissions(ctx context.Context, info *drive.File) ([]*drive.Permission, error) {
+	if len(info.Permissions) > 0 || len(info.PermissionIds) == 0 {
+		return info.Permissions, nil
+	}
+	permissions := make([]*drive.Permission, 0, len(info.PermissionIds))
+	for _, permissionID := range info.PermissionIds {
+		permission, ok := f.cachedPermission(info.Id, permissionID)
+		if !ok {
+			err := f.pacer.Call(ctx, func() (err error) {
+				permission, err = f.svc.Permissions.Get(info.Id, permissionID).
+					Fields(googleapi.Field(permissionFields)).
+					SupportsAllDrives(f.isTeamDrive).
+					Context(ctx).
+					Do()
+				return err
+			})
+			if err != nil {
+				return nil, fmt.Errorf("couldn't read permission %q: %w", permissionID, err)
+			}
+			f.cachePermission(info.Id, permission)
 		}
+		permissions = append(permissions, permission)
 	}
+	return permissions, nil
+}
 
-	// Process user metadata
-	properties := map[string]string{}
-	for k, v := range metadata {
-		if len(k) > 5 && k[:5] == "user." {
-			properties[k[5:]] = v
+// updateInfoFromMetadata sets the fields of info from metadata,
+// returning the permissions which should be added once the file
+// exists. Read only and unknown keys are ignored.
+func updateInfoFromMetadata(info *drive.File, metadata fs.Metadata) (permissions []*drive.Permission, err error) {
+	// parseBool parses the value v of key, making sure the field is
+	// sent even if it is false
+	parseBool := func(key, field, v string) (bool, error) {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return false, fmt.Errorf("invalid %s %q: %w", key, v, err)
 		}
+		info.ForceSendFields = append(info.ForceSendFields, field)
+		return b, nil
 	}
-	if len(properties) > 0 {
-		updateInfo.Properties = properties
+	for k, v := range metadata {
+		switch {
+		case k == "content-type":
+			info.MimeType = v
+		case k == "mtime":
+			info.ModifiedTime = v
+		case k == "btime":
+			info.CreatedTime = v
+		case k == "copy-requires-writer-permission":
+			info.CopyRequiresWriterPermission, err = parseBool(k, "CopyRequiresWriterPermission", v)
+		case k == "writers-can-share":
+			info.WritersCanShare, err = parseBool(k, "WritersCanShare", v)
+		case k == "starred":
+			info.Starred, err = parseBool(k, "Starred", v)
+		case k == "description":
+			info.Description = v
+			info.ForceSendFields = append(info.ForceSendFields, "Description")
+		case k == "folder-color-rgb":
+			info.FolderColorRgb = v
+		case k == "permissions":
+			if err = json.Unmarshal([]byte(v), &permissions); err != nil {
+				err = fmt.Errorf("invalid permissions %q: %w", v, err)
+			}
+		case strings.HasPrefix(k, userMetadataPrefix) && len(k) > len(userMetadataPrefix):
+			if info.Properties == nil {
+				info.Properties = map[string]string{}
+			}
+			info.Properties[k[len(userMetadataPrefix):]] = v
+		case strings.HasPrefix(k, appMetadataPrefix) && len(k) > len(appMetadataPrefix):
+			if info.AppProperties == nil {
+				info.AppProperties = map[string]string{}
+			}
+			info.AppProperties[k[len(appMetadataPrefix):]] = v
+		}
+		if err != nil {
+			return nil, err
+		}
 	}
-	// Send update
-	err := o.fs.pacer.Call(ctx, func() error {
-		_, err := o.fs.svc.Files.Update(o.id, updateInfo).
-			Fields(googleapi.Field(partialFields)).
-			SupportsAllDrives(o.fs.isTeamDrive).
-			Do()
-		return err
-	})
+	return permissions, nil
+}
 
-	return err
+// addPermissions adds permissions read from metadata to the file with
+// id. Owners can't be added so are skipped, and no notification
+// emails are sent.
+func (f *Fs) addPermissions(ctx context.Context, id string, permissions []*drive.Permission) error {
+	for _, permission := range permissions {
+		if permission.Role == RoleOwner || permission.Deleted {
+			continue
+		}
+		createInfo := &drive.Permission{
+			Type:               permission.Type,
+			Role:               permission.Role,
+			EmailAddress:       permission.EmailAddress,
+			Domain:             permission.Domain,
+			AllowFileDiscovery: permission.AllowFileDiscovery,
+			ExpirationTime:     permission.ExpirationTime,
+		}
+		err := f.pacer.Call(ctx, func() error {
+			call := f.svc.Permissions.Create(id, createInfo).
+				Fields(googleapi.Field(permissionFields)).
+				SupportsAllDrives(f.isTeamDrive).
+				Context(ctx)
+			if createInfo.Type == PermissionUser || createInfo.Type == PermissionGroup {
+				call = call.SendNotificationEmail(false)
+			}
+			info, err := call.Do()
+			if err == nil {
+				f.cachePermission(id, info)
+			}
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("couldn't add permission for %s: %w", grantee(createInfo), err)
+		}
+	}
+	return nil
 }
 
-// Metadata for directories
-func (d *Directory) Metadata(ctx context.Context) (fs.Metadata, error) {
+// setMetadata updates the file with id from metadata
+func (f *Fs) setMetadata(ctx context.Context, id string, metadata fs.Metadata) (*drive.File, error) {
+	updateInfo := &drive.File{}
+	permissions, err := updateInfoFromMetadata(updateInfo, metadata)
+	if err != nil {
+		return nil, err
+	}
 	var info *drive.File
-	err := d.fs.pacer.Call(ctx, func() error {
-		var err error
-		info, err = d.fs.svc.Files.Get(d.id).Fields("*").SupportsAllDrives(d.fs.isTeamDrive).Do()
+	err = f.pacer.Call(ctx, func() (err error) {
+		info, err = f.svc.Files.Update(id, updateInfo).
+			Fields(googleapi.Field(partialFields)).
+			SupportsAllDrives(f.isTeamDrive).
+			Context(ctx).
+			Do()
 		return err
 	})
 	if err != nil {
 		return nil, err
 	}
+	return info, f.addPermissions(ctx, id, permissions)
+}
 
-	// Construct metadata
-	metadata := fs.Metadata{
-		"mtime": info.ModifiedTime,
-	}
-
-	// Add creation time if available
-	if info.CreatedTime != "" {
-		metadata["btime"] = info.CreatedTime
-	}
+// Metadata returns metadata for an object
+func (o *Object) Metadata(ctx context.Context) (_ fs.Metadata, err error) {
+	defer translateErrorp(&err, fs.EntryObject)
+	return o.fs.readMetadata(ctx, o.id)
+}
 
-	// Add user metadata from properties
-	if info.Properties != nil {
-		for k, v := range info.Properties {
-			metadata["user."+k] = v
-		}
+// SetMetadata sets metadata for an object
+func (o *Object) SetMetadata(ctx context.Context, metadata fs.Metadata) (err error) {
+	defer translateErrorp(&err, fs.EntryObject)
+	info, err := o.fs.setMetadata(ctx, o.id, metadata)
+	if err != nil {
+		return err
 	}
+	o.modifiedDate = info.ModifiedTime
+	o.mimeType = info.MimeType
+	return nil
+}
 
-	return metadata, nil
+// Metadata for directories
+func (d *Directory) Metadata(ctx context.Context) (_ fs.Metadata, err error) {
+	defer translateErrorp(&err, fs.EntryDirectory)
+	return d.fs.readMetadata(ctx, d.id)
 }
 
 // SetMetadata sets metadata for a Directory
-func (d *Directory) SetMetadata(ctx context.Context, metadata fs.Metadata) error {
-	// Create update info
-	updateInfo := &drive.File{}
-
-	// Process system metadata
-	for k, v := range metadata {
-		switch k {
-		case "mtime":
-			updateInfo.ModifiedTime = v
-		case "btime":
-			updateInfo.CreatedTime = v
-		}
+func (d *Directory) SetMetadata(ctx context.Context, metadata fs.Metadata) (err error) {
+	defer translateErrorp(&err, fs.EntryDirectory)
+	// A directory's MIME type can't change
+	metadata = metadata.Copy()
+	metadata.DeleteKey("content-type")
+	info, err := d.fs.setMetadata(ctx, d.id, metadata)
+	if err != nil {
+		return err
 	}
+	d.modifiedDate = info.ModifiedTime
+	return nil
+}
 
-	// Process user metadata
-	properties := map[string]string{}
-	for k, v := range metadata {
-		if len(k) > 5 && k[:5] == "user." {
-			properties[k[5:]] = v
-		}
-	}
-	if len(properties) > 0 {
-		updateInfo.Properties = properties
+// MetadataInfo describes the metadata read and written by the backend
+func (f *Fs) MetadataInfo() *fs.MetadataInfo {
+	return &fs.MetadataInfo{
+		System: systemMetadataInfo,
+		Help: "User metadata is stored in the properties of a file with the `" + userMetadataPrefix +
+			"` prefix and in its appProperties, which only this OAuth client can see, with the `" +
+			appMetadataPrefix + "` prefix.",
 	}
-	// Send update
-	err := d.fs.pacer.Call(ctx, func() error {
-		_, err := d.fs.svc.Files.Update(d.id, updateInfo).
-			Fields(googleapi.Field(partialFields)).
-			SupportsAllDrives(d.fs.isTeamDrive).
-			Do()
-		return err
-	})
-
-	return err
 }
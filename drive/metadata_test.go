@@ -0,0 +1,154 @@
+package drive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/standalone-gdrive/fs"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func TestReadMetadata(t *testing.T) {
+	permissionGets := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/files/id", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, &drive.File{
+			Id:                "id",
+			MimeType:          "text/plain",
+			ModifiedTime:      "2024-01-02T03:04:05.000Z",
+			Description:       "notes",
+			Starred:           true,
+			Owners:            []*drive.User{{EmailAddress: "owner@example.com"}},
+			LastModifyingUser: &drive.User{EmailAddress: "editor@example.com"},
+			Properties:        map[string]string{"colour": "blue"},
+			AppProperties:     map[string]string{"sync": "42"},
+			PermissionIds:     []string{"p1"},
+		})
+	})
+	mux.HandleFunc("/files/id/permissions/p1", func(w http.ResponseWriter, r *http.Request) {
+		permissionGets++
+		writeJSON(w, &drive.Permission{Id: "p1", Type: PermissionUser, Role: RoleWriter, EmailAddress: "editor@example.com"})
+	})
+	// The same user has the same permission ID with another role
+	mux.HandleFunc("/files/id2", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, &drive.File{Id: "id2", MimeType: "text/plain", PermissionIds: []string{"p1"}})
+	})
+	mux.HandleFunc("/files/id2/permissions/p1", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, &drive.Permission{Id: "p1", Type: PermissionUser, Role: RoleReader, EmailAddress: "editor@example.com"})
+	})
+	f := newTestFs(t, Options{}, mux)
+	o := f.newObjectWithInfo("file.txt", &drive.File{Id: "id"})
+
+	for i := 0; i < 2; i++ {
+		metadata, err := o.Metadata(context.Background())
+		if err != nil {
+			t.Fatalf("Metadata failed: %v", err)
+		}
+		for k, want := range map[string]string{
+			"content-type":        "text/plain",
+			"description":         "notes",
+			"starred":             "true",
+			"owner":               "owner@example.com",
+			"last-modifying-user": "editor@example.com",
+			"user.colour":         "blue",
+			"app.sync":            "42",
+			"permissions":         `[{"emailAddress":"editor@example.com","id":"p1","role":"writer","type":"user"}]`,
+		} {
+			if got := metadata[k]; got != want {
+				t.Errorf("%s: got %q, want %q", k, got, want)
+			}
+		}
+		for k := range metadata {
+			if _, ok := systemMetadataInfo[k]; !ok && !strings.HasPrefix(k, "user.") && !strings.HasPrefix(k, "app.") {
+				t.Errorf("%s isn't described in systemMetadataInfo", k)
+			}
+		}
+	}
+	if permissionGets != 1 {
+		t.Errorf("read the permission %d times, want it cached", permissionGets)
+	}
+
+	metadata, err := f.newObjectWithInfo("other.txt", &drive.File{Id: "id2"}).Metadata(context.Background())
+	if err != nil {
+		t.Fatalf("Metadata failed: %v", err)
+	}
+	if want := `[{"emailAddress":"editor@example.com","id":"p1","role":"reader","type":"user"}]`; metadata["permissions"] != want {
+		t.Errorf("got permissions %s, want %s", metadata["permissions"], want)
+	}
+}
+
+func TestSetMetadata(t *testing.T) {
+	var sent map[string]interface{}
+	f := newTestFs(t, Options{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent = nil
+		_ = json.NewDecoder(r.Body).Decode(&sent)
+		writeJSON(w, &drive.File{Id: "id"})
+	}))
+	o := f.newObjectWithInfo("file.txt", &drive.File{Id: "id"})
+
+	err := o.SetMetadata(context.Background(), fs.Metadata{
+		"starred":     "false",
+		"description": "",
+		"owner":       "someone@example.com",
+		"app.sync":    "43",
+	})
+	if err != nil {
+		t.Fatalf("SetMetadata failed: %v", err)
+	}
+	if sent["starred"] != false || sent["description"] != "" {
+		t.Errorf("false and empty values weren't sent: %v", sent)
+	}
+	if _, ok := sent["owners"]; ok {
+		t.Errorf("read only owner was sent: %v", sent)
+	}
+	if app, _ := sent["appProperties"].(map[string]interface{}); app["sync"] != "43" {
+		t.Errorf("appProperties weren't sent: %v", sent)
+	}
+
+	if err := o.SetMetadata(context.Background(), fs.Metadata{"starred": "maybe"}); err == nil {
+		t.Error("expected an error for an invalid boolean")
+	}
+}
+
+func TestPutMetadata(t *testing.T) {
+	var meta drive.File
+	var contentType string
+	var added []*drive.Permission
+	var notify []string
+	uploads := importServer(t, &meta, &contentType)
+	f := newTestFs(t, Options{UploadCutoff: defaultChunkSize}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/files/new/permissions" {
+			var info drive.Permission
+			_ = json.NewDecoder(r.Body).Decode(&info)
+			added = append(added, &info)
+			notify = append(notify, r.URL.Query().Get("sendNotificationEmail"))
+			writeJSON(w, &info)
+			return
+		}
+		uploads.ServeHTTP(w, r)
+	}))
+
+	src := &fs.ObjectInfoImpl{RemoteName: "file.txt", FileSize: 3, FileModTime: time.Now()}
+	_, err := f.Put(context.Background(), bytes.NewReader([]byte("abc")), src, fs.MetadataOption{
+		"description": "copied",
+		"mtime":       "2020-01-01T00:00:00Z",
+		"user.colour": "blue",
+		"permissions": `[{"type":"user","role":"owner","emailAddress":"me@example.com"},` +
+			`{"type":"user","role":"reader","emailAddress":"you@example.com"}]`,
+	})
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if meta.Description != "copied" || meta.ModifiedTime != "2020-01-01T00:00:00Z" || meta.Properties["colour"] != "blue" {
+		t.Errorf("metadata wasn't sent: %+v", meta)
+	}
+	if len(added) != 1 || added[0].EmailAddress != "you@example.com" || notify[0] != "false" {
+		t.Errorf("added permissions %v with notification %v, want only the reader without", added, notify)
+	}
+}
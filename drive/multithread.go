@@ -0,0 +1,129 @@
+// Package drive implements a Google Drive client for standalone usage
+//
+// This file contains the multi-thread downloader
+package drive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/standalone-gdrive/fs"
+)
+
+const (
+	defaultMultiThreadStreams = 4
+	defaultMultiThreadCutoff  = 256 * fs.MiByte
+	multiThreadRetries        = 3 // attempts at each range before giving up
+)
+
+// MultiThreadDownload downloads the object into w.
+//
+// Objects of at least MultiThreadCutoff bytes are split into
+// MultiThreadStreams byte ranges, but no more than the pacer's limit on
+// connections, which are fetched concurrently, each written at its own
+// offset in w. A range which fails part way through
+// is retried from where it stopped. Smaller objects, or any object if
+// MultiThreadStreams is 1 or less, are downloaded in a single stream.
+//
+// Once every range is written the checksums are checked, reading the
+// data back if w is also an io.ReaderAt such as an *os.File.
+func (o *Object) MultiThreadDownload(ctx context.Context, w io.WriterAt) (err error) {
+	defer translateErrorp(&err, fs.EntryObject)
+	size := o.bytes
+	if size < 0 {
+		return errors.New("can't multi-thread download an object of unknown size")
+	}
+	streams := int64(o.fs.opt.MultiThreadStreams)
+	// The pacer only holds a connection until the response headers
+	// arrive, so keep the bodies streaming at once within its limit
+	if limit := int64(o.fs.pacer.Stats().MaxConnections); limit > 0 && streams > limit {
+		streams = limit
+	}
+	if streams <= 1 || size < int64(o.fs.opt.MultiThreadCutoff) {
+		streams = 1
+	}
+	if size == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	partSize := (size + streams - 1) / streams
+	errs := make(chan error, streams)
+	var wg sync.WaitGroup
+	for start := int64(0); start < size; start += partSize {
+		end := start + partSize
+		if end > size {
+			end = size
+		}
+		wg.Add(1)
+		go func(start, end int64) {
+			defer wg.Done()
+			if err := o.downloadRange(ctx, w, start, end); err != nil {
+				errs <- err
+				cancel()
+			}
+		}(start, end)
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return err
+	}
+	return o.checkDownload(w)
+}
+
+// checkDownload checks the object downloaded into w against the
+// checksums from Drive. The ranges finish in any order so the
+// checksums can't be calculated as they are written, and w is read
+// back instead if it is an io.ReaderAt.
+func (o *Object) checkDownload(w io.WriterAt) error {
+	if o.fs.opt.DisableChecksum || (o.md5sum == "" && o.sha256sum == "") {
+		return nil
+	}
+	r, ok := w.(io.ReaderAt)
+	if !ok {
+		o.fs.LogDebug("%q: can't check the checksums of a download into a %T", o.remote, w)
+		return nil
+	}
+	sums := newChecksumReader(io.NewSectionReader(r, 0, o.bytes))
+	if _, err := io.Copy(io.Discard, sums); err != nil {
+		return fmt.Errorf("couldn't read back %q to check it: %w", o.remote, err)
+	}
+	if err := sums.check(o.md5sum, o.sha256sum); err != nil {
+		return fmt.Errorf("download of %q corrupted: %w", o.remote, err)
+	}
+	return nil
+}
+
+// downloadRange downloads bytes start to end-1 of the object into w,
+// retrying from the last byte written if the transfer fails
+func (o *Object) downloadRange(ctx context.Context, w io.WriterAt, start, end int64) error {
+	offset := start
+	var err error
+	for try := 0; try < multiThreadRetries; try++ {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var rc io.ReadCloser
+		rc, err = o.Open(ctx, &fs.RangeOption{Start: offset, End: end - 1})
+		if err == nil {
+			var n int64
+			n, err = io.Copy(io.NewOffsetWriter(w, offset), rc)
+			_ = rc.Close()
+			offset += n
+			if err == nil && offset < end {
+				err = io.ErrUnexpectedEOF
+			}
+			if err == nil {
+				return nil
+			}
+		}
+		o.fs.LogDebug("%q: range %d-%d failed at offset %d: %v", o.remote, start, end-1, offset, err)
+	}
+	return fmt.Errorf("couldn't download range %d-%d of %q: %w", start, end-1, o.remote, err)
+}
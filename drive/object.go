@@ -1,14 +1,17 @@
 package drive
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
 	"net/http"
+	neturl "net/url"
 	"time"
 
 	"github.com/standalone-gdrive/fs"
 	"github.com/standalone-gdrive/fs/hash"
+	"github.com/standalone-gdrive/lib/readers"
 
 	"google.golang.org/api/drive/v3"
 	"google.golang.org/api/googleapi"
@@ -18,7 +21,7 @@ import (
 // Object specific methods
 
 // Fs returns read only access to the Fs that this object is part of
-func (o *Object) Fs() fs.Info {
+func (o *baseObject) Fs() fs.Info {
 	return o.fs
 }
 
@@ -31,7 +34,7 @@ func (o *Object) String() string {
 }
 
 // Remote returns the remote path
-func (o *Object) Remote() string {
+func (o *baseObject) Remote() string {
 	return o.remote
 }
 
@@ -48,8 +51,12 @@ func (o *Object) Hash(ctx context.Context, t hash.Type) (string, error) {
 	return "", hash.ErrUnsupported
 }
 
-// Size returns the size of an object in bytes
+// Size returns the size of an object in bytes, or the storage it uses
+// if SizeAsQuota is set
 func (o *Object) Size() int64 {
+	if o.fs.opt.SizeAsQuota {
+		return o.quota
+	}
 	return o.bytes
 }
 
@@ -59,13 +66,14 @@ func (o *Object) MimeType(ctx context.Context) string {
 }
 
 // SetModTime sets the modification time of the drive fs object
-func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
+func (o *baseObject) SetModTime(ctx context.Context, modTime time.Time) (err error) {
+	defer translateErrorp(&err, fs.EntryObject)
 	// New metadata
 	updateInfo := &drive.File{
 		ModifiedTime: modTime.Format(timeFormatOut),
 	}
 	// Set options
-	err := o.fs.pacer.Call(ctx, func() error {
+	err = o.fs.pacer.Call(ctx, func() error {
 		_, err := o.fs.svc.Files.Update(o.id, updateInfo).
 			Fields(googleapi.Field(partialFields)).
 			SupportsAllDrives(o.fs.isTeamDrive).
@@ -82,7 +90,7 @@ func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
 }
 
 // ModTime returns the modification time of the object
-func (o *Object) ModTime(ctx context.Context) time.Time {
+func (o *baseObject) ModTime(ctx context.Context) time.Time {
 	modTime, err := time.Parse(timeFormatIn, o.modifiedDate)
 	if err != nil {
 		return time.Now()
@@ -91,58 +99,134 @@ func (o *Object) ModTime(ctx context.Context) time.Time {
 }
 
 // Open an object for read
-func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
-	var url string
-	var resp *http.Response
-	var err error
+//
+// Any fs.RangeOption or fs.SeekOption is sent as a Range header, so
+// only the part of the object asked for is downloaded.
+func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (_ io.ReadCloser, err error) {
+	defer translateErrorp(&err, fs.EntryObject)
+	fs.FixRangeOption(options, o.bytes)
+
+	// Use Drive API v3 unless the object is big enough for v2, which
+	// gives a more reliable download experience for large files
+	basePath := o.fs.svc.BasePath
 	if o.v2Download {
-		// Use Drive API v2 to download the file to get a more reliable
-		// download experience for large files
-		err = o.fs.pacer.Call(ctx, func() (err error) {
-			resp, err = o.fs.v2Svc.Files.Get(o.id).Download()
-			return err
-		})
-		if err != nil {
-			return nil, err
+		basePath = o.fs.v2Svc.BasePath
+	}
+	downloadURL := basePath + "files/" + neturl.PathEscape(o.id) + "?alt=media"
+	if o.fs.opt.AcknowledgeAbuse {
+		downloadURL += "&acknowledgeAbuse=true"
+	}
+	rc, err := o.fs.download(ctx, downloadURL, options)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check the checksums of whole downloads
+	if o.fs.opt.DisableChecksum || isRanged(options) || (o.md5sum == "" && o.sha256sum == "") {
+		return rc, nil
+	}
+	return newCheckingReadCloser(rc, o.remote, o.md5sum, o.sha256sum), nil
+}
+
+// isRanged returns true if options ask for part of an object
+func isRanged(options []fs.OpenOption) bool {
+	for _, option := range options {
+		switch option.(type) {
+		case *fs.RangeOption, *fs.SeekOption:
+			return true
 		}
-		err = o.fs.pacer.Call(ctx, func() (err error) {
-			resp, err = o.fs.client.Get(url)
-			return err
-		})
+	}
+	return false
+}
+
+// download GETs downloadURL sending the headers of any HTTP options.
+//
+// If a range was asked for the server must reply with 206 Partial
+// Content. If it sends the whole object instead the response is cut
+// down to the range asked for.
+func (f *Fs) download(ctx context.Context, downloadURL string, options []fs.OpenOption) (io.ReadCloser, error) {
+	var resp *http.Response
+	err := f.pacer.Call(ctx, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, downloadURL, nil)
 		if err != nil {
-			return nil, err
+			return err
 		}
-	} else {
-		// Use Drive API v3
-		var downloadURL string
-		if o.fs.opt.AcknowledgeAbuse {
-			downloadURL = "https://www.googleapis.com/drive/v3/files/" + o.id + "?alt=media&acknowledgeAbuse=true"
-		} else {
-			downloadURL = "https://www.googleapis.com/drive/v3/files/" + o.id + "?alt=media"
+		for _, option := range options {
+			if httpOption, ok := option.(fs.HTTPOption); ok {
+				if key, value := httpOption.Header(); key != "" {
+					req.Header.Set(key, value)
+				}
+			}
 		}
-		err = o.fs.pacer.Call(ctx, func() (err error) {
-			resp, err = o.fs.client.Get(downloadURL)
-			return err
-		})
+		resp, err = f.client.Do(req)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		// Turn error responses into errors so they are retried
+		if err := googleapi.CheckResponse(resp); err != nil {
+			_ = resp.Body.Close()
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = f.bwLimiter.Download(ctx, resp.Body)
+
+	offset, limit := int64(0), int64(-1)
+	ranged := false
+	for _, option := range options {
+		switch x := option.(type) {
+		case *fs.RangeOption:
+			offset, limit = x.Decode(-1)
+			ranged = true
+		case *fs.SeekOption:
+			offset = x.Offset
+			ranged = true
 		}
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		defer resp.Body.Close()
-		return nil, fmt.Errorf("bad response: %d: %s", resp.StatusCode, resp.Status)
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && ranged:
+		return resp.Body, nil
+	case resp.StatusCode == http.StatusOK && !ranged:
+		return resp.Body, nil
+	case resp.StatusCode == http.StatusOK:
+		// The range was ignored so skip to it
+		if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+			_ = resp.Body.Close()
+			return nil, fmt.Errorf("couldn't skip to offset %d: %w", offset, err)
+		}
+		if limit >= 0 {
+			return readers.NewLimitedReadCloser(resp.Body, limit), nil
+		}
+		return resp.Body, nil
 	}
+	defer resp.Body.Close()
+	return nil, fmt.Errorf("bad response: %d: %s", resp.StatusCode, resp.Status)
+}
 
-	return resp.Body, nil
+// OpenSeekable opens the object for random access.
+//
+// The returned reader implements io.ReadSeekCloser and io.ReaderAt.
+// Seeking costs nothing until the next Read, which reopens the object
+// at the new offset with a ranged request. Each ReadAt downloads just
+// the range it needs.
+func (o *Object) OpenSeekable(ctx context.Context) *readers.ReopenReadSeeker {
+	return readers.NewReopenReadSeeker(o.bytes, func(offset, limit int64) (io.ReadCloser, error) {
+		end := int64(-1)
+		if limit >= 0 {
+			end = offset + limit - 1
+		}
+		return o.Open(ctx, &fs.RangeOption{Start: offset, End: end})
+	})
 }
 
 // Update in to the object
-func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (err error) {
+	defer translateErrorp(&err, fs.EntryObject)
 	size := src.Size()
-	if size < 0 {
-		size = 0
-	}
 
 	// Create a new file info
 	updateInfo := &drive.File{}
@@ -151,15 +235,38 @@ func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, op
 	modTime := src.ModTime(ctx)
 	updateInfo.ModifiedTime = modTime.Format(timeFormatOut)
 
+	// Apply any metadata passed in. The creation time can't be
+	// changed by an update.
+	metadata := fs.GetMetadataOptions(options)
+	metadata.DeleteKey("btime")
+	permissions, err := updateInfoFromMetadata(updateInfo, metadata)
+	if err != nil {
+		return err
+	}
+
+	in = o.fs.bwLimiter.Upload(ctx, in)
+
+	// Calculate the checksums as the data is sent
+	var sums *checksumReader
+	if !o.fs.opt.DisableChecksum {
+		sums = newChecksumReader(in)
+		in = sums
+	}
+
 	var info *drive.File
-	var err error
-	if size > int64(o.fs.opt.UploadCutoff) {
-		// Upload in chunks
-		info, err = o.fs.uploadChunked(ctx, in, size, updateInfo)
+	if size < 0 || size > int64(o.fs.opt.UploadCutoff) {
+		// Upload in chunks, streaming until EOF if the size isn't known
+		info, err = o.fs.uploadResumable(ctx, in, size, mimeTypeOf(ctx, src), o.id, o.remote, updateInfo)
 	} else { // Simple upload
+		// Read it all first so a retry can send the data again
+		var data []byte
+		data, err = io.ReadAll(in)
+		if err != nil {
+			return fmt.Errorf("couldn't read upload: %w", err)
+		}
 		err = o.fs.pacer.Call(ctx, func() (err error) {
 			info, err = o.fs.svc.Files.Update(o.id, updateInfo).
-				Media(in, googleapi.ContentType("")).
+				Media(bytes.NewReader(data), googleapi.ContentType("")).
 				Fields(googleapi.Field(partialFields)).
 				SupportsAllDrives(o.fs.isTeamDrive).
 				KeepRevisionForever(o.fs.opt.KeepRevisionForever).
@@ -171,49 +278,44 @@ func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, op
 	if err != nil {
 		return err
 	}
+	// The file was there before, so it is left alone if the
+	// checksums don't match rather than deleted
+	if sums != nil {
+		if err := sums.check(info.Md5Checksum, info.Sha256Checksum); err != nil {
+			return fmt.Errorf("upload of %q corrupted: %w", o.remote, err)
+		}
+	}
+	if err := o.fs.addPermissions(ctx, info.Id, permissions); err != nil {
+		return err
+	}
 
-	// Update object
-	o.id = info.Id
-	o.modifiedDate = info.ModifiedTime
-	o.mimeType = info.MimeType
-	o.bytes = info.Size
-	o.md5sum = info.Md5Checksum
-	o.sha1sum = info.Sha1Checksum
-	o.sha256sum = info.Sha256Checksum
-	o.v2Download = o.fs.opt.V2DownloadMinSize >= 0 && info.Size >= int64(o.fs.opt.V2DownloadMinSize)
-
+	// Update object, keeping how it was found
+	updated := o.fs.newObjectWithInfo(o.remote, info)
+	updated.resourceKey = o.resourceKey
+	updated.shortcutID = o.shortcutID
+	*o = *updated
 	return nil
 }
 
 // Remove an object
-func (o *Object) Remove(ctx context.Context) error {
-	err := o.fs.pacer.Call(ctx, func() error {
-		if o.fs.opt.UseTrash {
-			// Put file in the trash instead of deleting it permanently
-			updateInfo := &drive.File{
-				Trashed: true,
-			}
-			_, err := o.fs.svc.Files.Update(o.id, updateInfo).
-				Fields("").
-				SupportsAllDrives(o.fs.isTeamDrive).
-				Do()
-			return err
-		}
-		// Delete file permanently
-		return o.fs.svc.Files.Delete(o.id).
-			SupportsAllDrives(o.fs.isTeamDrive).
-			Do()
-	})
-	return err
+//
+// If the object was found through a shortcut the shortcut is removed
+// rather than its target.
+func (o *baseObject) Remove(ctx context.Context) (err error) {
+	defer translateErrorp(&err, fs.EntryObject)
+	if o.shortcutID != "" {
+		return o.fs.delete(ctx, o.shortcutID, o.fs.opt.UseTrash)
+	}
+	return o.fs.delete(ctx, o.id, o.fs.opt.UseTrash)
 }
 
 // ID gets the ID of the Object
-func (o *Object) ID() string {
+func (o *baseObject) ID() string {
 	return o.id
 }
 
 // ParentID gets the ID of the Object parent
-func (o *Object) ParentID() string {
+func (o *baseObject) ParentID() string {
 	if len(o.parents) > 0 {
 		return o.parents[0]
 	}
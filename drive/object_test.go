@@ -0,0 +1,325 @@
+package drive
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/standalone-gdrive/fs"
+	"github.com/standalone-gdrive/fs/hash"
+	"github.com/standalone-gdrive/lib/bwlimit"
+
+	"google.golang.org/api/drive/v3"
+)
+
+const rangeContent = "0123456789abcdefghij"
+
+// rangeServer serves rangeContent as the file "id", honouring Range
+// headers unless ignoreRange is set
+func rangeServer(t *testing.T, ignoreRange bool, requests *int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		if r.URL.Path != "/files/id" || r.URL.Query().Get("alt") != "media" {
+			t.Errorf("unexpected request %s", r.URL)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if ignoreRange {
+			_, _ = io.WriteString(w, rangeContent)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader([]byte(rangeContent)))
+	})
+}
+
+func TestOpenRange(t *testing.T) {
+	for _, ignoreRange := range []bool{false, true} {
+		for _, test := range []struct {
+			options []fs.OpenOption
+			want    string
+		}{
+			{nil, rangeContent},
+			{[]fs.OpenOption{&fs.RangeOption{Start: 2, End: 5}}, "2345"},
+			{[]fs.OpenOption{&fs.RangeOption{Start: -1, End: 3}}, "hij"},
+			{[]fs.OpenOption{&fs.RangeOption{Start: 15, End: -1}}, "fghij"},
+			{[]fs.OpenOption{&fs.SeekOption{Offset: 18}}, "ij"},
+		} {
+			var requests int
+			f := newTestFs(t, Options{V2DownloadMinSize: -1}, rangeServer(t, ignoreRange, &requests))
+			o := f.newObjectWithInfo("file", &drive.File{Id: "id", Size: int64(len(rangeContent))})
+
+			rc, err := o.Open(context.Background(), test.options...)
+			if err != nil {
+				t.Fatalf("Open(%v) failed: %v", test.options, err)
+			}
+			got, _ := io.ReadAll(rc)
+			_ = rc.Close()
+			if string(got) != test.want {
+				t.Errorf("ignoreRange=%v Open(%v) = %q, want %q", ignoreRange, test.options, got, test.want)
+			}
+		}
+	}
+}
+
+func TestOpenSeekable(t *testing.T) {
+	var requests int
+	f := newTestFs(t, Options{V2DownloadMinSize: -1}, rangeServer(t, false, &requests))
+	o := f.newObjectWithInfo("file", &drive.File{Id: "id", Size: int64(len(rangeContent))})
+
+	r := o.OpenSeekable(context.Background())
+	defer func() { _ = r.Close() }()
+	if requests != 0 {
+		t.Errorf("opening made %d requests, want none until read", requests)
+	}
+
+	buf := make([]byte, 3)
+	if _, err := io.ReadFull(r, buf); err != nil || string(buf) != "012" {
+		t.Fatalf("first read got %q, %v", buf, err)
+	}
+	if _, err := r.Seek(-4, io.SeekEnd); err != nil {
+		t.Fatalf("Seek failed: %v", err)
+	}
+	rest, err := io.ReadAll(r)
+	if err != nil || string(rest) != "ghij" {
+		t.Errorf("read after seek got %q, %v", rest, err)
+	}
+	if requests != 2 {
+		t.Errorf("got %d requests, want 2", requests)
+	}
+
+	n, err := r.ReadAt(buf, 10)
+	if err != nil || string(buf[:n]) != "abc" {
+		t.Errorf("ReadAt got %q, %v", buf[:n], err)
+	}
+	n, err = r.ReadAt(buf, 18)
+	if err != io.EOF || string(buf[:n]) != "ij" {
+		t.Errorf("ReadAt at end got %q, %v, want \"ij\", EOF", buf[:n], err)
+	}
+}
+
+func TestOpenV2Download(t *testing.T) {
+	var path string
+	f := newTestFs(t, Options{V2DownloadMinSize: 0}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		_, _ = io.WriteString(w, rangeContent)
+	}))
+	o := f.newObjectWithInfo("file", &drive.File{Id: "id", Size: int64(len(rangeContent))})
+
+	rc, err := o.Open(context.Background())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	got, _ := io.ReadAll(rc)
+	_ = rc.Close()
+	if string(got) != rangeContent || path != "/v2/files/id" {
+		t.Errorf("got %q from %q, want the v2 download", got, path)
+	}
+}
+
+// writerAt collects the data written by a multi-thread download
+type writerAt struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (w *writerAt) WriteAt(p []byte, off int64) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	copy(w.buf[off:], p)
+	return len(p), nil
+}
+
+func (w *writerAt) ReadAt(p []byte, off int64) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n := copy(p, w.buf[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func TestMultiThreadDownload(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	rangeRe := regexp.MustCompile(`^bytes=(\d+)-(\d+)$`)
+	var mu sync.Mutex
+	var ranges []string
+	failed := false
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := rangeRe.FindStringSubmatch(r.Header.Get("Range"))
+		if m == nil {
+			t.Errorf("bad Range %q", r.Header.Get("Range"))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		start, _ := strconv.Atoi(m[1])
+		end, _ := strconv.Atoi(m[2])
+		mu.Lock()
+		ranges = append(ranges, m[1]+"-"+m[2])
+		fail := start == 250 && !failed
+		failed = failed || fail
+		mu.Unlock()
+
+		w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+		w.WriteHeader(http.StatusPartialContent)
+		if fail {
+			// Send half the range then drop the connection
+			_, _ = w.Write(content[start : start+100])
+			return
+		}
+		_, _ = w.Write(content[start : end+1])
+	})
+	f := newTestFs(t, Options{V2DownloadMinSize: -1, MultiThreadStreams: 4, MultiThreadCutoff: 100}, handler)
+	sum, _ := hash.MD5.Sum(content)
+	o := f.newObjectWithInfo("file", &drive.File{Id: "id", Size: int64(len(content)), Md5Checksum: sum})
+
+	w := &writerAt{buf: make([]byte, len(content))}
+	if err := o.MultiThreadDownload(context.Background(), w); err != nil {
+		t.Fatalf("MultiThreadDownload failed: %v", err)
+	}
+	if !bytes.Equal(w.buf, content) {
+		t.Error("downloaded content doesn't match")
+	}
+	sort.Strings(ranges)
+	want := []string{"0-249", "250-499", "350-499", "500-749", "750-999"}
+	if fmt.Sprint(ranges) != fmt.Sprint(want) {
+		t.Errorf("got ranges %v, want %v", ranges, want)
+	}
+
+	// The whole download is checked against the MD5
+	o.md5sum = "00000000000000000000000000000000"
+	err := o.MultiThreadDownload(context.Background(), &writerAt{buf: make([]byte, len(content))})
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("got %v, want a checksum mismatch", err)
+	}
+}
+
+func TestMultiThreadDownloadConnectionLimit(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	rangeRe := regexp.MustCompile(`^bytes=(\d+)-(\d+)$`)
+	var mu sync.Mutex
+	running, most, requests := 0, 0, 0
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		running++
+		requests++
+		most = max(most, running)
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}()
+		m := rangeRe.FindStringSubmatch(r.Header.Get("Range"))
+		start, _ := strconv.Atoi(m[1])
+		end, _ := strconv.Atoi(m[2])
+		w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+		w.WriteHeader(http.StatusPartialContent)
+		// Send the headers, then take a while over the body
+		w.(http.Flusher).Flush()
+		time.Sleep(20 * time.Millisecond)
+		_, _ = w.Write(content[start : end+1])
+	})
+	f := newTestFs(t, Options{V2DownloadMinSize: -1, MultiThreadStreams: 8, MultiThreadCutoff: 100}, handler)
+	f.pacer.SetMaxConnections(2)
+	o := f.newObjectWithInfo("file", &drive.File{Id: "id", Size: int64(len(content))})
+
+	w := &writerAt{buf: make([]byte, len(content))}
+	if err := o.MultiThreadDownload(context.Background(), w); err != nil {
+		t.Fatalf("MultiThreadDownload failed: %v", err)
+	}
+	if !bytes.Equal(w.buf, content) {
+		t.Error("downloaded content doesn't match")
+	}
+	if most > 2 || requests != 2 {
+		t.Errorf("got %d ranges with %d at once, want 2 with at most 2 at once", requests, most)
+	}
+}
+
+func TestOpenChecksum(t *testing.T) {
+	const badMD5 = "00000000000000000000000000000000"
+	sum, _ := hash.MD5.Sum([]byte(rangeContent))
+	for _, test := range []struct {
+		md5sum  string
+		options []fs.OpenOption
+		wantErr bool
+	}{
+		{sum, nil, false},
+		{badMD5, nil, true},
+		{badMD5, []fs.OpenOption{&fs.RangeOption{Start: 2, End: 5}}, false},
+	} {
+		var requests int
+		f := newTestFs(t, Options{V2DownloadMinSize: -1}, rangeServer(t, false, &requests))
+		o := f.newObjectWithInfo("file", &drive.File{Id: "id", Size: int64(len(rangeContent)), Md5Checksum: test.md5sum})
+
+		rc, err := o.Open(context.Background(), test.options...)
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		_, err = io.ReadAll(rc)
+		_ = rc.Close()
+		if test.wantErr != errors.Is(err, ErrChecksumMismatch) {
+			t.Errorf("md5 %s options %v: got error %v", test.md5sum, test.options, err)
+		}
+	}
+}
+
+func TestBwLimit(t *testing.T) {
+	var requests int
+	fr := &fakeResumable{t: t}
+	f := newTestFs(t, Options{ChunkSize: 4, V2DownloadMinSize: -1}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/files/id" {
+			rangeServer(t, false, &requests).ServeHTTP(w, r)
+			return
+		}
+		fr.ServeHTTP(w, r)
+	}))
+	timetable, err := bwlimit.Parse("100B")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.bwLimiter = bwlimit.New(timetable)
+
+	src := &fs.ObjectInfoImpl{RemoteName: "up.txt", FileSize: 10, FileModTime: time.Now()}
+	if _, err := f.PutStream(context.Background(), bytes.NewReader(make([]byte, 10)), src); err != nil {
+		t.Fatalf("PutStream failed: %v", err)
+	}
+	o := f.newObjectWithInfo("file", &drive.File{Id: "id", Size: int64(len(rangeContent))})
+	rc, err := o.Open(context.Background())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	got, _ := io.ReadAll(rc)
+	_ = rc.Close()
+	if string(got) != rangeContent {
+		t.Errorf("got %q", got)
+	}
+
+	// The limiter lets a second's worth through straight away, so another
+	// second's worth only has to wait if the transfers above were counted
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = io.ReadAll(f.BwLimiter().Upload(ctx, bytes.NewReader(make([]byte, 100))))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("upload got %v, want the deadline", err)
+	}
+	_, err = io.ReadAll(f.BwLimiter().Download(ctx, io.NopCloser(bytes.NewReader(make([]byte, 100)))))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("download got %v, want the deadline", err)
+	}
+
+	// Lifting the limits at runtime lets them straight through
+	f.BwLimiter().SetLimits(bwlimit.Limits{})
+	if _, err := io.ReadAll(f.BwLimiter().Upload(context.Background(), bytes.NewReader(make([]byte, 1<<20)))); err != nil {
+		t.Errorf("upload without limits failed: %v", err)
+	}
+}
@@ -0,0 +1,359 @@
+// Package drive implements a Google Drive client for standalone usage
+//
+// This file contains the server-side operations
+package drive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/standalone-gdrive/fs"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Copier   = (*Fs)(nil)
+	_ fs.Mover    = (*Fs)(nil)
+	_ fs.DirMover = (*Fs)(nil)
+	_ fs.Purger   = (*Fs)(nil)
+)
+
+// canServerSide returns true if src belongs to an Fs that f can
+// operate on server-side
+func (f *Fs) canServerSide(src *Fs) bool {
+	return src == f || f.opt.ServerSideAcrossConfigs
+}
+
+// Copy src to this remote using server-side copy operations.
+//
+// The modification time and properties of src are preserved. If an
+// object already exists at remote it is replaced by the copy.
+//
+// If it isn't possible then return fs.ErrorCantCopy
+func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (_ fs.Object, err error) {
+	defer translateErrorp(&err, fs.EntryObject)
+	srcObj, ok := src.(*Object)
+	if !ok || !f.canServerSide(srcObj.fs) {
+		return nil, fs.ErrorCantCopy
+	}
+
+	// Note any object we are going to replace
+	existingObject, err := f.NewObject(ctx, remote)
+	if err != nil && !errors.Is(err, fs.ErrorObjectNotFound) {
+		return nil, err
+	}
+
+	leaf, directoryID, err := f.leafAndDirectoryID(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the properties to carry over to the copy
+	supportsAllDrives := f.isTeamDrive || srcObj.fs.isTeamDrive
+	var srcInfo *drive.File
+	err = f.pacer.Call(ctx, func() (err error) {
+		srcInfo, err = f.svc.Files.Get(srcObj.id).
+			Fields("properties").
+			SupportsAllDrives(supportsAllDrives).
+			Context(ctx).
+			Do()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read properties of %q: %w", srcObj.remote, err)
+	}
+
+	copyInfo := &drive.File{
+		Name:         leaf,
+		Parents:      []string{directoryID},
+		ModifiedTime: srcObj.modifiedDate,
+		Properties:   srcInfo.Properties,
+	}
+	var info *drive.File
+	err = f.pacer.Call(ctx, func() (err error) {
+		info, err = f.svc.Files.Copy(srcObj.id, copyInfo).
+			Fields(googleapi.Field(partialFields)).
+			SupportsAllDrives(supportsAllDrives).
+			KeepRevisionForever(f.opt.KeepRevisionForever).
+			Context(ctx).
+			Do()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't copy %q to %q: %w", srcObj.remote, remote, err)
+	}
+
+	if existingObject != nil {
+		if err := existingObject.Remove(ctx); err != nil {
+			f.LogWarn("failed to remove existing object %q after copy: %v", remote, err)
+		}
+	}
+	return f.newObjectWithInfo(remote, info), nil
+}
+
+// parentIDs returns the IDs of the directories containing o, reading
+// them from Drive if they weren't returned with the object
+func (o *Object) parentIDs(ctx context.Context) ([]string, error) {
+	if len(o.parents) > 0 {
+		return o.parents, nil
+	}
+	var info *drive.File
+	err := o.fs.pacer.Call(ctx, func() (err error) {
+		info, err = o.fs.svc.Files.Get(o.id).
+			Fields("parents").
+			SupportsAllDrives(o.fs.isTeamDrive).
+			Context(ctx).
+			Do()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read parents of %q: %w", o.remote, err)
+	}
+	return info.Parents, nil
+}
+
+// reparent returns the addParents and removeParents parameters needed
+// to move an item from parents into newParent. Either may be empty if
+// nothing needs changing, which the API treats as a no-op.
+func reparent(parents []string, newParent string) (addParent, removeParents string) {
+	addParent = newParent
+	var remove []string
+	for _, parent := range parents {
+		if parent == newParent {
+			addParent = ""
+		} else {
+			remove = append(remove, parent)
+		}
+	}
+	return addParent, strings.Join(remove, ",")
+}
+
+// Move src to this remote using server-side move operations.
+//
+// The object is renamed and reparented in a single Files.Update call
+// so it keeps its ID, share links and revision history. If an object
+// already exists at remote it is replaced.
+//
+// If it isn't possible then return fs.ErrorCantMove
+func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (_ fs.Object, err error) {
+	defer translateErrorp(&err, fs.EntryObject)
+	srcObj, ok := src.(*Object)
+	if !ok || !f.canServerSide(srcObj.fs) {
+		return nil, fs.ErrorCantMove
+	}
+
+	// Note any object we are going to replace
+	existingObject, err := f.NewObject(ctx, remote)
+	if err != nil && !errors.Is(err, fs.ErrorObjectNotFound) {
+		return nil, err
+	}
+
+	leaf, directoryID, err := f.leafAndDirectoryID(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+	srcParents, err := srcObj.parentIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	addParent, removeParents := reparent(srcParents, directoryID)
+	updateInfo := &drive.File{
+		Name:         leaf,
+		ModifiedTime: srcObj.modifiedDate,
+	}
+	var info *drive.File
+	err = f.pacer.Call(ctx, func() (err error) {
+		info, err = f.svc.Files.Update(srcObj.id, updateInfo).
+			AddParents(addParent).
+			RemoveParents(removeParents).
+			Fields(googleapi.Field(partialFields)).
+			SupportsAllDrives(f.isTeamDrive || srcObj.fs.isTeamDrive).
+			Context(ctx).
+			Do()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't move %q to %q: %w", srcObj.remote, remote, err)
+	}
+
+	if existingObject != nil && existingObject.(fs.IDer).ID() != srcObj.id {
+		if err := existingObject.Remove(ctx); err != nil {
+			f.LogWarn("failed to remove existing object %q after move: %v", remote, err)
+		}
+	}
+	return f.newObjectWithInfo(remote, info), nil
+}
+
+// DirMove moves src, srcRemote to this remote at dstRemote using
+// server-side move operations.
+//
+// The directory is renamed and reparented in a single Files.Update
+// call and every cached path under it is rewritten in the directory
+// cache.
+//
+// If it isn't possible then return fs.ErrorCantDirMove
+//
+// If destination exists then return fs.ErrorDirExists
+func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string) (err error) {
+	defer translateErrorp(&err, fs.EntryDirectory)
+	srcFs, ok := src.(*Fs)
+	if !ok || !f.canServerSide(srcFs) || srcRemote == "" {
+		return fs.ErrorCantDirMove
+	}
+
+	// Check the destination doesn't exist
+	_, err = f.dirCache.FindDir(ctx, dstRemote)
+	if err == nil {
+		return fs.ErrorDirExists
+	}
+	if !errors.Is(err, fs.ErrorDirNotFound) {
+		return err
+	}
+
+	// Find the source directory and its parent. A folder shortcut is
+	// moved itself rather than the directory it points at.
+	shortcutID, err := srcFs.dirShortcutID(ctx, srcRemote)
+	if err != nil {
+		return err
+	}
+	srcID, err := srcFs.dirCache.FindDir(ctx, srcRemote)
+	if err != nil {
+		return err
+	}
+	moveID := srcID
+	if shortcutID != "" {
+		moveID = shortcutID
+	}
+	srcDir, srcLeaf := splitPath(srcRemote)
+	srcParentID, err := srcFs.dirCache.FindDir(ctx, srcDir)
+	if err != nil {
+		return err
+	}
+
+	// Find the destination parent
+	dstLeaf, dstParentID, err := f.leafAndDirectoryID(ctx, dstRemote)
+	if err != nil {
+		return err
+	}
+
+	addParent, removeParents := reparent([]string{srcParentID}, dstParentID)
+	updateInfo := &drive.File{
+		Name: dstLeaf,
+	}
+	err = f.pacer.Call(ctx, func() error {
+		_, err := f.svc.Files.Update(moveID, updateInfo).
+			AddParents(addParent).
+			RemoveParents(removeParents).
+			Fields("").
+			SupportsAllDrives(f.isTeamDrive || srcFs.isTeamDrive).
+			Context(ctx).
+			Do()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't move directory %q to %q: %w", srcRemote, dstRemote, err)
+	}
+
+	// Update the directory caches
+	if shortcutID != "" {
+		srcFs.forgetShortcutDir(srcParentID, srcLeaf)
+		f.noteShortcutDir(&drive.File{Id: shortcutID, Name: dstLeaf, Parents: []string{dstParentID}})
+	}
+	switch {
+	case srcFs == f:
+		f.dirCache.MoveDir(srcRemote, dstRemote)
+	case shortcutID != "":
+		srcFs.dirCache.FlushDir(srcRemote)
+		f.dirCache.PutAlias(dstRemote, srcID)
+	default:
+		srcFs.dirCache.FlushDir(srcRemote)
+		f.dirCache.Put(dstRemote, srcID)
+	}
+	return nil
+}
+
+// delete trashes the item with the given ID, or deletes it permanently
+// if useTrash is false. Deleting a directory removes everything in it.
+func (f *Fs) delete(ctx context.Context, id string, useTrash bool) error {
+	return f.pacer.Call(ctx, func() error {
+		if useTrash {
+			// Put the item in the trash instead of deleting it permanently
+			updateInfo := &drive.File{
+				Trashed: true,
+			}
+			_, err := f.svc.Files.Update(id, updateInfo).
+				Fields("").
+				SupportsAllDrives(f.isTeamDrive).
+				Context(ctx).
+				Do()
+			return err
+		}
+		// Delete the item permanently
+		return f.svc.Files.Delete(id).
+			SupportsAllDrives(f.isTeamDrive).
+			Context(ctx).
+			Do()
+	})
+}
+
+// Purge deletes dir and everything in it with a single request.
+//
+// The directory is put in the trash if UseTrash is set, otherwise it
+// is deleted permanently. The subtree is then dropped from the
+// directory cache. Purging the root removes everything in it but
+// leaves the root directory itself.
+func (f *Fs) Purge(ctx context.Context, dir string) (err error) {
+	defer translateErrorp(&err, fs.EntryDirectory)
+	if f.opt.TrashedOnly {
+		return errors.New("can't purge with trashed_only set - remove the files individually instead")
+	}
+	// Only remove the shortcut if dir is one, not what it points to
+	shortcutID, err := f.dirShortcutID(ctx, dir)
+	if err != nil {
+		return err
+	}
+	if shortcutID != "" {
+		return f.removeDirShortcut(ctx, dir, shortcutID)
+	}
+	directoryID, err := f.dirCache.FindDir(ctx, dir)
+	if err != nil {
+		return err
+	}
+	if dir == "" {
+		return f.purgeChildren(ctx, directoryID)
+	}
+	err = f.delete(ctx, directoryID, f.opt.UseTrash)
+	if err != nil {
+		return fmt.Errorf("couldn't purge directory %q: %w", dir, err)
+	}
+	f.dirCache.FlushDir(dir)
+	return nil
+}
+
+// purgeChildren deletes everything in the root directory with
+// directoryID, one request per item, without removing the root
+func (f *Fs) purgeChildren(ctx context.Context, directoryID string) error {
+	// Read everything first as deleting changes the listing
+	var children []*drive.File
+	query := "trashed=false and " + quoteQuery(directoryID) + " in parents"
+	err := f.listPages(ctx, query, func(files []*drive.File) error {
+		children = append(children, files...)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't list the root to purge it: %w", err)
+	}
+	for _, child := range children {
+		if err := f.delete(ctx, child.Id, f.opt.UseTrash); err != nil {
+			return fmt.Errorf("couldn't purge %q: %w", child.Name, err)
+		}
+		f.dirCache.FlushDir(child.Name)
+	}
+	return nil
+}
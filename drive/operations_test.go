@@ -0,0 +1,233 @@
+package drive
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/standalone-gdrive/fs"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// writeJSON writes v as the JSON response
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func TestCopyPreservesModTimeAndProperties(t *testing.T) {
+	var copyReq drive.File
+	mux := http.NewServeMux()
+	mux.HandleFunc("/files", func(w http.ResponseWriter, r *http.Request) {
+		// Nothing exists at the destination
+		writeJSON(w, &drive.FileList{})
+	})
+	mux.HandleFunc("/files/src", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, &drive.File{Properties: map[string]string{"colour": "blue"}})
+	})
+	mux.HandleFunc("/files/src/copy", func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&copyReq); err != nil {
+			t.Errorf("bad copy request: %v", err)
+		}
+		writeJSON(w, &drive.File{Id: "dst", Name: copyReq.Name, ModifiedTime: copyReq.ModifiedTime, Size: 42})
+	})
+	f := newTestFs(t, Options{}, mux)
+
+	src := f.newObjectWithInfo("a.txt", &drive.File{Id: "src", Size: 42, ModifiedTime: "2020-01-02T03:04:05Z"})
+	dst, err := f.Copy(context.Background(), src, "b.txt")
+	if err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	if dst.Remote() != "b.txt" || dst.Size() != 42 {
+		t.Errorf("got %q size %d, want b.txt size 42", dst.Remote(), dst.Size())
+	}
+	if copyReq.Name != "b.txt" || len(copyReq.Parents) != 1 || copyReq.Parents[0] != "root" {
+		t.Errorf("copy sent name %q parents %v", copyReq.Name, copyReq.Parents)
+	}
+	if copyReq.ModifiedTime != "2020-01-02T03:04:05Z" {
+		t.Errorf("copy sent modifiedTime %q", copyReq.ModifiedTime)
+	}
+	if copyReq.Properties["colour"] != "blue" {
+		t.Errorf("copy sent properties %v", copyReq.Properties)
+	}
+}
+
+func TestCopyWhenLookupNotFound(t *testing.T) {
+	lookups := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/files", func(w http.ResponseWriter, r *http.Request) {
+		// Drive says the destination isn't there with a 404
+		lookups++
+		http.Error(w, `{"error":{"code":404,"errors":[{"reason":"notFound"}]}}`, http.StatusNotFound)
+	})
+	mux.HandleFunc("/files/src", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, &drive.File{})
+	})
+	mux.HandleFunc("/files/src/copy", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, &drive.File{Id: "dst", Name: "b.txt"})
+	})
+	f := newTestFs(t, Options{}, mux)
+
+	src := f.newObjectWithInfo("a.txt", &drive.File{Id: "src"})
+	if _, err := f.Copy(context.Background(), src, "b.txt"); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	if lookups != 1 {
+		t.Errorf("got %d lookups, want 1", lookups)
+	}
+}
+
+func TestMoveWhenLookupNotFound(t *testing.T) {
+	var query url.Values
+	mux := http.NewServeMux()
+	mux.HandleFunc("/files", func(w http.ResponseWriter, r *http.Request) {
+		// Drive says the destination isn't there with a 404
+		http.Error(w, `{"error":{"code":404,"errors":[{"reason":"notFound"}]}}`, http.StatusNotFound)
+	})
+	mux.HandleFunc("/files/src", func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		writeJSON(w, &drive.File{Id: "src", Name: "b.txt"})
+	})
+	f := newTestFs(t, Options{}, mux)
+
+	src := f.newObjectWithInfo("a.txt", &drive.File{Id: "src", Parents: []string{"root"}})
+	dst, err := f.Move(context.Background(), src, "b.txt")
+	if err != nil {
+		t.Fatalf("Move failed: %v", err)
+	}
+	if dst.Remote() != "b.txt" || query.Get("addParents") != "" {
+		t.Errorf("got %q, addParents %q", dst.Remote(), query.Get("addParents"))
+	}
+}
+
+func TestCopyAcrossConfigs(t *testing.T) {
+	other := newTestFs(t, Options{}, http.NotFoundHandler())
+	src := other.newObjectWithInfo("a.txt", &drive.File{Id: "src"})
+
+	f := newTestFs(t, Options{}, http.NotFoundHandler())
+	if _, err := f.Copy(context.Background(), src, "a.txt"); err != fs.ErrorCantCopy {
+		t.Errorf("got %v, want fs.ErrorCantCopy", err)
+	}
+}
+
+func TestReparent(t *testing.T) {
+	for _, test := range []struct {
+		parents    []string
+		newParent  string
+		wantAdd    string
+		wantRemove string
+	}{
+		{[]string{"a"}, "b", "b", "a"},
+		{[]string{"a"}, "a", "", ""},
+		{[]string{"a", "b"}, "b", "", "a"},
+		{nil, "b", "b", ""},
+	} {
+		add, remove := reparent(test.parents, test.newParent)
+		if add != test.wantAdd || remove != test.wantRemove {
+			t.Errorf("reparent(%v, %q) = %q, %q; want %q, %q",
+				test.parents, test.newParent, add, remove, test.wantAdd, test.wantRemove)
+		}
+	}
+}
+
+func TestDirMoveRewritesCache(t *testing.T) {
+	var query url.Values
+	mux := http.NewServeMux()
+	mux.HandleFunc("/files", func(w http.ResponseWriter, r *http.Request) {
+		// The destination doesn't exist
+		writeJSON(w, &drive.FileList{})
+	})
+	mux.HandleFunc("/files/id-src", func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		writeJSON(w, &drive.File{})
+	})
+	f := newTestFs(t, Options{}, mux)
+	f.dirCache.Put("src", "id-src")
+	f.dirCache.Put("src/sub", "id-sub")
+	f.dirCache.Put("dst", "id-dst")
+
+	err := f.DirMove(context.Background(), f, "src", "dst/moved")
+	if err != nil {
+		t.Fatalf("DirMove failed: %v", err)
+	}
+	if query.Get("addParents") != "id-dst" || query.Get("removeParents") != "root" {
+		t.Errorf("got addParents %q removeParents %q", query.Get("addParents"), query.Get("removeParents"))
+	}
+	if id, ok := f.dirCache.Get("dst/moved/sub"); !ok || id != "id-sub" {
+		t.Errorf("cache not rewritten: got %q, %v", id, ok)
+	}
+	if _, ok := f.dirCache.Get("src/sub"); ok {
+		t.Error("old path still cached")
+	}
+}
+
+func TestPurge(t *testing.T) {
+	for _, useTrash := range []bool{true, false} {
+		var method string
+		var body drive.File
+		mux := http.NewServeMux()
+		mux.HandleFunc("/files/id-dir", func(w http.ResponseWriter, r *http.Request) {
+			method = r.Method
+			if r.Method == http.MethodDelete {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			writeJSON(w, &drive.File{})
+		})
+		f := newTestFs(t, Options{UseTrash: useTrash}, mux)
+		f.dirCache.Put("dir", "id-dir")
+		f.dirCache.Put("dir/sub", "id-sub")
+
+		if err := f.Purge(context.Background(), "dir"); err != nil {
+			t.Fatalf("Purge failed: %v", err)
+		}
+		if useTrash && (method != http.MethodPatch || !body.Trashed) {
+			t.Errorf("with UseTrash got %s trashed=%v, want a PATCH to trash", method, body.Trashed)
+		}
+		if !useTrash && method != http.MethodDelete {
+			t.Errorf("without UseTrash got %s, want DELETE", method)
+		}
+		if _, ok := f.dirCache.Get("dir/sub"); ok {
+			t.Error("purged subtree still cached")
+		}
+	}
+}
+
+func TestPurgeRoot(t *testing.T) {
+	var deleted []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/files", func(w http.ResponseWriter, r *http.Request) {
+		if q := r.URL.Query().Get("q"); q != "trashed=false and 'root' in parents" {
+			t.Errorf("got query %q", q)
+		}
+		writeJSON(w, &drive.FileList{Files: []*drive.File{
+			{Id: "id-file", Name: "file.txt"},
+			{Id: "id-dir", Name: "dir", MimeType: driveFolderType},
+		}})
+	})
+	mux.HandleFunc("/files/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			t.Errorf("got %s %s, want DELETE", r.Method, r.URL.Path)
+		}
+		deleted = append(deleted, strings.TrimPrefix(r.URL.Path, "/files/"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	f := newTestFs(t, Options{}, mux)
+	f.dirCache.Put("dir", "id-dir")
+	f.dirCache.Put("dir/sub", "id-sub")
+
+	if err := f.Purge(context.Background(), ""); err != nil {
+		t.Fatalf("Purge failed: %v", err)
+	}
+	if strings.Join(deleted, ",") != "id-file,id-dir" {
+		t.Errorf("deleted %v, want only the root's children", deleted)
+	}
+	if _, ok := f.dirCache.Get("dir/sub"); ok {
+		t.Error("purged subtree still cached")
+	}
+}
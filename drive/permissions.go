@@ -0,0 +1,308 @@
+// Package drive implements a Google Drive client for standalone usage
+//
+// This file contains the sharing and permissions management
+package drive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/standalone-gdrive/fs"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// Check the interfaces are satisfied
+var (
+	_ fs.PublicLinker = (*Fs)(nil)
+)
+
+// Types of grantee a permission can be given to
+const (
+	PermissionUser   = "user"
+	PermissionGroup  = "group"
+	PermissionDomain = "domain"
+	PermissionAnyone = "anyone"
+)
+
+// Roles a permission can grant
+const (
+	RoleOwner         = "owner"
+	RoleOrganizer     = "organizer"
+	RoleFileOrganizer = "fileOrganizer"
+	RoleWriter        = "writer"
+	RoleCommenter     = "commenter"
+	RoleReader        = "reader"
+)
+
+// permissionFields are the fields read for each permission
+const permissionFields = "id,type,role,emailAddress,domain,displayName,expirationTime,allowFileDiscovery"
+
+// Permission describes who an item is shared with
+type Permission struct {
+	ID                 string    // ID of the permission, set by Drive
+	Type               string    // PermissionUser, PermissionGroup, PermissionDomain or PermissionAnyone
+	Role               string    // One of the Role constants
+	EmailAddress       string    // Email address of the user or group
+	Domain             string    // Domain the permission is for
+	DisplayName        string    // Name of the grantee, set by Drive
+	Expires            time.Time // When the permission expires, zero for never
+	AllowFileDiscovery bool      // Whether domain and anyone grantees can find the item by searching
+}
+
+// newPermission converts the permission info into a Permission
+func newPermission(info *drive.Permission) *Permission {
+	expires, _ := time.Parse(timeFormatIn, info.ExpirationTime)
+	return &Permission{
+		ID:                 info.Id,
+		Type:               info.Type,
+		Role:               info.Role,
+		EmailAddress:       info.EmailAddress,
+		Domain:             info.Domain,
+		DisplayName:        info.DisplayName,
+		Expires:            expires,
+		AllowFileDiscovery: info.AllowFileDiscovery,
+	}
+}
+
+// permissionKey returns the key of the permission with id on the file
+// with fileID in the permissions map. Drive gives a user or group the
+// same permission ID on every file, with whatever role it has there,
+// so the ID alone isn't enough.
+func permissionKey(fileID, id string) string {
+	return fileID + "/" + id
+}
+
+// cachePermission remembers info on the file with fileID in the
+// permissions map
+func (f *Fs) cachePermission(fileID string, info *drive.Permission) {
+	f.permissionsMu.Lock()
+	f.permissions[permissionKey(fileID, info.Id)] = info
+	f.permissionsMu.Unlock()
+}
+
+// cachedPermission returns the permission with id on the file with
+// fileID from the permissions map, if it is there
+func (f *Fs) cachedPermission(fileID, id string) (info *drive.Permission, ok bool) {
+	f.permissionsMu.Lock()
+	info, ok = f.permissions[permissionKey(fileID, id)]
+	f.permissionsMu.Unlock()
+	return info, ok
+}
+
+// uncachePermission removes the permission with id on the file with
+// fileID from the permissions map
+func (f *Fs) uncachePermission(fileID, id string) {
+	f.permissionsMu.Lock()
+	delete(f.permissions, permissionKey(fileID, id))
+	f.permissionsMu.Unlock()
+}
+
+// uncacheFilePermissions removes all the permissions of the file with
+// fileID from the permissions map
+func (f *Fs) uncacheFilePermissions(fileID string) {
+	prefix := permissionKey(fileID, "")
+	f.permissionsMu.Lock()
+	for key := range f.permissions {
+		if strings.HasPrefix(key, prefix) {
+			delete(f.permissions, key)
+		}
+	}
+	f.permissionsMu.Unlock()
+}
+
+// itemID returns the ID of the directory or object at remote
+func (f *Fs) itemID(ctx context.Context, remote string) (string, error) {
+	id, err := f.dirCache.FindDir(ctx, remote)
+	if !errors.Is(err, fs.ErrorDirNotFound) {
+		return id, err
+	}
+	o, err := f.NewObject(ctx, remote)
+	if err != nil {
+		return "", err
+	}
+	return o.(fs.IDer).ID(), nil
+}
+
+// ListPermissions returns the permissions of the file or directory at
+// remote
+func (f *Fs) ListPermissions(ctx context.Context, remote string) (_ []*Permission, err error) {
+	defer translateErrorp(&err, fs.EntryObject)
+	id, err := f.itemID(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+	var permissions []*Permission
+	pageToken := ""
+	for {
+		var list *drive.PermissionList
+		err := f.pacer.Call(ctx, func() (err error) {
+			call := f.svc.Permissions.List(id).
+				Fields(googleapi.Field("nextPageToken,permissions(" + permissionFields + ")")).
+				SupportsAllDrives(f.isTeamDrive).
+				Context(ctx)
+			if pageToken != "" {
+				call = call.PageToken(pageToken)
+			}
+			list, err = call.Do()
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("couldn't list permissions of %q: %w", remote, err)
+		}
+		for _, info := range list.Permissions {
+			f.cachePermission(id, info)
+			permissions = append(permissions, newPermission(info))
+		}
+		if list.NextPageToken == "" {
+			return permissions, nil
+		}
+		pageToken = list.NextPageToken
+	}
+}
+
+// AddPermission shares the file or directory at remote as described
+// by permission, returning the permission Drive created.
+//
+// Type and Role must be set, along with EmailAddress for users and
+// groups and Domain for domains. Drive only allows users and groups to
+// have an expiry.
+func (f *Fs) AddPermission(ctx context.Context, remote string, permission *Permission) (_ *Permission, err error) {
+	defer translateErrorp(&err, fs.EntryObject)
+	id, err := f.itemID(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+	createInfo := &drive.Permission{
+		Type:               permission.Type,
+		Role:               permission.Role,
+		EmailAddress:       permission.EmailAddress,
+		Domain:             permission.Domain,
+		AllowFileDiscovery: permission.AllowFileDiscovery,
+	}
+	if !permission.Expires.IsZero() {
+		createInfo.ExpirationTime = permission.Expires.Format(timeFormatOut)
+	}
+	return f.createPermission(ctx, remote, id, createInfo, false)
+}
+
+// grantee describes who info grants access to
+func grantee(info *drive.Permission) string {
+	switch {
+	case info.EmailAddress != "":
+		return info.EmailAddress
+	case info.Domain != "":
+		return info.Domain
+	}
+	return info.Type
+}
+
+// createPermission creates the permission createInfo on the item with
+// id found at remote
+func (f *Fs) createPermission(ctx context.Context, remote, id string, createInfo *drive.Permission, transferOwnership bool) (*Permission, error) {
+	var info *drive.Permission
+	err := f.pacer.Call(ctx, func() (err error) {
+		info, err = f.svc.Permissions.Create(id, createInfo).
+			Fields(googleapi.Field(permissionFields)).
+			SupportsAllDrives(f.isTeamDrive).
+			TransferOwnership(transferOwnership).
+			Context(ctx).
+			Do()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't share %q with %s: %w", remote, grantee(createInfo), err)
+	}
+	if transferOwnership {
+		// The previous owner's role has changed too
+		f.uncacheFilePermissions(id)
+	}
+	f.cachePermission(id, info)
+	return newPermission(info), nil
+}
+
+// RemovePermission removes the permission with permissionID from the
+// file or directory at remote
+func (f *Fs) RemovePermission(ctx context.Context, remote, permissionID string) (err error) {
+	defer translateErrorp(&err, fs.EntryObject)
+	id, err := f.itemID(ctx, remote)
+	if err != nil {
+		return err
+	}
+	err = f.pacer.Call(ctx, func() error {
+		return f.svc.Permissions.Delete(id, permissionID).
+			SupportsAllDrives(f.isTeamDrive).
+			Context(ctx).
+			Do()
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't remove permission %q from %q: %w", permissionID, remote, err)
+	}
+	f.uncachePermission(id, permissionID)
+	return nil
+}
+
+// TransferOwnership makes the user with email the owner of the file
+// or directory at remote. The current owner is made a writer.
+//
+// Items in shared drives belong to the drive so can't change owner.
+func (f *Fs) TransferOwnership(ctx context.Context, remote, email string) (err error) {
+	defer translateErrorp(&err, fs.EntryObject)
+	if f.isTeamDrive {
+		return fmt.Errorf("can't transfer ownership of %q: items in shared drives are owned by the drive", remote)
+	}
+	id, err := f.itemID(ctx, remote)
+	if err != nil {
+		return err
+	}
+	_, err = f.createPermission(ctx, remote, id, &drive.Permission{
+		Type:         PermissionUser,
+		Role:         RoleOwner,
+		EmailAddress: email,
+	}, true)
+	return err
+}
+
+// PublicLink shares the file or directory at remote with anyone who
+// has the link and returns the link. If unlink is set the sharing is
+// removed instead.
+//
+// Drive can't expire permissions for anyone, so a non zero expire is
+// refused rather than making a link which never expires.
+func (f *Fs) PublicLink(ctx context.Context, remote string, expire time.Duration, unlink bool) (_ string, err error) {
+	defer translateErrorp(&err, fs.EntryObject)
+	if expire != 0 && !unlink {
+		return "", fmt.Errorf("can't make a link to %q which expires: drive only expires permissions for users and groups", remote)
+	}
+	id, err := f.itemID(ctx, remote)
+	if err != nil {
+		return "", err
+	}
+	if unlink {
+		permissions, err := f.ListPermissions(ctx, remote)
+		if err != nil {
+			return "", err
+		}
+		for _, permission := range permissions {
+			if permission.Type == PermissionAnyone {
+				if err := f.RemovePermission(ctx, remote, permission.ID); err != nil {
+					return "", err
+				}
+			}
+		}
+		return "", nil
+	}
+
+	_, err = f.createPermission(ctx, remote, id, &drive.Permission{
+		Type: PermissionAnyone,
+		Role: RoleReader,
+	}, false)
+	if err != nil {
+		return "", err
+	}
+	return "https://drive.google.com/open?id=" + id, nil
+}
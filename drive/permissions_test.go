@@ -0,0 +1,133 @@
+package drive
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// fakePermissions serves the permissions of the directory "dir"
+type fakePermissions struct {
+	t           *testing.T
+	permissions []*drive.Permission
+	created     []*drive.Permission
+	transfer    []string
+	deleted     []string
+}
+
+func (fp *fakePermissions) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/files":
+		writeJSON(w, &drive.FileList{Files: []*drive.File{{Id: "dirid", Name: "dir", MimeType: driveFolderType}}})
+	case r.URL.Path == "/files/dirid/permissions" && r.Method == http.MethodGet:
+		list := &drive.PermissionList{}
+		if r.URL.Query().Get("pageToken") == "" {
+			list.Permissions = fp.permissions[:1]
+			list.NextPageToken = "next"
+		} else {
+			list.Permissions = fp.permissions[1:]
+		}
+		writeJSON(w, list)
+	case r.URL.Path == "/files/dirid/permissions" && r.Method == http.MethodPost:
+		var info drive.Permission
+		_ = json.NewDecoder(r.Body).Decode(&info)
+		fp.created = append(fp.created, &info)
+		fp.transfer = append(fp.transfer, r.URL.Query().Get("transferOwnership"))
+		info.Id = "new"
+		writeJSON(w, &info)
+	case strings.HasPrefix(r.URL.Path, "/files/dirid/permissions/") && r.Method == http.MethodDelete:
+		fp.deleted = append(fp.deleted, strings.TrimPrefix(r.URL.Path, "/files/dirid/permissions/"))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		fp.t.Errorf("unexpected %s %s", r.Method, r.URL)
+		http.Error(w, "unexpected", http.StatusBadRequest)
+	}
+}
+
+func newFakePermissions(t *testing.T) *fakePermissions {
+	return &fakePermissions{t: t, permissions: []*drive.Permission{
+		{Id: "owner", Type: PermissionUser, Role: RoleOwner, EmailAddress: "me@example.com"},
+		{Id: "anyoneWithLink", Type: PermissionAnyone, Role: RoleReader},
+	}}
+}
+
+func TestPermissions(t *testing.T) {
+	ctx := context.Background()
+	fp := newFakePermissions(t)
+	f := newTestFs(t, Options{}, fp)
+
+	permissions, err := f.ListPermissions(ctx, "dir")
+	if err != nil {
+		t.Fatalf("ListPermissions failed: %v", err)
+	}
+	if len(permissions) != 2 || permissions[0].EmailAddress != "me@example.com" || permissions[1].Type != PermissionAnyone {
+		t.Errorf("got permissions %+v", permissions)
+	}
+	if len(f.permissions) != 2 {
+		t.Errorf("got %d cached permissions, want 2", len(f.permissions))
+	}
+
+	expires := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	added, err := f.AddPermission(ctx, "dir", &Permission{
+		Type:         PermissionGroup,
+		Role:         RoleWriter,
+		EmailAddress: "team@example.com",
+		Expires:      expires,
+	})
+	if err != nil {
+		t.Fatalf("AddPermission failed: %v", err)
+	}
+	if !added.Expires.Equal(expires) || added.Role != RoleWriter || fp.transfer[0] != "false" {
+		t.Errorf("got permission %+v, transferOwnership %q", added, fp.transfer[0])
+	}
+
+	if err := f.RemovePermission(ctx, "dir", "new"); err != nil {
+		t.Fatalf("RemovePermission failed: %v", err)
+	}
+	if _, ok := f.cachedPermission("dirid", "new"); ok || len(fp.deleted) != 1 {
+		t.Errorf("permission wasn't removed: deleted %v", fp.deleted)
+	}
+
+	if err := f.TransferOwnership(ctx, "dir", "boss@example.com"); err != nil {
+		t.Fatalf("TransferOwnership failed: %v", err)
+	}
+	if p := fp.created[1]; p.Role != RoleOwner || p.EmailAddress != "boss@example.com" || fp.transfer[1] != "true" {
+		t.Errorf("got permission %+v, transferOwnership %q", p, fp.transfer[1])
+	}
+}
+
+func TestPublicLink(t *testing.T) {
+	ctx := context.Background()
+	fp := newFakePermissions(t)
+	f := newTestFs(t, Options{}, fp)
+	if f.Features().PublicLink == nil {
+		t.Fatal("Features().PublicLink should be set")
+	}
+
+	link, err := f.PublicLink(ctx, "dir", 0, false)
+	if err != nil {
+		t.Fatalf("PublicLink failed: %v", err)
+	}
+	if link != "https://drive.google.com/open?id=dirid" {
+		t.Errorf("got link %q", link)
+	}
+	if p := fp.created[0]; p.Type != PermissionAnyone || p.Role != RoleReader {
+		t.Errorf("got permission %+v", p)
+	}
+
+	if _, err := f.PublicLink(ctx, "dir", time.Hour, false); err == nil {
+		t.Error("expected an error making a link which expires")
+	}
+
+	if _, err := f.PublicLink(ctx, "dir", 0, true); err != nil {
+		t.Fatalf("PublicLink unlink failed: %v", err)
+	}
+	if strings.Join(fp.deleted, ",") != "anyoneWithLink" {
+		t.Errorf("deleted %v, want only the anyone permission", fp.deleted)
+	}
+}
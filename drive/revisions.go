@@ -0,0 +1,176 @@
+// Package drive implements a Google Drive client for standalone usage
+//
+// This file contains the listing and management of file revisions
+package drive
+
+import (
+	"context"
+	"fmt"
+	"io"
+	neturl "net/url"
+	"time"
+
+	"github.com/standalone-gdrive/fs"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// revisionFields are the fields read for each revision
+const revisionFields = "id,modifiedTime,size,md5Checksum,keepForever,mimeType"
+
+// Revision describes a stored revision of an object's content
+type Revision struct {
+	ID          string    // ID of the revision
+	Size        int64     // Size of the content in bytes
+	ModTime     time.Time // When the revision was made
+	MD5         string    // MD5 checksum of the content
+	MimeType    string    // MIME type of the content
+	KeepForever bool      // Whether the revision is pinned
+}
+
+// newRevision converts the revision info into a Revision
+func newRevision(info *drive.Revision) *Revision {
+	modTime, _ := time.Parse(timeFormatIn, info.ModifiedTime)
+	return &Revision{
+		ID:          info.Id,
+		Size:        info.Size,
+		ModTime:     modTime,
+		MD5:         info.Md5Checksum,
+		MimeType:    info.MimeType,
+		KeepForever: info.KeepForever,
+	}
+}
+
+// Revisions returns the revisions of the object, oldest first. The
+// last one is the current content of the object.
+func (o *Object) Revisions(ctx context.Context) (_ []*Revision, err error) {
+	defer translateErrorp(&err, fs.EntryObject)
+	var revisions []*Revision
+	pageToken := ""
+	for {
+		var list *drive.RevisionList
+		err := o.fs.pacer.Call(ctx, func() (err error) {
+			call := o.fs.svc.Revisions.List(o.id).
+				Fields(googleapi.Field("nextPageToken,revisions(" + revisionFields + ")")).
+				PageSize(1000).
+				Context(ctx)
+			if pageToken != "" {
+				call = call.PageToken(pageToken)
+			}
+			list, err = call.Do()
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("couldn't list revisions of %q: %w", o.remote, err)
+		}
+		for _, info := range list.Revisions {
+			revisions = append(revisions, newRevision(info))
+		}
+		if list.NextPageToken == "" {
+			return revisions, nil
+		}
+		pageToken = list.NextPageToken
+	}
+}
+
+// OpenRevision opens the content of the revision with revisionID.
+//
+// Range options are honoured as they are in Open, and whole downloads
+// are checked against the MD5 checksum of the revision.
+func (o *Object) OpenRevision(ctx context.Context, revisionID string, options ...fs.OpenOption) (_ io.ReadCloser, err error) {
+	defer translateErrorp(&err, fs.EntryObject)
+	var info *drive.Revision
+	err = o.fs.pacer.Call(ctx, func() (err error) {
+		info, err = o.fs.svc.Revisions.Get(o.id, revisionID).
+			Fields(googleapi.Field(revisionFields)).
+			Context(ctx).
+			Do()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't find revision %q of %q: %w", revisionID, o.remote, err)
+	}
+	fs.FixRangeOption(options, info.Size)
+
+	downloadURL := o.fs.svc.BasePath + "files/" + neturl.PathEscape(o.id) +
+		"/revisions/" + neturl.PathEscape(revisionID) + "?alt=media"
+	if o.fs.opt.AcknowledgeAbuse {
+		downloadURL += "&acknowledgeAbuse=true"
+	}
+	rc, err := o.fs.download(ctx, downloadURL, options)
+	if err != nil {
+		return nil, err
+	}
+	if o.fs.opt.DisableChecksum || isRanged(options) || info.Md5Checksum == "" {
+		return rc, nil
+	}
+	return newCheckingReadCloser(rc, o.remote, info.Md5Checksum, ""), nil
+}
+
+// SetKeepForever pins or unpins the revision with revisionID. Pinned
+// revisions are never removed automatically by Drive.
+func (o *Object) SetKeepForever(ctx context.Context, revisionID string, keep bool) (err error) {
+	defer translateErrorp(&err, fs.EntryObject)
+	updateInfo := &drive.Revision{
+		KeepForever:     keep,
+		ForceSendFields: []string{"KeepForever"},
+	}
+	err = o.fs.pacer.Call(ctx, func() error {
+		_, err := o.fs.svc.Revisions.Update(o.id, revisionID, updateInfo).
+			Fields("id").
+			Context(ctx).
+			Do()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't set keepForever on revision %q of %q: %w", revisionID, o.remote, err)
+	}
+	return nil
+}
+
+// DeleteRevision deletes the revision with revisionID. The current
+// revision of an object can't be deleted.
+func (o *Object) DeleteRevision(ctx context.Context, revisionID string) (err error) {
+	defer translateErrorp(&err, fs.EntryObject)
+	err = o.fs.pacer.Call(ctx, func() error {
+		return o.fs.svc.Revisions.Delete(o.id, revisionID).Context(ctx).Do()
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't delete revision %q of %q: %w", revisionID, o.remote, err)
+	}
+	return nil
+}
+
+// PruneRevisions deletes old revisions of the object, returning how
+// many were deleted.
+//
+// A revision is deleted if it is older than maxAge or isn't one of the
+// newest keep revisions. Either limit is ignored if it is 0. The
+// current revision and pinned revisions are never deleted.
+func (o *Object) PruneRevisions(ctx context.Context, maxAge time.Duration, keep int) (_ int, err error) {
+	defer translateErrorp(&err, fs.EntryObject)
+	revisions, err := o.Revisions(ctx)
+	if err != nil {
+		return 0, err
+	}
+	cutoff := time.Now().Add(-maxAge)
+	deleted := 0
+	for i, revision := range revisions {
+		newer := len(revisions) - 1 - i
+		if newer == 0 || revision.KeepForever {
+			continue
+		}
+		tooOld := maxAge > 0 && revision.ModTime.Before(cutoff)
+		tooMany := keep > 0 && newer >= keep
+		if !tooOld && !tooMany {
+			continue
+		}
+		if err := o.DeleteRevision(ctx, revision.ID); err != nil {
+			return deleted, err
+		}
+		o.fs.LogDebug("%q: deleted revision %q from %v", o.remote, revision.ID, revision.ModTime)
+		deleted++
+	}
+	return deleted, nil
+}
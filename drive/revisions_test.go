@@ -0,0 +1,161 @@
+package drive
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/standalone-gdrive/fs/hash"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// fakeRevisions serves the revisions of the file "id", two per page
+type fakeRevisions struct {
+	t         *testing.T
+	revisions []*drive.Revision
+	content   map[string]string
+	deleted   []string
+	pinned    map[string]bool
+}
+
+func (fr *fakeRevisions) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/files/id/revisions")
+	revisionID := strings.TrimPrefix(rest, "/")
+	switch {
+	case rest == "" && r.Method == http.MethodGet:
+		start := 0
+		if token := r.URL.Query().Get("pageToken"); token != "" {
+			start = len(token)
+		}
+		end := start + 2
+		list := &drive.RevisionList{}
+		if end < len(fr.revisions) {
+			list.NextPageToken = strings.Repeat("x", end)
+		} else {
+			end = len(fr.revisions)
+		}
+		list.Revisions = fr.revisions[start:end]
+		writeJSON(w, list)
+	case r.Method == http.MethodGet && r.URL.Query().Get("alt") == "media":
+		_, _ = io.WriteString(w, fr.content[revisionID])
+	case r.Method == http.MethodGet:
+		for _, revision := range fr.revisions {
+			if revision.Id == revisionID {
+				writeJSON(w, revision)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	case r.Method == http.MethodPatch:
+		var info map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&info)
+		fr.pinned[revisionID] = info["keepForever"] == true
+		writeJSON(w, &drive.Revision{Id: revisionID})
+	case r.Method == http.MethodDelete:
+		fr.deleted = append(fr.deleted, revisionID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		fr.t.Errorf("unexpected %s %s", r.Method, r.URL)
+		http.Error(w, "unexpected", http.StatusBadRequest)
+	}
+}
+
+func newFakeRevisions(t *testing.T) *fakeRevisions {
+	now := time.Now()
+	fr := &fakeRevisions{
+		t:       t,
+		content: map[string]string{"r1": "first", "r2": "second"},
+		pinned:  map[string]bool{},
+	}
+	for i, id := range []string{"r1", "r2", "r3", "r4", "r5"} {
+		sum, _ := hash.MD5.Sum([]byte(fr.content[id]))
+		fr.revisions = append(fr.revisions, &drive.Revision{
+			Id:           id,
+			Size:         int64(len(fr.content[id])),
+			Md5Checksum:  sum,
+			ModifiedTime: now.Add(time.Duration(i-5) * 24 * time.Hour).Format(timeFormatOut),
+			KeepForever:  id == "r2",
+		})
+	}
+	return fr
+}
+
+func TestRevisions(t *testing.T) {
+	fr := newFakeRevisions(t)
+	f := newTestFs(t, Options{}, fr)
+	o := f.newObjectWithInfo("config.json", &drive.File{Id: "id"})
+
+	revisions, err := o.Revisions(context.Background())
+	if err != nil {
+		t.Fatalf("Revisions failed: %v", err)
+	}
+	if len(revisions) != 5 {
+		t.Fatalf("got %d revisions, want 5 across pages", len(revisions))
+	}
+	if r := revisions[1]; r.ID != "r2" || r.Size != 6 || !r.KeepForever || r.ModTime.IsZero() {
+		t.Errorf("got revision %+v", r)
+	}
+
+	rc, err := o.OpenRevision(context.Background(), "r1")
+	if err != nil {
+		t.Fatalf("OpenRevision failed: %v", err)
+	}
+	got, err := io.ReadAll(rc)
+	_ = rc.Close()
+	if err != nil || string(got) != "first" {
+		t.Errorf("got %q, %v, want first", got, err)
+	}
+
+	fr.content["r1"] = "tampered"
+	rc, err = o.OpenRevision(context.Background(), "r1")
+	if err != nil {
+		t.Fatalf("OpenRevision failed: %v", err)
+	}
+	_, err = io.ReadAll(rc)
+	_ = rc.Close()
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("got %v, want checksum mismatch", err)
+	}
+
+	if err := o.SetKeepForever(context.Background(), "r2", false); err != nil {
+		t.Fatalf("SetKeepForever failed: %v", err)
+	}
+	if pinned, ok := fr.pinned["r2"]; !ok || pinned {
+		t.Errorf("keepForever wasn't cleared: %v", fr.pinned)
+	}
+}
+
+func TestPruneRevisions(t *testing.T) {
+	for _, test := range []struct {
+		maxAge time.Duration
+		keep   int
+		want   string
+	}{
+		{0, 0, ""},
+		{0, 2, "r1,r3"},
+		{60 * time.Hour, 0, "r1,r3"},
+		{100 * time.Hour, 0, "r1"},
+		{100 * time.Hour, 4, "r1"},
+		{time.Hour, 0, "r1,r3,r4"},
+	} {
+		fr := newFakeRevisions(t)
+		f := newTestFs(t, Options{}, fr)
+		o := f.newObjectWithInfo("config.json", &drive.File{Id: "id"})
+
+		n, err := o.PruneRevisions(context.Background(), test.maxAge, test.keep)
+		if err != nil {
+			t.Fatalf("PruneRevisions failed: %v", err)
+		}
+		sort.Strings(fr.deleted)
+		if got := strings.Join(fr.deleted, ","); got != test.want || n != len(fr.deleted) {
+			t.Errorf("maxAge %v keep %d: deleted %q (%d), want %q", test.maxAge, test.keep, got, n, test.want)
+		}
+	}
+}
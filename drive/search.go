@@ -0,0 +1,218 @@
+// Package drive implements a Google Drive client for standalone usage
+//
+// This file contains the search API
+package drive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/standalone-gdrive/fs"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// quoteQuery quotes s as a string in a Drive query, escaping
+// backslashes and single quotes
+func quoteQuery(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `'`, `\'`)
+	return "'" + s + "'"
+}
+
+// Query describes the files to Search for. Only the fields which are
+// set are used, and all of them must match.
+type Query struct {
+	Name           string            // Exact name
+	NameContains   string            // Part of the name
+	MimeType       string            // Exact MIME type
+	ModifiedAfter  time.Time         // Modified after this time
+	ModifiedBefore time.Time         // Modified before this time
+	CreatedAfter   time.Time         // Created after this time
+	CreatedBefore  time.Time         // Created before this time
+	FullText       string            // Text in the name, description or content
+	Starred        bool              // Only starred files
+	Owner          string            // Email address of the owner
+	Properties     map[string]string // Properties which must have these values
+	Trashed        bool              // Search the trash instead of live files
+	Limit          int               // Maximum number of results, 0 for no limit
+}
+
+// queryTime formats t for a Drive query
+func queryTime(t time.Time) string {
+	return quoteQuery(t.UTC().Format(time.RFC3339))
+}
+
+// String returns the Drive query expression for q
+func (q *Query) String() string {
+	terms := []string{fmt.Sprintf("trashed=%t", q.Trashed)}
+	if q.Name != "" {
+		terms = append(terms, "name="+quoteQuery(q.Name))
+	}
+	if q.NameContains != "" {
+		terms = append(terms, "name contains "+quoteQuery(q.NameContains))
+	}
+	if q.MimeType != "" {
+		terms = append(terms, "mimeType="+quoteQuery(q.MimeType))
+	}
+	if !q.ModifiedAfter.IsZero() {
+		terms = append(terms, "modifiedTime > "+queryTime(q.ModifiedAfter))
+	}
+	if !q.ModifiedBefore.IsZero() {
+		terms = append(terms, "modifiedTime < "+queryTime(q.ModifiedBefore))
+	}
+	if !q.CreatedAfter.IsZero() {
+		terms = append(terms, "createdTime > "+queryTime(q.CreatedAfter))
+	}
+	if !q.CreatedBefore.IsZero() {
+		terms = append(terms, "createdTime < "+queryTime(q.CreatedBefore))
+	}
+	if q.FullText != "" {
+		terms = append(terms, "fullText contains "+quoteQuery(q.FullText))
+	}
+	if q.Starred {
+		terms = append(terms, "starred=true")
+	}
+	if q.Owner != "" {
+		terms = append(terms, quoteQuery(q.Owner)+" in owners")
+	}
+	keys := make([]string, 0, len(q.Properties))
+	for key := range q.Properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		terms = append(terms, fmt.Sprintf("properties has { key=%s and value=%s }", quoteQuery(key), quoteQuery(q.Properties[key])))
+	}
+	return strings.Join(terms, " and ")
+}
+
+// errNotUnderRoot is returned by searchPath for directories outside
+// the root of the Fs
+var errNotUnderRoot = errors.New("not under the root")
+
+// searcher resolves the paths of search results
+type searcher struct {
+	f          *Fs
+	rootID     string          // ID of the root of the Fs
+	notUnder   map[string]bool // IDs of directories outside the root
+	trueRootID string          // real ID of My Drive if rootID is the "root" alias
+}
+
+// dirPath returns the path of the directory with id, looking up its
+// parents and caching them in the dircache if it isn't cached already
+func (s *searcher) dirPath(ctx context.Context, id string) (string, error) {
+	if id == s.rootID || id == s.trueRootID {
+		return "", nil
+	}
+	if dirPath, ok := s.f.dirCache.GetInv(id); ok {
+		return dirPath, nil
+	}
+	if s.notUnder[id] {
+		return "", errNotUnderRoot
+	}
+
+	var info *drive.File
+	err := s.f.pacer.Call(ctx, func() (err error) {
+		info, err = s.f.svc.Files.Get(id).
+			Fields("id,name,parents").
+			SupportsAllDrives(s.f.isTeamDrive).
+			Context(ctx).
+			Do()
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("couldn't find directory with ID %q: %w", id, err)
+	}
+	if len(info.Parents) == 0 {
+		s.notUnder[id] = true
+		return "", errNotUnderRoot
+	}
+	parentPath, err := s.dirPath(ctx, info.Parents[0])
+	if err != nil {
+		if errors.Is(err, errNotUnderRoot) {
+			s.notUnder[id] = true
+		}
+		return "", err
+	}
+	dirPath := path.Join(parentPath, info.Name)
+	s.f.dirCache.Put(dirPath, id)
+	return dirPath, nil
+}
+
+// Search finds the files and directories matching q anywhere in the
+// drive, without walking it.
+//
+// The paths of the results are found through the dircache, reading
+// the parents of directories which aren't cached yet. Results outside
+// the root of the Fs are left out.
+func (f *Fs) Search(ctx context.Context, q Query) (_ fs.DirEntries, err error) {
+	defer translateErrorp(&err, fs.EntryDirectory)
+	rootID, err := f.dirCache.FindDir(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	s := &searcher{
+		f:        f,
+		rootID:   rootID,
+		notUnder: map[string]bool{},
+	}
+	if rootID == "root" {
+		err := f.pacer.Call(ctx, func() error {
+			info, err := f.svc.Files.Get("root").Fields(googleapi.Field("id")).Context(ctx).Do()
+			if err == nil {
+				s.trueRootID = info.Id
+			}
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read the root ID: %w", err)
+		}
+	}
+
+	var entries fs.DirEntries
+	err = f.listPages(ctx, q.String(), func(files []*drive.File) error {
+		targets, err := f.findShortcutTargets(ctx, files)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			dirPath, err := "", errNotUnderRoot
+			for _, parent := range file.Parents {
+				dirPath, err = s.dirPath(ctx, parent)
+				if !errors.Is(err, errNotUnderRoot) {
+					break
+				}
+			}
+			if errors.Is(err, errNotUnderRoot) {
+				f.LogDebug("%q: skipping search result outside the root", file.Name)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			entry, err := f.itemToDirEntry(ctx, path.Join(dirPath, file.Name), file, targets)
+			if err != nil {
+				return err
+			}
+			if entry == nil {
+				continue
+			}
+			entries = append(entries, entry)
+			if q.Limit > 0 && len(entries) >= q.Limit {
+				return errStopListing
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't search for %q: %w", q.String(), err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,109 @@
+package drive
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func TestQuoteQuery(t *testing.T) {
+	for in, want := range map[string]string{
+		"plain":       `'plain'`,
+		`it's`:        `'it\'s'`,
+		`back\slash`:  `'back\\slash'`,
+		`say "hello"`: `'say "hello"'`,
+		"café \t":     "'café \t'",
+	} {
+		if got := quoteQuery(in); got != want {
+			t.Errorf("quoteQuery(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestQueryString(t *testing.T) {
+	when := time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("", 3600))
+	q := Query{
+		NameContains:   "report's",
+		MimeType:       "application/pdf",
+		ModifiedAfter:  when,
+		ModifiedBefore: when.Add(time.Hour),
+		CreatedAfter:   when,
+		FullText:       "budget",
+		Starred:        true,
+		Owner:          "me@example.com",
+		Properties:     map[string]string{"team": "ops", "env": "prod"},
+	}
+	want := `trashed=false and name contains 'report\'s' and mimeType='application/pdf' and ` +
+		`modifiedTime > '2024-01-02T02:04:05Z' and modifiedTime < '2024-01-02T03:04:05Z' and ` +
+		`createdTime > '2024-01-02T02:04:05Z' and fullText contains 'budget' and starred=true and ` +
+		`'me@example.com' in owners and properties has { key='env' and value='prod' } and ` +
+		`properties has { key='team' and value='ops' }`
+	if got := q.String(); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+	if got := (&Query{Trashed: true}).String(); got != "trashed=true" {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	folders := map[string]*drive.File{
+		"root":    {Id: "realroot"},
+		"a":       {Id: "a", Name: "a", Parents: []string{"realroot"}},
+		"b":       {Id: "b", Name: "b", Parents: []string{"a"}},
+		"outside": {Id: "outside", Name: "outside"},
+	}
+	gets := map[string]int{}
+	var query string
+	f := newTestFs(t, Options{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/files" {
+			query = r.URL.Query().Get("q")
+			writeJSON(w, &drive.FileList{Files: []*drive.File{
+				{Id: "1", Name: "deep.txt", Parents: []string{"b"}},
+				{Id: "2", Name: "away.txt", Parents: []string{"outside"}},
+				{Id: "3", Name: "top.txt", Parents: []string{"realroot"}},
+				{Id: "b", Name: "b", MimeType: driveFolderType, Parents: []string{"a"}},
+				{Id: "5", Name: "also.txt", Parents: []string{"b"}},
+			}})
+			return
+		}
+		id := strings.TrimPrefix(r.URL.Path, "/files/")
+		gets[id]++
+		writeJSON(w, folders[id])
+	}))
+
+	entries, err := f.Search(context.Background(), Query{NameContains: "t"})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if query != "trashed=false and name contains 't'" {
+		t.Errorf("got query %s", query)
+	}
+	var remotes []string
+	for _, entry := range entries {
+		remotes = append(remotes, entry.Remote())
+	}
+	if got := strings.Join(remotes, ","); got != "a/b/deep.txt,top.txt,a/b,a/b/also.txt" {
+		t.Errorf("got %s", got)
+	}
+	if id, _ := f.dirCache.Get("a/b"); id != "b" {
+		t.Errorf("a/b cached as %q", id)
+	}
+	for id, n := range gets {
+		if n > 1 {
+			t.Errorf("read %q %d times", id, n)
+		}
+	}
+
+	entries, err = f.Search(context.Background(), Query{NameContains: "t", Limit: 2})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("got %d entries, want 2", len(entries))
+	}
+}
@@ -0,0 +1,280 @@
+// Package drive implements a Google Drive client for standalone usage
+//
+// This file contains the resolution and creation of shortcuts
+package drive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/standalone-gdrive/fs"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// isShortcutType returns true if mimeType is a shortcut, dangling or not
+func isShortcutType(mimeType string) bool {
+	return mimeType == shortcutMimeType || mimeType == shortcutMimeTypeDangling
+}
+
+// shortcutResolvers is the most shortcut targets looked up at once
+const shortcutResolvers = 8
+
+// shortcutTargets maps the target IDs of shortcuts to their targets,
+// or to nil for targets which don't exist
+type shortcutTargets map[string]*drive.File
+
+// findShortcutTargets looks up the targets of the shortcuts in files.
+//
+// Drive can't list files by ID, so each distinct target is read once,
+// with up to shortcutResolvers reads running at once, rather than
+// reading every shortcut's target in turn.
+func (f *Fs) findShortcutTargets(ctx context.Context, files []*drive.File) (shortcutTargets, error) {
+	targets := make(shortcutTargets)
+	var ids []string
+	for _, item := range files {
+		if item.MimeType != shortcutMimeType || f.opt.SkipShortcuts ||
+			item.ShortcutDetails == nil || item.ShortcutDetails.TargetId == "" {
+			continue
+		}
+		id := item.ShortcutDetails.TargetId
+		if _, ok := targets[id]; !ok {
+			targets[id] = nil
+			ids = append(ids, id)
+		}
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+	)
+	tokens := make(chan struct{}, shortcutResolvers)
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			tokens <- struct{}{}
+			defer func() { <-tokens }()
+			var target *drive.File
+			err := f.pacer.Call(ctx, func() (err error) {
+				target, err = f.svc.Files.Get(id).
+					Fields(googleapi.Field(partialFields)).
+					SupportsAllDrives(true).
+					Context(ctx).
+					Do()
+				return err
+			})
+			var apiErr *googleapi.Error
+			if errors.As(err, &apiErr) && apiErr.Code == 404 {
+				f.LogDebug("shortcut target %q not found", id)
+				target, err = nil, nil
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("couldn't resolve shortcut target %q: %w", id, err)
+				}
+				return
+			}
+			targets[id] = target
+		}(id)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return targets, nil
+}
+
+// resolveShortcut returns the target of the shortcut item from
+// targets, with the name and parents of the shortcut so it appears in
+// its place.
+//
+// If the target can't be found a copy of item with the dangling
+// shortcut MIME type is returned. Items which aren't shortcuts are
+// returned unchanged.
+func resolveShortcut(item *drive.File, targets shortcutTargets) *drive.File {
+	if item.MimeType != shortcutMimeType {
+		return item
+	}
+	if item.ShortcutDetails == nil {
+		return danglingShortcut(item)
+	}
+	target := targets[item.ShortcutDetails.TargetId]
+	if target == nil {
+		return danglingShortcut(item)
+	}
+	resolved := *target
+	resolved.Name = item.Name
+	resolved.Parents = item.Parents
+	return &resolved
+}
+
+// danglingShortcut returns a copy of item marked as a dangling shortcut
+func danglingShortcut(item *drive.File) *drive.File {
+	dangling := *item
+	dangling.MimeType = shortcutMimeTypeDangling
+	dangling.Size = 0
+	return &dangling
+}
+
+// shortcutDirKey returns the key of the folder shortcut called leaf in
+// the directory with parentID in the shortcutDirs map
+func shortcutDirKey(parentID, leaf string) string {
+	return parentID + "/" + leaf
+}
+
+// noteShortcutDir remembers the ID of the folder shortcut item so
+// removing its path removes the shortcut rather than the target
+func (f *Fs) noteShortcutDir(item *drive.File) {
+	for _, parent := range item.Parents {
+		f.shortcutDirs.Store(shortcutDirKey(parent, item.Name), item.Id)
+	}
+}
+
+// forgetShortcutDir forgets the folder shortcut called leaf in the
+// directory with parentID, if there was one
+func (f *Fs) forgetShortcutDir(parentID, leaf string) {
+	f.shortcutDirs.Delete(shortcutDirKey(parentID, leaf))
+}
+
+// dirShortcutID returns the ID of the shortcut dir was found through,
+// or "" if it is a real directory.
+//
+// The shortcut is read to make sure it is still there, in the same
+// place and pointing at the directory found at dir. If it isn't it is
+// forgotten and dir is dropped from the directory cache, so it must be
+// looked up again afterwards.
+func (f *Fs) dirShortcutID(ctx context.Context, dir string) (string, error) {
+	if dir == "" {
+		return "", nil
+	}
+	leaf, parentID, err := f.leafAndDirectoryID(ctx, dir)
+	if err != nil {
+		return "", err
+	}
+	id, ok := f.shortcutDirs.Load(shortcutDirKey(parentID, leaf))
+	if !ok {
+		return "", nil
+	}
+	shortcutID := id.(string)
+	targetID, err := f.dirCache.FindDir(ctx, dir)
+	if err != nil {
+		return "", err
+	}
+
+	var info *drive.File
+	err = f.pacer.Call(ctx, func() (err error) {
+		info, err = f.svc.Files.Get(shortcutID).
+			Fields("id,name,parents,trashed,mimeType,shortcutDetails").
+			SupportsAllDrives(true).
+			Context(ctx).
+			Do()
+		return err
+	})
+	var apiErr *googleapi.Error
+	switch {
+	case errors.As(err, &apiErr) && apiErr.Code == 404:
+	case err != nil:
+		return "", fmt.Errorf("couldn't read shortcut %q: %w", dir, err)
+	case !info.Trashed && info.MimeType == shortcutMimeType && info.Name == leaf &&
+		info.ShortcutDetails != nil && info.ShortcutDetails.TargetId == targetID &&
+		slices.Contains(info.Parents, parentID):
+		return shortcutID, nil
+	}
+	f.LogDebug("%q: forgetting stale folder shortcut %q", dir, shortcutID)
+	f.forgetShortcutDir(parentID, leaf)
+	f.dirCache.FlushDir(dir)
+	return "", nil
+}
+
+// removeDirShortcut removes the folder shortcut with shortcutID found
+// at dir, leaving its target alone
+func (f *Fs) removeDirShortcut(ctx context.Context, dir, shortcutID string) error {
+	if err := f.delete(ctx, shortcutID, f.opt.UseTrash); err != nil {
+		return err
+	}
+	leaf, parentID, err := f.leafAndDirectoryID(ctx, dir)
+	if err != nil {
+		return err
+	}
+	f.forgetShortcutDir(parentID, leaf)
+	f.dirCache.FlushDir(dir)
+	return nil
+}
+
+// IsAlias implements dircache.AliasFinder, returning true if the
+// directory called leaf in the directory with parentID was found
+// through a folder shortcut
+func (f *Fs) IsAlias(parentID, leaf string) bool {
+	_, ok := f.shortcutDirs.Load(shortcutDirKey(parentID, leaf))
+	return ok
+}
+
+// setShortcutID records that o was found through the shortcut with
+// shortcutID so removing it removes the shortcut
+func setShortcutID(o fs.Object, shortcutID string) {
+	switch o := o.(type) {
+	case *Object:
+		o.shortcutID = shortcutID
+	case *documentObject:
+		o.shortcutID = shortcutID
+	case *linkObject:
+		o.shortcutID = shortcutID
+	}
+}
+
+// CreateShortcut creates a shortcut at dstRemote pointing at the file
+// or directory at srcRemote, returning the resolved entry.
+//
+// It returns fs.ErrorDirExists or an error if something already
+// exists at dstRemote.
+func (f *Fs) CreateShortcut(ctx context.Context, srcRemote, dstRemote string) (_ fs.DirEntry, err error) {
+	defer translateErrorp(&err, fs.EntryObject)
+	// Find the target, which may be a directory or an object
+	targetID, err := f.itemID(ctx, srcRemote)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't find shortcut target %q: %w", srcRemote, err)
+	}
+
+	// Make sure the destination is free
+	leaf, directoryID, err := f.leafAndDirectoryID(ctx, dstRemote)
+	if err != nil {
+		return nil, err
+	}
+	_, found, err := f.findLeaf(ctx, directoryID, leaf, false)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		return nil, fmt.Errorf("can't create shortcut %q: %w", dstRemote, fs.ErrorDirExists)
+	}
+
+	createInfo := &drive.File{
+		Name:     leaf,
+		Parents:  []string{directoryID},
+		MimeType: shortcutMimeType,
+		ShortcutDetails: &drive.FileShortcutDetails{
+			TargetId: targetID,
+		},
+	}
+	var info *drive.File
+	err = f.pacer.Call(ctx, func() (err error) {
+		info, err = f.svc.Files.Create(createInfo).
+			Fields(googleapi.Field(partialFields)).
+			SupportsAllDrives(f.isTeamDrive).
+			Context(ctx).
+			Do()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create shortcut %q: %w", dstRemote, err)
+	}
+	return f.itemToDirEntry(ctx, dstRemote, info, nil)
+}
@@ -0,0 +1,312 @@
+package drive
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/standalone-gdrive/fs"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// shortcutServer serves a directory of shortcuts to a file, a folder
+// and something which no longer exists
+func shortcutServer(t *testing.T, deleted *[]string) http.Handler {
+	shortcuts := map[string]*drive.File{
+		"s1": {Id: "s1", Name: "file-link", MimeType: shortcutMimeType, Parents: []string{"root"},
+			ShortcutDetails: &drive.FileShortcutDetails{TargetId: "file", TargetMimeType: "text/plain"}},
+		"s2": {Id: "s2", Name: "dir-link", MimeType: shortcutMimeType, Parents: []string{"root"},
+			ShortcutDetails: &drive.FileShortcutDetails{TargetId: "dir", TargetMimeType: driveFolderType}},
+		"s3": {Id: "s3", Name: "gone-link", MimeType: shortcutMimeType, Parents: []string{"root"},
+			ShortcutDetails: &drive.FileShortcutDetails{TargetId: "gone"}},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/files", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, &drive.FileList{Files: []*drive.File{shortcuts["s1"], shortcuts["s2"], shortcuts["s3"]}})
+	})
+	mux.HandleFunc("/files/file", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, &drive.File{Id: "file", Name: "target.txt", MimeType: "text/plain", Size: 42, Md5Checksum: "abc", Parents: []string{"elsewhere"}})
+	})
+	mux.HandleFunc("/files/dir", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, &drive.File{Id: "dir", Name: "target", MimeType: driveFolderType})
+	})
+	mux.HandleFunc("/files/gone", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		writeJSON(w, map[string]interface{}{"error": map[string]interface{}{"code": 404, "message": "File not found"}})
+	})
+	for _, id := range []string{"s1", "s2", "s3"} {
+		id := id
+		mux.HandleFunc("/files/"+id, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodDelete {
+				*deleted = append(*deleted, id)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			writeJSON(w, shortcuts[id])
+		})
+	}
+	return mux
+}
+
+func TestListResolvesShortcuts(t *testing.T) {
+	var deleted []string
+	f := newTestFs(t, Options{}, shortcutServer(t, &deleted))
+	ctx := context.Background()
+
+	entries, err := f.List(ctx, "")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want 3", len(entries))
+	}
+
+	o := entries[0].(*Object)
+	if o.Remote() != "file-link" || o.Size() != 42 || o.md5sum != "abc" || o.ParentID() != "root" {
+		t.Errorf("file shortcut not resolved: %q size %d md5 %q parent %q", o.Remote(), o.Size(), o.md5sum, o.ParentID())
+	}
+	if d, ok := entries[1].(*Directory); !ok || d.ID() != "dir" {
+		t.Errorf("folder shortcut should be a directory with the target ID, got %#v", entries[1])
+	}
+	if id, _ := f.dirCache.Get("dir-link"); id != "dir" {
+		t.Errorf("folder shortcut cached as %q", id)
+	}
+	if p, ok := f.dirCache.GetInv("dir"); ok {
+		t.Errorf("target folder's path cached as the shortcut %q", p)
+	}
+	if dangling := entries[2].(*Object); dangling.mimeType != shortcutMimeTypeDangling {
+		t.Errorf("got MIME type %q for dangling shortcut", dangling.mimeType)
+	}
+
+	// Removing goes to the shortcut not the target
+	if err := o.Remove(ctx); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if err := f.Rmdir(ctx, "dir-link"); err != nil {
+		t.Fatalf("Rmdir failed: %v", err)
+	}
+	if strings.Join(deleted, ",") != "s1,s2" {
+		t.Errorf("deleted %v, want the shortcuts s1,s2", deleted)
+	}
+}
+
+func TestListSkipsShortcuts(t *testing.T) {
+	for _, test := range []struct {
+		opt  Options
+		want int
+	}{
+		{Options{SkipDanglingShortcuts: true}, 2},
+		{Options{SkipShortcuts: true}, 0},
+	} {
+		var deleted []string
+		f := newTestFs(t, test.opt, shortcutServer(t, &deleted))
+		entries, err := f.List(context.Background(), "")
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		if len(entries) != test.want {
+			t.Errorf("%+v: got %d entries, want %d", test.opt, len(entries), test.want)
+		}
+	}
+}
+
+// shortcutTree serves files by ID, listing them by parent and name,
+// and records the IDs deleted and patched
+func shortcutTree(mu *sync.Mutex, files map[string]*drive.File, changed *[]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		id := strings.TrimPrefix(r.URL.Path, "/files/")
+		switch {
+		case r.URL.Path == "/files":
+			parent := parentQueryRe.FindStringSubmatch(r.URL.Query().Get("q"))[1]
+			name := nameQueryRe.FindStringSubmatch(r.URL.Query().Get("q"))
+			list := &drive.FileList{}
+			for _, file := range files {
+				if file.Parents[0] == parent && (name == nil || name[1] == file.Name) {
+					list.Files = append(list.Files, file)
+				}
+			}
+			writeJSON(w, list)
+		case r.Method == http.MethodDelete:
+			*changed = append(*changed, id)
+			delete(files, id)
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodPatch:
+			*changed = append(*changed, id)
+			var update drive.File
+			_ = json.NewDecoder(r.Body).Decode(&update)
+			if update.Name != "" {
+				files[id].Name = update.Name
+			}
+			if add := r.URL.Query().Get("addParents"); add != "" {
+				files[id].Parents = []string{add}
+			}
+			writeJSON(w, files[id])
+		case files[id] != nil:
+			writeJSON(w, files[id])
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			writeJSON(w, map[string]interface{}{"error": map[string]interface{}{"code": 404, "message": "File not found"}})
+		}
+	})
+}
+
+func TestStaleFolderShortcut(t *testing.T) {
+	var mu sync.Mutex
+	files := map[string]*drive.File{
+		"s": {Id: "s", Name: "d", MimeType: shortcutMimeType, Parents: []string{"root"},
+			ShortcutDetails: &drive.FileShortcutDetails{TargetId: "t", TargetMimeType: driveFolderType}},
+		"t": {Id: "t", Name: "target", MimeType: driveFolderType, Parents: []string{"elsewhere"}},
+	}
+	var deleted []string
+	handler := shortcutTree(&mu, files, &deleted)
+	f := newTestFs(t, Options{}, handler)
+	ctx := context.Background()
+	if _, err := f.List(ctx, ""); err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+
+	// Removing the shortcut forgets it, so a real directory made in
+	// its place is removed itself
+	if err := f.Rmdir(ctx, "d"); err != nil {
+		t.Fatalf("Rmdir failed: %v", err)
+	}
+	if f.IsAlias("root", "d") {
+		t.Error("removed shortcut still remembered")
+	}
+	files["real"] = &drive.File{Id: "real", Name: "d", MimeType: driveFolderType, Parents: []string{"root"}}
+	if err := f.Rmdir(ctx, "d"); err != nil {
+		t.Fatalf("Rmdir failed: %v", err)
+	}
+
+	// A shortcut replaced behind our back isn't trusted either
+	files["s2"] = &drive.File{Id: "s2", Name: "d", MimeType: shortcutMimeType, Parents: []string{"root"},
+		ShortcutDetails: &drive.FileShortcutDetails{TargetId: "t", TargetMimeType: driveFolderType}}
+	if _, err := f.List(ctx, ""); err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	delete(files, "s2")
+	files["real2"] = &drive.File{Id: "real2", Name: "d", MimeType: driveFolderType, Parents: []string{"root"}}
+	if err := f.Purge(ctx, "d"); err != nil {
+		t.Fatalf("Purge failed: %v", err)
+	}
+	if strings.Join(deleted, ",") != "s,real,real2" {
+		t.Errorf("deleted %v, want s,real,real2", deleted)
+	}
+	if _, ok := files["t"]; !ok {
+		t.Error("the shortcut target was removed")
+	}
+}
+
+func TestDirMoveFolderShortcut(t *testing.T) {
+	var mu sync.Mutex
+	files := map[string]*drive.File{
+		"s": {Id: "s", Name: "d", MimeType: shortcutMimeType, Parents: []string{"root"},
+			ShortcutDetails: &drive.FileShortcutDetails{TargetId: "t", TargetMimeType: driveFolderType}},
+		"t":   {Id: "t", Name: "target", MimeType: driveFolderType, Parents: []string{"elsewhere"}},
+		"dst": {Id: "dst", Name: "dst", MimeType: driveFolderType, Parents: []string{"root"}},
+	}
+	var patched []string
+	f := newTestFs(t, Options{}, shortcutTree(&mu, files, &patched))
+	ctx := context.Background()
+	if _, err := f.List(ctx, ""); err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+
+	if err := f.DirMove(ctx, f, "d", "dst/moved"); err != nil {
+		t.Fatalf("DirMove failed: %v", err)
+	}
+	if strings.Join(patched, ",") != "s" {
+		t.Errorf("patched %v, want only the shortcut", patched)
+	}
+	if s := files["s"]; s.Name != "moved" || s.Parents[0] != "dst" {
+		t.Errorf("shortcut is now %q in %v", s.Name, s.Parents)
+	}
+	if tgt := files["t"]; tgt.Name != "target" || tgt.Parents[0] != "elsewhere" {
+		t.Errorf("target moved to %q in %v", tgt.Name, tgt.Parents)
+	}
+	if id, _ := f.dirCache.Get("dst/moved"); id != "t" {
+		t.Errorf("moved shortcut cached as %q", id)
+	}
+	if p, ok := f.dirCache.GetInv("t"); ok {
+		t.Errorf("target's path cached as %q", p)
+	}
+	if f.IsAlias("root", "d") || !f.IsAlias("dst", "moved") {
+		t.Error("shortcut not remembered at its new place")
+	}
+}
+
+func TestListResolvesEachTargetOnce(t *testing.T) {
+	var mu sync.Mutex
+	gets := map[string]int{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/files", func(w http.ResponseWriter, r *http.Request) {
+		var list drive.FileList
+		for i, target := range []string{"a", "b", "a", "a", "b"} {
+			list.Files = append(list.Files, &drive.File{
+				Id: fmt.Sprintf("s%d", i), Name: fmt.Sprintf("link%d", i), MimeType: shortcutMimeType, Parents: []string{"root"},
+				ShortcutDetails: &drive.FileShortcutDetails{TargetId: target, TargetMimeType: "text/plain"},
+			})
+		}
+		writeJSON(w, &list)
+	})
+	mux.HandleFunc("/files/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/files/")
+		mu.Lock()
+		gets[id]++
+		mu.Unlock()
+		writeJSON(w, &drive.File{Id: id, Name: id, MimeType: "text/plain", Size: 1})
+	})
+	f := newTestFs(t, Options{}, mux)
+
+	entries, err := f.List(context.Background(), "")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(entries) != 5 {
+		t.Fatalf("got %d entries, want 5", len(entries))
+	}
+	if gets["a"] != 1 || gets["b"] != 1 || len(gets) != 2 {
+		t.Errorf("got target reads %v, want one for each of a and b", gets)
+	}
+}
+
+func TestCreateShortcut(t *testing.T) {
+	var created drive.File
+	mux := http.NewServeMux()
+	mux.HandleFunc("/files", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			_ = json.NewDecoder(r.Body).Decode(&created)
+			created.Id = "new"
+			writeJSON(w, &created)
+			return
+		}
+		// Only the target exists
+		var list drive.FileList
+		if strings.Contains(r.URL.Query().Get("q"), `name='target.txt'`) {
+			list.Files = []*drive.File{{Id: "file", Name: "target.txt"}}
+		}
+		writeJSON(w, &list)
+	})
+	mux.HandleFunc("/files/file", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, &drive.File{Id: "file", Name: "target.txt", MimeType: "text/plain", Size: 42})
+	})
+	f := newTestFs(t, Options{}, mux)
+
+	entry, err := f.CreateShortcut(context.Background(), "target.txt", "link.txt")
+	if err != nil {
+		t.Fatalf("CreateShortcut failed: %v", err)
+	}
+	if created.MimeType != shortcutMimeType || created.ShortcutDetails == nil || created.ShortcutDetails.TargetId != "file" {
+		t.Errorf("created %+v", created)
+	}
+	if o, ok := entry.(fs.Object); !ok || o.Remote() != "link.txt" || o.Size() != 42 {
+		t.Errorf("got %#v, want the resolved target at link.txt", entry)
+	}
+}
@@ -3,9 +3,12 @@ package drive
 import (
 	"context"
 	"errors"
+	"io"
 	"os"
 	"path/filepath"
 
+	"github.com/standalone-gdrive/fs"
+
 	"google.golang.org/api/drive/v3"
 )
 
@@ -18,7 +21,8 @@ type TeamDrive struct {
 }
 
 // ListTeamDrives returns a list of team drives the user has access to
-func (f *Fs) ListTeamDrives(ctx context.Context) ([]TeamDrive, error) {
+func (f *Fs) ListTeamDrives(ctx context.Context) (_ []TeamDrive, err error) {
+	defer translateErrorp(&err, fs.EntryDirectory)
 	if f.svc == nil {
 		return nil, errors.New("client not initialized")
 	}
@@ -31,9 +35,13 @@ func (f *Fs) ListTeamDrives(ctx context.Context) ([]TeamDrive, error) {
 			call = call.PageToken(nextPageToken)
 		}
 
-		res, err := call.Context(ctx).Do()
+		var res *drive.DriveList
+		err := f.pacer.Call(ctx, func() (err error) {
+			res, err = call.Context(ctx).Do()
+			return err
+		})
 		if err != nil {
-			return nil, ProcessError(err)
+			return nil, err
 		}
 		for _, d := range res.Drives {
 			drives = append(drives, TeamDrive{
@@ -54,32 +62,38 @@ func (f *Fs) ListTeamDrives(ctx context.Context) ([]TeamDrive, error) {
 }
 
 // GetTeamDrive returns information about a specific team drive
-func (f *Fs) GetTeamDrive(ctx context.Context, teamDriveID string) (*TeamDrive, error) {
+func (f *Fs) GetTeamDrive(ctx context.Context, teamDriveID string) (_ *TeamDrive, err error) {
+	defer translateErrorp(&err, fs.EntryDirectory)
 	if f.svc == nil {
 		return nil, errors.New("client not initialized")
 	}
-	drive, err := f.svc.Drives.Get(teamDriveID).Context(ctx).Do()
+	var info *drive.Drive
+	err = f.pacer.Call(ctx, func() (err error) {
+		info, err = f.svc.Drives.Get(teamDriveID).Context(ctx).Do()
+		return err
+	})
 	if err != nil {
-		return nil, ProcessError(err)
+		return nil, err
 	}
 	return &TeamDrive{
-		ID:       drive.Id,
-		Name:     drive.Name,
-		ColorRGB: drive.ThemeId,
+		ID:       info.Id,
+		Name:     info.Name,
+		ColorRGB: info.ThemeId,
 	}, nil
 }
 
 // ListFilesInTeamDrive lists files in a team drive folder
-func (f *Fs) ListFilesInTeamDrive(ctx context.Context, folderID, teamDriveID string, recursive bool) ([]*drive.File, error) {
+func (f *Fs) ListFilesInTeamDrive(ctx context.Context, folderID, teamDriveID string, recursive bool) (_ []*drive.File, err error) {
+	defer translateErrorp(&err, fs.EntryDirectory)
 	if f.svc == nil {
 		return nil, errors.New("client not initialized")
 	}
 
 	var query string
 	if folderID == "root" {
-		query = "driveId = '" + teamDriveID + "' and 'root' in parents"
+		query = "driveId = " + quoteQuery(teamDriveID) + " and 'root' in parents"
 	} else {
-		query = "'" + folderID + "' in parents"
+		query = quoteQuery(folderID) + " in parents"
 	}
 
 	var files []*drive.File
@@ -99,9 +113,13 @@ func (f *Fs) ListFilesInTeamDrive(ctx context.Context, folderID, teamDriveID str
 			call = call.PageToken(nextPageToken)
 		}
 
-		res, err := call.Context(ctx).Fields("files(id,name,mimeType,size,md5Checksum,createdTime,modifiedTime),nextPageToken").Do()
+		var res *drive.FileList
+		err := f.pacer.Call(ctx, func() (err error) {
+			res, err = call.Context(ctx).Fields("files(id,name,mimeType,size,md5Checksum,createdTime,modifiedTime),nextPageToken").Do()
+			return err
+		})
 		if err != nil {
-			return nil, ProcessError(err)
+			return nil, err
 		}
 		for _, file := range res.Files {
 			files = append(files, file)
@@ -125,7 +143,8 @@ func (f *Fs) ListFilesInTeamDrive(ctx context.Context, folderID, teamDriveID str
 }
 
 // UploadFileToTeamDrive uploads a file to a specific team drive
-func (f *Fs) UploadFileToTeamDrive(ctx context.Context, localPath, parentID, teamDriveID, filename string) (*drive.File, error) {
+func (f *Fs) UploadFileToTeamDrive(ctx context.Context, localPath, parentID, teamDriveID, filename string) (_ *drive.File, err error) {
+	defer translateErrorp(&err, fs.EntryObject)
 	if f.svc == nil {
 		return nil, errors.New("client not initialized")
 	}
@@ -147,13 +166,20 @@ func (f *Fs) UploadFileToTeamDrive(ctx context.Context, localPath, parentID, tea
 		Parents: []string{parentID},
 	}
 
-	// Perform the upload with team drive parameters
-	res, err := f.svc.Files.Create(fileMetadata).
-		Media(file).
-		SupportsAllDrives(true).
-		Context(ctx).
-		Do()
-
+	// Perform the upload with team drive parameters, starting the file
+	// again if the upload is retried
+	var res *drive.File
+	err = f.pacer.Call(ctx, func() (err error) {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		res, err = f.svc.Files.Create(fileMetadata).
+			Media(f.bwLimiter.Upload(ctx, file)).
+			SupportsAllDrives(true).
+			Context(ctx).
+			Do()
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
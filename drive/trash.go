@@ -0,0 +1,143 @@
+// Package drive implements a Google Drive client for standalone usage
+//
+// This file contains the trash management
+package drive
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/standalone-gdrive/fs"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// Check the interfaces are satisfied
+var (
+	_ fs.CleanUpper = (*Fs)(nil)
+)
+
+// CleanUp empties the trash.
+//
+// On a shared drive only the trash of that drive is emptied. Otherwise
+// everything in the user's trash is deleted permanently, not just the
+// items under the root of this Fs.
+func (f *Fs) CleanUp(ctx context.Context) (err error) {
+	defer translateErrorp(&err, fs.EntryDirectory)
+	err = f.pacer.Call(ctx, func() error {
+		call := f.svc.Files.EmptyTrash()
+		if f.isTeamDrive {
+			call.DriveId(f.opt.TeamDriveID)
+		}
+		return call.Context(ctx).Do()
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't empty trash: %w", err)
+	}
+	return nil
+}
+
+// Untrash restores the object or directory at remote from the trash.
+//
+// Any directories on the path to remote which were trashed are
+// restored too, so the item is visible again at the same path.
+// Restoring a directory restores everything which was trashed with
+// it. It does nothing if remote isn't in the trash, and returns an
+// error if there is nothing at remote at all.
+func (f *Fs) Untrash(ctx context.Context, remote string) (err error) {
+	defer translateErrorp(&err, fs.EntryObject)
+	parentID, err := f.dirCache.FindDir(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	remote = strings.Trim(remote, "/")
+	if remote == "" {
+		return fmt.Errorf("can't restore the root directory")
+	}
+	components := strings.Split(remote, "/")
+	for i, leaf := range components {
+		last := i == len(components)-1
+		item, err := f.findTrashedLeaf(ctx, parentID, leaf, last)
+		if err != nil {
+			return err
+		}
+		if item == nil {
+			if last {
+				return fs.ErrorObjectNotFound
+			}
+			return fs.ErrorDirNotFound
+		}
+		if item.ExplicitlyTrashed {
+			if err := f.untrash(ctx, item.Id); err != nil {
+				return fmt.Errorf("couldn't restore %q: %w", strings.Join(components[:i+1], "/"), err)
+			}
+		}
+		parentID = item.Id
+	}
+
+	// The cached view of this part of the tree is out of date
+	f.dirCache.FlushDir(remote)
+	return nil
+}
+
+// findTrashedLeaf finds leaf in the directory with parentID, returning
+// nil if it isn't there.
+//
+// If last is set leaf is the item to restore, and a trashed item is
+// used in preference to a live one, which may have been restored with
+// a directory above it. Otherwise leaf is a directory on the way to the
+// item, and a live directory is used in preference to a trashed one.
+// Several matches are an error, or one of them is picked, according to
+// DuplicatePolicy.
+func (f *Fs) findTrashedLeaf(ctx context.Context, parentID, leaf string, last bool) (*drive.File, error) {
+	query := fmt.Sprintf("name=%s and %s in parents", quoteQuery(leaf), quoteQuery(parentID))
+	first, second := "trashed=false", "trashed=true"
+	if last {
+		first, second = second, first
+	} else {
+		query = fmt.Sprintf("%s and mimeType=%s", query, quoteQuery(driveFolderType))
+	}
+	item, err := f.findOnly(ctx, leaf, query+" and "+first)
+	if item != nil || err != nil {
+		return item, err
+	}
+	return f.findOnly(ctx, leaf, query+" and "+second)
+}
+
+// findOnly returns the item called name matching query, or nil if
+// there isn't one. If there are several pickDuplicate chooses between
+// them.
+func (f *Fs) findOnly(ctx context.Context, name, query string) (*drive.File, error) {
+	var files []*drive.File
+	err := f.listPages(ctx, query, func(page []*drive.File) error {
+		files = append(files, page...)
+		return nil
+	})
+	switch {
+	case err != nil:
+		return nil, err
+	case len(files) == 0:
+		return nil, nil
+	case len(files) == 1:
+		return files[0], nil
+	}
+	return f.pickDuplicate(name, files)
+}
+
+// untrash takes the item with the given ID out of the trash
+func (f *Fs) untrash(ctx context.Context, id string) error {
+	return f.pacer.Call(ctx, func() error {
+		updateInfo := &drive.File{
+			Trashed:         false,
+			ForceSendFields: []string{"Trashed"},
+		}
+		_, err := f.svc.Files.Update(id, updateInfo).
+			Fields("").
+			SupportsAllDrives(f.isTeamDrive).
+			Context(ctx).
+			Do()
+		return err
+	})
+}
@@ -0,0 +1,139 @@
+package drive
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/standalone-gdrive/fs"
+
+	"google.golang.org/api/drive/v3"
+)
+
+func TestCleanUp(t *testing.T) {
+	for _, teamDrive := range []string{"", "td"} {
+		var method, driveID string
+		mux := http.NewServeMux()
+		mux.HandleFunc("/files/trash", func(w http.ResponseWriter, r *http.Request) {
+			method = r.Method
+			driveID = r.URL.Query().Get("driveId")
+			w.WriteHeader(http.StatusNoContent)
+		})
+		f := newTestFs(t, Options{TeamDriveID: teamDrive}, mux)
+		f.isTeamDrive = teamDrive != ""
+
+		if err := f.Features().CleanUp(context.Background()); err != nil {
+			t.Fatalf("CleanUp failed: %v", err)
+		}
+		if method != http.MethodDelete || driveID != teamDrive {
+			t.Errorf("got %s with driveId %q, want DELETE with %q", method, driveID, teamDrive)
+		}
+	}
+}
+
+func TestUntrashNested(t *testing.T) {
+	// dir was trashed taking dir/file.txt with it
+	tree := map[string][]*drive.File{
+		"root": {{Id: "dir", Name: "dir", MimeType: driveFolderType, Trashed: true, ExplicitlyTrashed: true}},
+		"dir":  {{Id: "file", Name: "file.txt", Trashed: true}},
+	}
+	// restore takes id out of the trash along with everything trashed
+	// with it, like Drive does
+	var restore func(id string)
+	restore = func(id string) {
+		for _, files := range tree {
+			for _, file := range files {
+				if file.Id == id {
+					file.Trashed, file.ExplicitlyTrashed = false, false
+				}
+			}
+		}
+		for _, child := range tree[id] {
+			if child.Trashed && !child.ExplicitlyTrashed {
+				restore(child.Id)
+			}
+		}
+	}
+	queryRe := regexp.MustCompile(`^name='([^']+)' and '([^']+)' in parents( and mimeType='[^']+')? and trashed=(true|false)$`)
+	var restored []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPatch {
+			var info map[string]interface{}
+			_ = json.NewDecoder(r.Body).Decode(&info)
+			if info["trashed"] != false {
+				t.Errorf("restore sent %v", info)
+			}
+			id := strings.TrimPrefix(r.URL.Path, "/files/")
+			restored = append(restored, id)
+			restore(id)
+			writeJSON(w, &drive.File{})
+			return
+		}
+		var list drive.FileList
+		if m := queryRe.FindStringSubmatch(r.URL.Query().Get("q")); m != nil {
+			for _, file := range tree[m[2]] {
+				isDir := file.MimeType == driveFolderType
+				if file.Name == m[1] && (m[3] == "" || isDir) && m[4] == strconv.FormatBool(file.Trashed) {
+					list.Files = append(list.Files, file)
+				}
+			}
+		}
+		writeJSON(w, &list)
+	})
+	f := newTestFs(t, Options{}, handler)
+	untrash := func(remote string, wantErr error, want string) {
+		t.Helper()
+		restored = nil
+		if err := f.Untrash(context.Background(), remote); !errors.Is(err, wantErr) {
+			t.Errorf("%s: got %v, want %v", remote, err, wantErr)
+		}
+		if got := strings.Join(restored, ","); got != want {
+			t.Errorf("%s: restored %q, want %q", remote, got, want)
+		}
+	}
+
+	// Restoring dir brings back the file trashed with it
+	untrash("dir/file.txt", nil, "dir")
+	if tree["dir"][0].Trashed {
+		t.Error("file still trashed")
+	}
+
+	// Nothing is in the trash now
+	untrash("dir/file.txt", nil, "")
+	untrash("dir/missing.txt", fs.ErrorObjectNotFound, "")
+
+	// A trashed file is restored rather than a live one of the same name
+	tree["dir"] = append(tree["dir"], &drive.File{Id: "file2", Name: "file.txt", Trashed: true, ExplicitlyTrashed: true})
+	untrash("dir/file.txt", nil, "file2")
+
+	// A live directory is followed rather than a trashed one of the
+	// same name, whichever comes first
+	tree["root"] = append([]*drive.File{{Id: "old-dir", Name: "dir", MimeType: driveFolderType, Trashed: true, ExplicitlyTrashed: true}}, tree["root"]...)
+	tree["old-dir"] = []*drive.File{{Id: "old", Name: "old.txt", Trashed: true}}
+	untrash("dir/old.txt", fs.ErrorObjectNotFound, "")
+
+	// Two trashed files with the same name are ambiguous
+	tree["dir"] = append(tree["dir"],
+		&drive.File{Id: "one", Name: "dup.txt", Trashed: true, ExplicitlyTrashed: true},
+		&drive.File{Id: "two", Name: "dup.txt", Trashed: true, ExplicitlyTrashed: true},
+	)
+	restored = nil
+	if err := f.Untrash(context.Background(), "dir/dup.txt"); err == nil || !strings.Contains(err.Error(), "multiple files") {
+		t.Errorf("got %v, want an error for the duplicates", err)
+	}
+	f.opt.DuplicatePolicy = duplicateFirst
+	untrash("dir/dup.txt", nil, "one")
+}
+
+func TestTrashedOnlyListsLiveDirectories(t *testing.T) {
+	f := newTestFs(t, Options{TrashedOnly: true}, http.NotFoundHandler())
+	want := `(trashed=true or mimeType='application/vnd.google-apps.folder') and 'id' in parents`
+	if got := f.listQuery("id"); got != want {
+		t.Errorf("got query %q, want %q", got, want)
+	}
+}
@@ -4,30 +4,435 @@
 package drive
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/standalone-gdrive/fs"
 
 	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	// statusResumeIncomplete is returned by the server when a chunk
+	// has been accepted but the upload isn't finished yet
+	statusResumeIncomplete = 308
+
+	// uploadSessionMaxAge is how long a saved upload session is
+	// resumed for. Drive keeps resumable upload URIs for a week.
+	uploadSessionMaxAge = 6 * 24 * time.Hour
 )
 
-// uploadChunkedDetailed uploads a file using the Google Drive API
-func (f *Fs) uploadChunkedDetailed(ctx context.Context, in io.Reader, size int64, createInfo *drive.File) (*drive.File, error) {
-	var fileInfo *drive.File
+// Check the interfaces are satisfied
+var (
+	_ fs.PutStreamer = (*Fs)(nil)
+)
+
+// resumableUpload is a single upload session using the resumable
+// upload protocol
+type resumableUpload struct {
+	f           *Fs
+	URI         string      // session URI returned when the upload was started
+	in          io.Reader   // the data to upload
+	size        int64       // total size, or -1 if it isn't known until EOF
+	start       int64       // offset to start sending from when resuming
+	contentType string      // MIME type of the data
+	ret         *drive.File // the file returned when the upload finishes
+}
+
+// uploadResumable uploads in using a resumable upload session.
+//
+// If fileID is empty a new file described by info is created,
+// otherwise the content of fileID is replaced. size may be -1 if it
+// isn't known, in which case chunks are sent until in returns EOF and
+// the total size is sent with the last one.
+//
+// If UploadSessionDir is set and the size is known, the session URI is
+// saved there so an interrupted upload of the same remote can carry on
+// from where it stopped, even after a restart.
+func (f *Fs) uploadResumable(ctx context.Context, in io.Reader, size int64, contentType, fileID, remote string, info *drive.File) (*drive.File, error) {
+	rx := &resumableUpload{
+		f:           f,
+		in:          in,
+		size:        size,
+		contentType: contentType,
+	}
 
-	err := f.pacer.Call(ctx, func() error {
-		// Create the file using the Drive API
-		call := f.svc.Files.Create(createInfo)
-		call.SupportsAllDrives(f.isTeamDrive)
-		call.Media(in)
+	sessionPath := f.uploadSessionPath(remote, fileID, size, info.ModifiedTime)
+	if sessionPath != "" {
+		ret, err := rx.resume(ctx, sessionPath)
+		if err != nil || ret != nil {
+			return ret, err
+		}
+	}
+
+	if rx.URI == "" {
+		err := f.pacer.Call(ctx, func() (err error) {
+			rx.URI, err = f.startResumableUpload(ctx, fileID, info, contentType, size)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("couldn't start upload session: %w", err)
+		}
+		if sessionPath != "" {
+			if err := saveUploadSession(sessionPath, rx.URI); err != nil {
+				f.LogWarn("couldn't save upload session for %q: %v", remote, err)
+			}
+		}
+	}
 
-		var err error
-		fileInfo, err = call.Do()
+	ret, err := rx.Upload(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if sessionPath != "" {
+		_ = os.Remove(sessionPath)
+	}
+	return ret, nil
+}
+
+// resume carries on with a saved upload session if there is one.
+//
+// If the session is still valid rx.URI is set and the data the server
+// already has is skipped. If the server reports the upload complete the
+// file is returned. Expired sessions are removed.
+func (rx *resumableUpload) resume(ctx context.Context, sessionPath string) (*drive.File, error) {
+	URI, err := loadUploadSession(sessionPath)
+	if err != nil || URI == "" {
+		return nil, nil
+	}
+
+	rx.URI = URI
+	var committed int64
+	var finished bool
+	err = rx.f.pacer.Call(ctx, func() (err error) {
+		committed, finished, err = rx.queryStatus(ctx, rx.size)
 		return err
 	})
+	if err != nil {
+		rx.f.LogInfo("upload session %q can't be resumed, starting again: %v", sessionPath, err)
+		rx.URI = ""
+		_ = os.Remove(sessionPath)
+		return nil, nil
+	}
+	if finished {
+		_ = os.Remove(sessionPath)
+		return rx.ret, nil
+	}
+
+	// Skip the data the server already has
+	if seeker, ok := rx.in.(io.Seeker); ok {
+		_, err = seeker.Seek(committed, io.SeekCurrent)
+	} else {
+		_, err = io.CopyN(io.Discard, rx.in, committed)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't skip %d bytes already uploaded: %w", committed, err)
+	}
+	rx.start = committed
+	rx.f.LogInfo("resuming upload session at offset %d", committed)
+	return nil, nil
+}
+
+// startResumableUpload starts an upload session returning its URI
+func (f *Fs) startResumableUpload(ctx context.Context, fileID string, info *drive.File, contentType string, size int64) (string, error) {
+	params := url.Values{}
+	params.Set("alt", "json")
+	params.Set("uploadType", "resumable")
+	params.Set("fields", partialFields)
+	params.Set("supportsAllDrives", strconv.FormatBool(f.isTeamDrive))
+	params.Set("keepRevisionForever", strconv.FormatBool(f.opt.KeepRevisionForever))
+
+	method := http.MethodPost
+	urls := googleapi.ResolveRelative(f.svc.BasePath, "/upload/drive/v3/files")
+	if fileID != "" {
+		method = http.MethodPatch
+		urls = googleapi.ResolveRelative(f.svc.BasePath, "/upload/drive/v3/files/"+url.PathEscape(fileID))
+	}
 
+	body, err := json.Marshal(info)
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, urls+"?"+params.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	if contentType != "" {
+		req.Header.Set("X-Upload-Content-Type", contentType)
+	}
+	if size >= 0 {
+		req.Header.Set("X-Upload-Content-Length", strconv.FormatInt(size, 10))
 	}
 
-	return fileInfo, nil
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer googleapi.CloseBody(resp)
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return "", err
+	}
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", errors.New("upload session started without a location")
+	}
+	return location, nil
+}
+
+// contentRange returns the Content-Range header for sending n bytes
+// starting at start. total is -1 if the size isn't known yet.
+func contentRange(start int64, n int, total int64) string {
+	if n == 0 {
+		if total < 0 {
+			return "bytes */*"
+		}
+		return fmt.Sprintf("bytes */%d", total)
+	}
+	end := start + int64(n) - 1
+	if total < 0 {
+		return fmt.Sprintf("bytes %d-%d/*", start, end)
+	}
+	return fmt.Sprintf("bytes %d-%d/%d", start, end, total)
+}
+
+// transferChunk sends chunk starting at offset start, returning true
+// when the server reports the upload is finished
+func (rx *resumableUpload) transferChunk(ctx context.Context, start int64, chunk []byte, total int64) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, rx.URI, bytes.NewReader(chunk))
+	if err != nil {
+		return false, err
+	}
+	req.ContentLength = int64(len(chunk))
+	req.Header.Set("Content-Range", contentRange(start, len(chunk), total))
+	if rx.contentType != "" {
+		req.Header.Set("Content-Type", rx.contentType)
+	}
+
+	resp, err := rx.f.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer googleapi.CloseBody(resp)
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		rx.ret = new(drive.File)
+		if err := json.NewDecoder(resp.Body).Decode(rx.ret); err != nil {
+			return false, fmt.Errorf("couldn't decode upload response: %w", err)
+		}
+		return true, nil
+	case statusResumeIncomplete:
+		return false, nil
+	}
+	return false, googleapi.CheckResponse(resp)
+}
+
+// queryStatus asks the server how much of the upload it has
+// committed, returning true if the upload is already complete
+func (rx *resumableUpload) queryStatus(ctx context.Context, total int64) (committed int64, finished bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, rx.URI, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	req.ContentLength = 0
+	req.Header.Set("Content-Range", contentRange(0, 0, total))
+
+	resp, err := rx.f.client.Do(req)
+	if err != nil {
+		return 0, false, err
+	}
+	defer googleapi.CloseBody(resp)
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		rx.ret = new(drive.File)
+		if err := json.NewDecoder(resp.Body).Decode(rx.ret); err != nil {
+			return 0, false, fmt.Errorf("couldn't decode upload response: %w", err)
+		}
+		return 0, true, nil
+	case statusResumeIncomplete:
+		// Range is "bytes=0-N" or missing if nothing is committed
+		var end int64
+		if _, err := fmt.Sscanf(resp.Header.Get("Range"), "bytes=0-%d", &end); err == nil {
+			committed = end + 1
+		}
+		return committed, false, nil
+	}
+	return 0, false, googleapi.CheckResponse(resp)
+}
+
+// Upload reads the data in ChunkSize chunks and sends each one to the
+// session, returning the file once the server reports it complete.
+//
+// If sending a chunk fails the server is asked how much of it was
+// committed and only the rest of that chunk is sent again.
+func (rx *resumableUpload) Upload(ctx context.Context) (*drive.File, error) {
+	buf := make([]byte, rx.f.opt.ChunkSize)
+	start := rx.start
+	for {
+		n, err := io.ReadFull(rx.in, buf)
+		eof := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !eof {
+			return nil, fmt.Errorf("couldn't read data to upload: %w", err)
+		}
+
+		total := rx.size
+		if eof {
+			total = start + int64(n)
+			if rx.size >= 0 && total != rx.size {
+				return nil, fmt.Errorf("upload size mismatch: expected %d bytes but read %d", rx.size, total)
+			}
+		}
+
+		chunk := buf[:n]
+		chunkStart := start
+		finished := false
+		retry := false
+		err = rx.f.pacer.Call(ctx, func() (err error) {
+			if retry {
+				// Find out how much of the chunk made it
+				committed, done, err := rx.queryStatus(ctx, total)
+				if err != nil {
+					return err
+				}
+				if done {
+					finished = true
+					return nil
+				}
+				if committed < start || committed > start+int64(n) {
+					return fmt.Errorf("server committed offset %d outside chunk %d-%d", committed, start, start+int64(n))
+				}
+				chunk = buf[committed-start : n]
+				chunkStart = committed
+				if len(chunk) == 0 && !eof {
+					// The whole chunk arrived
+					return nil
+				}
+			}
+			retry = true
+			finished, err = rx.transferChunk(ctx, chunkStart, chunk, total)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("couldn't upload chunk at offset %d: %w", chunkStart, err)
+		}
+		start += int64(n)
+
+		if finished {
+			return rx.ret, nil
+		}
+		if eof {
+			return nil, errors.New("upload incomplete after all data was sent")
+		}
+	}
+}
+
+// uploadSessionPath returns the file used to save the session for
+// uploading remote, or "" if sessions aren't being saved
+func (f *Fs) uploadSessionPath(remote, fileID string, size int64, modTime string) string {
+	if f.opt.UploadSessionDir == "" || size < 0 {
+		return ""
+	}
+	key := strings.Join([]string{f.name, f.root, remote, fileID, strconv.FormatInt(size, 10), modTime}, "\x00")
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(f.opt.UploadSessionDir, hex.EncodeToString(sum[:])+".session")
+}
+
+// uploadSession is the saved state of a resumable upload
+type uploadSession struct {
+	URI     string    `json:"uri"`
+	Created time.Time `json:"created"`
+}
+
+// saveUploadSession saves the session URI to path
+func saveUploadSession(path, URI string) error {
+	data, err := json.Marshal(&uploadSession{URI: URI, Created: time.Now()})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// loadUploadSession returns the session URI saved in path or "" if
+// there isn't one. Sessions older than uploadSessionMaxAge are removed
+// as Drive will have forgotten them.
+func loadUploadSession(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	var session uploadSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return "", err
+	}
+	if time.Since(session.Created) > uploadSessionMaxAge {
+		_ = os.Remove(path)
+		return "", nil
+	}
+	return session.URI, nil
+}
+
+// checkUpload compares the checksums of the data sent with those of
+// the file Drive stored, deleting the file if they don't match.
+//
+// It must only be used for files the upload created, as deleting an
+// updated file would lose the original along with its revisions.
+func (f *Fs) checkUpload(ctx context.Context, sums *checksumReader, remote string, info *drive.File) error {
+	err := sums.check(info.Md5Checksum, info.Sha256Checksum)
+	if err == nil {
+		return nil
+	}
+	if removeErr := f.delete(ctx, info.Id, f.opt.UseTrash); removeErr != nil {
+		f.LogError("couldn't remove corrupted upload %q: %v", remote, removeErr)
+	}
+	return fmt.Errorf("upload of %q corrupted: %w", remote, err)
+}
+
+// PutStream uploads to the remote path with the modTime given of
+// indeterminate size.
+//
+// The data is sent in ChunkSize chunks over a resumable upload session
+// until in returns EOF, then the total size is sent to finish it.
+func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (_ fs.Object, err error) {
+	defer translateErrorp(&err, fs.EntryObject)
+	return f.Put(ctx, in, &sizeUnknown{src}, options...)
+}
+
+// sizeUnknown wraps an fs.ObjectInfo to report an unknown size
+type sizeUnknown struct {
+	fs.ObjectInfo
+}
+
+// Size returns -1 as the size isn't known
+func (s *sizeUnknown) Size() int64 {
+	return -1
+}
+
+// mimeTypeOf returns the MIME type of src if it knows it
+func mimeTypeOf(ctx context.Context, src fs.ObjectInfo) string {
+	if do, ok := src.(fs.MimeTyper); ok {
+		return do.MimeType(ctx)
+	}
+	return ""
 }
@@ -0,0 +1,291 @@
+package drive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/standalone-gdrive/fs"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// fakeResumable is a fake resumable upload server
+type fakeResumable struct {
+	t       *testing.T
+	data    bytes.Buffer
+	ranges  []string
+	started int
+
+	// Chunks starting at failAt keep failKeep bytes then fail, fails times
+	failAt   int
+	failKeep int
+	fails    int
+
+	md5sum  string // MD5 checksum to report for the upload
+	deleted bool   // set if the upload was deleted
+}
+
+var rangeRe = regexp.MustCompile(`^bytes (?:(\d+)-(\d+)|\*)/(\d+|\*)$`)
+
+func (fr *fakeResumable) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/upload/drive/v3/files" && r.URL.Query().Get("uploadType") == "resumable":
+		fr.started++
+		w.Header().Set("Location", "http://"+r.Host+"/session")
+		w.WriteHeader(http.StatusOK)
+	case r.URL.Path == "/session" && r.Method == http.MethodPut:
+		cr := r.Header.Get("Content-Range")
+		fr.ranges = append(fr.ranges, cr)
+		m := rangeRe.FindStringSubmatch(cr)
+		if m == nil {
+			fr.t.Errorf("bad Content-Range %q", cr)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if m[1] != "" {
+			start, _ := strconv.Atoi(m[1])
+			if start != fr.data.Len() {
+				fr.t.Errorf("chunk starts at %d, have %d", start, fr.data.Len())
+			}
+		}
+		if m[1] != "" && fr.fails > 0 && fr.data.Len() == fr.failAt {
+			fr.fails--
+			fr.data.Write(body[:fr.failKeep])
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fr.data.Write(body)
+		if m[3] != "*" {
+			total, _ := strconv.Atoi(m[3])
+			if total == fr.data.Len() {
+				writeJSON(w, &drive.File{Id: "new", Size: int64(total), Md5Checksum: fr.md5sum})
+				return
+			}
+		}
+		if fr.data.Len() > 0 {
+			w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", fr.data.Len()-1))
+		}
+		w.WriteHeader(statusResumeIncomplete)
+	case r.URL.Path == "/files/new" && r.Method == http.MethodDelete:
+		fr.deleted = true
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		fr.t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestPutStream(t *testing.T) {
+	for _, test := range []struct {
+		size       int
+		wantRanges []string
+	}{
+		{10, []string{"bytes 0-3/*", "bytes 4-7/*", "bytes 8-9/10"}},
+		{8, []string{"bytes 0-3/*", "bytes 4-7/*", "bytes */8"}},
+		{0, []string{"bytes */0"}},
+	} {
+		fr := &fakeResumable{t: t}
+		f := newTestFs(t, Options{ChunkSize: 4}, fr)
+		content := bytes.Repeat([]byte("x"), test.size)
+		src := &fs.ObjectInfoImpl{RemoteName: "stream.txt", FileSize: int64(test.size), FileModTime: time.Now()}
+
+		o, err := f.PutStream(context.Background(), bytes.NewReader(content), src)
+		if err != nil {
+			t.Fatalf("PutStream(%d bytes) failed: %v", test.size, err)
+		}
+		if o.Size() != int64(test.size) {
+			t.Errorf("got size %d, want %d", o.Size(), test.size)
+		}
+		if !bytes.Equal(fr.data.Bytes(), content) {
+			t.Errorf("server received %d bytes, want %d", fr.data.Len(), test.size)
+		}
+		if fmt.Sprint(fr.ranges) != fmt.Sprint(test.wantRanges) {
+			t.Errorf("got ranges %v, want %v", fr.ranges, test.wantRanges)
+		}
+	}
+}
+
+func TestUploadRetriesRestOfFailedChunk(t *testing.T) {
+	fr := &fakeResumable{t: t, failAt: 4, failKeep: 1, fails: 1}
+	f := newTestFs(t, Options{ChunkSize: 4}, fr)
+	content := []byte("0123456789")
+	src := &fs.ObjectInfoImpl{RemoteName: "file.txt", FileSize: 10, FileModTime: time.Now()}
+
+	if _, err := f.Put(context.Background(), bytes.NewReader(content), src); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if !bytes.Equal(fr.data.Bytes(), content) {
+		t.Errorf("server received %q, want %q", fr.data.Bytes(), content)
+	}
+	want := []string{"bytes 0-3/10", "bytes 4-7/10", "bytes */10", "bytes 5-7/10", "bytes 8-9/10"}
+	if fmt.Sprint(fr.ranges) != fmt.Sprint(want) {
+		t.Errorf("got ranges %v, want %v", fr.ranges, want)
+	}
+}
+
+func TestUploadResumesSavedSession(t *testing.T) {
+	dir := t.TempDir()
+	fr := &fakeResumable{t: t, failAt: 4, fails: 100}
+	f := newTestFs(t, Options{ChunkSize: 4, UploadSessionDir: dir}, fr)
+	content := []byte("0123456789")
+	src := &fs.ObjectInfoImpl{RemoteName: "file.txt", FileSize: 10, FileModTime: time.Unix(1000, 0)}
+
+	if _, err := f.Put(context.Background(), bytes.NewReader(content), src); err == nil {
+		t.Fatal("expected the first upload to fail")
+	}
+	sessions, _ := os.ReadDir(dir)
+	if len(sessions) != 1 {
+		t.Fatalf("got %d saved sessions, want 1", len(sessions))
+	}
+
+	// Try again as if after a restart
+	fr.fails = 0
+	fr.ranges = nil
+	if _, err := f.Put(context.Background(), bytes.NewReader(content), src); err != nil {
+		t.Fatalf("resumed Put failed: %v", err)
+	}
+	if fr.started != 1 {
+		t.Errorf("started %d sessions, want 1", fr.started)
+	}
+	if !bytes.Equal(fr.data.Bytes(), content) {
+		t.Errorf("server received %q, want %q", fr.data.Bytes(), content)
+	}
+	want := []string{"bytes */10", "bytes 4-7/10", "bytes 8-9/10"}
+	if fmt.Sprint(fr.ranges) != fmt.Sprint(want) {
+		t.Errorf("got ranges %v, want %v", fr.ranges, want)
+	}
+	if sessions, _ := os.ReadDir(dir); len(sessions) != 0 {
+		t.Errorf("session not removed after upload finished")
+	}
+}
+
+func TestUploadSkipsExpiredSession(t *testing.T) {
+	dir := t.TempDir()
+	fr := &fakeResumable{t: t, failAt: 4, fails: 100}
+	f := newTestFs(t, Options{ChunkSize: 4, UploadSessionDir: dir}, fr)
+	content := []byte("0123456789")
+	src := &fs.ObjectInfoImpl{RemoteName: "file.txt", FileSize: 10, FileModTime: time.Unix(1000, 0)}
+
+	if _, err := f.Put(context.Background(), bytes.NewReader(content), src); err == nil {
+		t.Fatal("expected the first upload to fail")
+	}
+	sessions, _ := os.ReadDir(dir)
+	if len(sessions) != 1 {
+		t.Fatalf("got %d saved sessions, want 1", len(sessions))
+	}
+
+	// Age the session past the time Drive keeps it
+	path := filepath.Join(dir, sessions[0].Name())
+	data, _ := json.Marshal(&uploadSession{URI: "http://example.com/gone", Created: time.Now().Add(-8 * 24 * time.Hour)})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if URI, err := loadUploadSession(path); URI != "" || err != nil {
+		t.Errorf("loaded expired session %q: %v", URI, err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expired session not removed: %v", err)
+	}
+
+	// A new upload starts a new session rather than resuming
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	fr.fails = 0
+	fr.ranges = nil
+	fr.data.Reset()
+	if _, err := f.Put(context.Background(), bytes.NewReader(content), src); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if fr.started != 2 {
+		t.Errorf("started %d sessions, want 2", fr.started)
+	}
+	want := []string{"bytes 0-3/10", "bytes 4-7/10", "bytes 8-9/10"}
+	if fmt.Sprint(fr.ranges) != fmt.Sprint(want) {
+		t.Errorf("got ranges %v, want %v", fr.ranges, want)
+	}
+}
+
+func TestUploadChecksum(t *testing.T) {
+	content := []byte("0123456789")
+	for _, test := range []struct {
+		md5sum      string
+		disable     bool
+		wantErr     bool
+		wantDeleted bool
+	}{
+		{"781e5e245d69b566979b86e28d23f2c7", false, false, false},
+		{"00000000000000000000000000000000", false, true, true},
+		{"00000000000000000000000000000000", true, false, false},
+	} {
+		fr := &fakeResumable{t: t, md5sum: test.md5sum}
+		f := newTestFs(t, Options{ChunkSize: 4, DisableChecksum: test.disable}, fr)
+		src := &fs.ObjectInfoImpl{RemoteName: "file.txt", FileSize: 10, FileModTime: time.Now()}
+
+		_, err := f.Put(context.Background(), bytes.NewReader(content), src)
+		if test.wantErr != errors.Is(err, ErrChecksumMismatch) {
+			t.Errorf("md5 %s disable=%v: got error %v", test.md5sum, test.disable, err)
+		}
+		if fr.deleted != test.wantDeleted {
+			t.Errorf("md5 %s disable=%v: deleted=%v, want %v", test.md5sum, test.disable, fr.deleted, test.wantDeleted)
+		}
+	}
+}
+
+func TestSimpleUploadRetryAndUpdateChecksum(t *testing.T) {
+	content := "0123456789"
+	var bodies []string
+	var md5sum string
+	var removed bool
+	f := newTestFs(t, Options{UploadCutoff: fs.MiByte}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/upload/drive/v3/files"):
+			body, _ := io.ReadAll(r.Body)
+			bodies = append(bodies, string(body))
+			if len(bodies) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			writeJSON(w, &drive.File{Id: "id", Name: "file.txt", Size: int64(len(content)), Md5Checksum: md5sum})
+		default:
+			removed = true
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	src := &fs.ObjectInfoImpl{RemoteName: "file.txt", FileSize: int64(len(content)), FileModTime: time.Now()}
+
+	// The retry sends all the data again
+	md5sum = "781e5e245d69b566979b86e28d23f2c7"
+	o := f.newObjectWithInfo("file.txt", &drive.File{Id: "id"})
+	if err := o.Update(context.Background(), strings.NewReader(content), src); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if len(bodies) != 2 || !strings.Contains(bodies[1], content) {
+		t.Fatalf("got bodies %q, want the content sent twice", bodies)
+	}
+
+	// A corrupted update leaves the file there
+	bodies = bodies[:1]
+	md5sum = "00000000000000000000000000000000"
+	err := o.Update(context.Background(), strings.NewReader(content), src)
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("got %v, want a checksum mismatch", err)
+	}
+	if removed {
+		t.Error("the updated file was removed")
+	}
+}
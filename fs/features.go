@@ -3,6 +3,7 @@ package fs
 import (
 	"context"
 	"io"
+	"time"
 )
 
 // Features describe the optional features of the Fs
@@ -55,6 +56,24 @@ type Features struct {
 	// MergeDirs merges the contents of all the directories passed
 	// in into the first one and rmdirs the other directories.
 	MergeDirs func(ctx context.Context, dirs []Directory) error
+
+	// ListP lists the objects and directories in dir calling
+	// callback with each page of entries as it arrives
+	ListP func(ctx context.Context, dir string, callback ListCallback) error
+
+	// ListR lists the objects and directories of the Fs starting
+	// from dir recursively, calling callback with batches of entries
+	ListR func(ctx context.Context, dir string, callback ListCallback) error
+
+	// About gets quota information from the Fs
+	About func(ctx context.Context) (*Usage, error)
+
+	// CleanUp the trash in the Fs
+	CleanUp func(ctx context.Context) error
+
+	// PublicLink generates a public link to the remote path, or
+	// removes it if unlink is set. See PublicLinker for expire.
+	PublicLink func(ctx context.Context, remote string, expire time.Duration, unlink bool) (string, error)
 }
 
 // Fill fills in the function pointers in the Features struct from the
@@ -88,6 +107,21 @@ func (ftrs *Features) Fill(ctx context.Context, f Fs) *Features {
 	if do, ok := f.(MergeDirser); ok {
 		ftrs.MergeDirs = do.MergeDirs
 	}
+	if do, ok := f.(ListPer); ok {
+		ftrs.ListP = do.ListP
+	}
+	if do, ok := f.(ListRer); ok {
+		ftrs.ListR = do.ListR
+	}
+	if do, ok := f.(Abouter); ok {
+		ftrs.About = do.About
+	}
+	if do, ok := f.(CleanUpper); ok {
+		ftrs.CleanUp = do.CleanUp
+	}
+	if do, ok := f.(PublicLinker); ok {
+		ftrs.PublicLink = do.PublicLink
+	}
 	return ftrs
 }
 
@@ -161,3 +195,57 @@ type MergeDirser interface {
 	// in into the first one and rmdirs the other directories.
 	MergeDirs(ctx context.Context, dirs []Directory) error
 }
+
+// ListCallback is called by streaming listings with each batch of
+// entries as it is read. Returning an error stops the listing.
+type ListCallback func(entries DirEntries) error
+
+// ListPer is an optional interface for Fs
+type ListPer interface {
+	// ListP lists the objects and directories in dir calling
+	// callback with each page of entries as it arrives
+	ListP(ctx context.Context, dir string, callback ListCallback) error
+}
+
+// ListRer is an optional interface for Fs
+type ListRer interface {
+	// ListR lists the objects and directories of the Fs starting
+	// from dir recursively, calling callback with batches of entries.
+	//
+	// The order of the entries is not defined and the callback may
+	// see entries from different directories in the same batch.
+	ListR(ctx context.Context, dir string, callback ListCallback) error
+}
+
+// Usage is returned by the About call
+//
+// If a value is nil then it isn't supported by that backend
+type Usage struct {
+	Total   *int64 `json:"total,omitempty"`   // quota of bytes that can be used
+	Used    *int64 `json:"used,omitempty"`    // bytes in use
+	Trashed *int64 `json:"trashed,omitempty"` // bytes in trash
+	Other   *int64 `json:"other,omitempty"`   // other usage e.g. gmail in drive
+	Free    *int64 `json:"free,omitempty"`    // bytes which can be uploaded before reaching the quota
+	Objects *int64 `json:"objects,omitempty"` // objects in the storage system
+}
+
+// Abouter is an optional interface for Fs
+type Abouter interface {
+	// About gets quota information from the Fs
+	About(ctx context.Context) (*Usage, error)
+}
+
+// CleanUpper is an optional interfaces for Fs
+type CleanUpper interface {
+	// CleanUp the trash in the Fs
+	CleanUp(ctx context.Context) error
+}
+
+// PublicLinker is an optional interface for Fs
+type PublicLinker interface {
+	// PublicLink generates a public link to the remote path, or
+	// removes it if unlink is set. If expire isn't 0 the link
+	// expires after it, or an error is returned if the backend can't
+	// expire links, as Google Drive can't.
+	PublicLink(ctx context.Context, remote string, expire time.Duration, unlink bool) (string, error)
+}
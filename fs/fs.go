@@ -4,6 +4,9 @@ package fs
 import (
 	"context"
 	"fmt"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -25,6 +28,37 @@ func (s SizeSuffix) Byte() int64 {
 	return int64(s)
 }
 
+// ParseSizeSuffix parses a size such as 1024, 512k, 250M or 1.5G. The
+// suffixes are powers of 1024, optionally followed by "i" or "iB", and
+// a number without one is in bytes.
+func ParseSizeSuffix(s string) (SizeSuffix, error) {
+	number := strings.TrimSpace(s)
+	number = strings.TrimSuffix(strings.TrimSuffix(number, "B"), "i")
+	unit := Byte
+	if n := len(number); n > 0 {
+		switch number[n-1] {
+		case 'k', 'K':
+			unit = KiByte
+		case 'm', 'M':
+			unit = MiByte
+		case 'g', 'G':
+			unit = GiByte
+		case 't', 'T':
+			unit = TiByte
+		case 'p', 'P':
+			unit = PiByte
+		}
+		if unit != Byte {
+			number = number[:n-1]
+		}
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return SizeSuffix(value * float64(unit)), nil
+}
+
 // Instance of Size suffixes
 const (
 	Byte   SizeSuffix = 1
@@ -102,8 +136,11 @@ type Metadata map[string]string
 type Pacer struct {
 	calculateDelay func(state PacerState) time.Duration
 	maxConnections int
-	connTokens     chan struct{}
+	mu             sync.Mutex    // protects maxConnections, inUse and changed
+	inUse          int           // connections in use
+	changed        chan struct{} // closed and replaced when a connection is freed or the limit changes
 	retries        int
+	shouldRetry    func(ctx context.Context, err error) (bool, error)
 }
 
 // NewPacer creates a Pacer with the given retries and max connections
@@ -113,13 +150,18 @@ func NewPacer(ctx context.Context, calculateDelay func(state PacerState) time.Du
 		maxConnections: 1,
 		retries:        3,
 	}
-	pacer.connTokens = make(chan struct{}, pacer.maxConnections)
-	for i := 0; i < pacer.maxConnections; i++ {
-		pacer.connTokens <- struct{}{}
-	}
+	pacer.changed = make(chan struct{})
 	return pacer
 }
 
+// SetShouldRetry sets the function deciding whether an error returned
+// by the function passed to Call is worth retrying. It may replace the
+// error, and the replacement is what calculateDelay sees and Call
+// returns. Without one every error is retried.
+func (p *Pacer) SetShouldRetry(shouldRetry func(ctx context.Context, err error) (bool, error)) {
+	p.shouldRetry = shouldRetry
+}
+
 // PacerState represents the state of a Pacer
 type PacerState struct {
 	ConsecutiveRetries int
@@ -131,20 +173,21 @@ func (p *Pacer) Call(ctx context.Context, fn func() error) error {
 	var err error
 	for try := 0; try <= p.retries; try++ {
 		// Get a token
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-p.connTokens:
-			// Got a token
+		if err := p.getToken(ctx); err != nil {
+			return err
 		}
 		// Do the operation
 		err = fn()
 		// Return the token
-		p.connTokens <- struct{}{}
+		p.PutToken()
 		if err == nil {
 			break
 		}
-		if try >= p.retries {
+		retry := true
+		if p.shouldRetry != nil {
+			retry, err = p.shouldRetry(ctx, err)
+		}
+		if !retry || try >= p.retries {
 			break
 		}
 		// Delay before retrying
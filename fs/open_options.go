@@ -145,6 +145,48 @@ func (o NullOption) Mandatory() bool {
 	return false
 }
 
+// MetadataOption defines an Option which carries the metadata to set
+// on an upload
+type MetadataOption Metadata
+
+// Header formats the option as an http header
+func (o MetadataOption) Header() (key string, value string) {
+	return "", ""
+}
+
+// Apply doesn't do anything as the metadata is read by the backend
+func (o MetadataOption) Apply(string) error {
+	return nil
+}
+
+// String formats the option into human-readable form
+func (o MetadataOption) String() string {
+	return fmt.Sprintf("MetadataOption(%v)", Metadata(o))
+}
+
+// Mandatory returns whether the option must be parsed or can be ignored
+func (o MetadataOption) Mandatory() bool {
+	return false
+}
+
+// GetMetadataOptions returns the metadata of any MetadataOptions in
+// options merged together, later ones taking precedence, or nil if
+// there are none
+func GetMetadataOptions(options []OpenOption) Metadata {
+	var metadata Metadata
+	for _, option := range options {
+		if o, ok := option.(MetadataOption); ok {
+			if metadata == nil {
+				metadata = Metadata{}
+			}
+			for k, v := range o {
+				metadata[k] = v
+			}
+		}
+	}
+	return metadata
+}
+
 // FixRangeOption adjusts RangeOptions that request a fetch from the end into an
 // absolute fetch using the size passed in
 func FixRangeOption(options []OpenOption, size int64) {
@@ -1,33 +1,58 @@
 // Package fs provides core functionality for filesystem-like operations
 package fs
 
+import "context"
+
 // Note: This file provides additional pacer functionality beyond what's in fs.go
 
-// SetMaxConnections sets the maximum number of concurrent connections
+// SetMaxConnections sets the maximum number of concurrent connections.
+//
+// It is safe to call while calls are running: waiting calls start if
+// the limit is raised, and if it is lowered no new calls start until
+// enough running ones have finished.
 func (p *Pacer) SetMaxConnections(n int) {
-	if p.connTokens != nil {
-		// Close old tokens
-		close(p.connTokens)
-	}
-
-	// Create new tokens
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	p.maxConnections = n
-	p.connTokens = make(chan struct{}, n)
+	p.broadcast()
+}
+
+// broadcast wakes the calls waiting for a token - call with mu held
+func (p *Pacer) broadcast() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
 
-	// Fill the connection pool
-	for i := 0; i < n; i++ {
-		p.connTokens <- struct{}{}
+// getToken gets a connection token, waiting if necessary, or returns
+// an error if ctx is done first
+func (p *Pacer) getToken(ctx context.Context) error {
+	p.mu.Lock()
+	for p.inUse >= p.maxConnections {
+		changed := p.changed
+		p.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		p.mu.Lock()
 	}
+	p.inUse++
+	p.mu.Unlock()
+	return nil
 }
 
 // GetToken gets a connection token, waiting if necessary
 func (p *Pacer) GetToken() {
-	<-p.connTokens
+	_ = p.getToken(context.Background())
 }
 
 // PutToken returns a connection token
 func (p *Pacer) PutToken() {
-	p.connTokens <- struct{}{}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.inUse--
+	p.broadcast()
 }
 
 // CallWithoutContext calls a function with retries but without a context
@@ -0,0 +1,364 @@
+// Package bwlimit limits the bandwidth used by transfers
+package bwlimit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/standalone-gdrive/fs"
+)
+
+// maxRead is the most read at once from a limited reader, so the data
+// flows smoothly rather than in bursts followed by long waits
+const maxRead = 64 * 1024
+
+// Limits are the bandwidths allowed in bytes per second, 0 for no limit
+type Limits struct {
+	Upload   fs.SizeSuffix
+	Download fs.SizeSuffix
+}
+
+// String returns the limits in the form Parse reads
+func (l Limits) String() string {
+	if l.Upload == l.Download {
+		return formatRate(l.Upload)
+	}
+	return formatRate(l.Upload) + ":" + formatRate(l.Download)
+}
+
+// formatRate formats a single rate, using the largest unit it is a
+// whole number of
+func formatRate(rate fs.SizeSuffix) string {
+	if rate <= 0 {
+		return "off"
+	}
+	for _, unit := range []struct {
+		size   fs.SizeSuffix
+		suffix string
+	}{{fs.GiByte, "G"}, {fs.MiByte, "M"}, {fs.KiByte, "k"}} {
+		if rate%unit.size == 0 {
+			return fmt.Sprintf("%d%s", rate/unit.size, unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", rate)
+}
+
+// parseRate parses a rate such as 512k, 1.5M or off. A number without
+// a suffix is in KiB/s.
+func parseRate(s string) (fs.SizeSuffix, error) {
+	if strings.EqualFold(s, "off") {
+		return 0, nil
+	}
+	unit := fs.KiByte
+	number := s
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'b', 'B':
+			unit, number = fs.Byte, s[:n-1]
+		case 'k', 'K':
+			unit, number = fs.KiByte, s[:n-1]
+		case 'm', 'M':
+			unit, number = fs.MiByte, s[:n-1]
+		case 'g', 'G':
+			unit, number = fs.GiByte, s[:n-1]
+		}
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid bandwidth %q", s)
+	}
+	return fs.SizeSuffix(value * float64(unit)), nil
+}
+
+// parseLimits parses limits for both directions such as 1M, or for
+// upload and download separately such as 512k:off
+func parseLimits(s string) (Limits, error) {
+	up, down, separate := strings.Cut(s, ":")
+	upload, err := parseRate(up)
+	if err != nil {
+		return Limits{}, err
+	}
+	if !separate {
+		return Limits{Upload: upload, Download: upload}, nil
+	}
+	download, err := parseRate(down)
+	if err != nil {
+		return Limits{}, err
+	}
+	return Limits{Upload: upload, Download: download}, nil
+}
+
+// Slot is an entry in a Timetable, with the limits from a time of day
+// until the next slot
+type Slot struct {
+	Start  time.Duration // time since midnight the slot starts
+	Limits Limits
+}
+
+// Timetable says which limits apply at each time of day. The slots are
+// in order of start time, and the last one carries on past midnight
+// until the first.
+type Timetable []Slot
+
+// Parse parses a timetable.
+//
+// It is either a single set of limits which always applies, or a space
+// separated list of HH:MM,LIMITS slots, such as
+//
+//	08:00,512k 19:00,off
+//
+// LIMITS is a rate for both directions, such as 512k, 1.5M or off, or
+// UPLOAD:DOWNLOAD rates such as 512k:off. A number without a suffix is
+// in KiB/s. An empty string or "off" means no limits.
+func Parse(s string) (Timetable, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.EqualFold(s, "off") {
+		return nil, nil
+	}
+	if !strings.Contains(s, ",") {
+		limits, err := parseLimits(s)
+		if err != nil {
+			return nil, err
+		}
+		return Timetable{{Limits: limits}}, nil
+	}
+
+	var tt Timetable
+	for _, entry := range strings.Fields(s) {
+		when, limitsString, ok := strings.Cut(entry, ",")
+		if !ok {
+			return nil, fmt.Errorf("invalid bandwidth slot %q: want HH:MM,LIMITS", entry)
+		}
+		start, err := time.Parse("15:04", when)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time in bandwidth slot %q: %w", entry, err)
+		}
+		limits, err := parseLimits(limitsString)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bandwidth slot %q: %w", entry, err)
+		}
+		tt = append(tt, Slot{
+			Start:  time.Duration(start.Hour())*time.Hour + time.Duration(start.Minute())*time.Minute,
+			Limits: limits,
+		})
+	}
+	sort.SliceStable(tt, func(i, j int) bool { return tt[i].Start < tt[j].Start })
+	for i := 1; i < len(tt); i++ {
+		if tt[i].Start == tt[i-1].Start {
+			return nil, fmt.Errorf("more than one bandwidth slot at %s", formatStart(tt[i].Start))
+		}
+	}
+	return tt, nil
+}
+
+// formatStart formats the start of a slot as HH:MM
+func formatStart(start time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(start.Hours()), int(start.Minutes())%60)
+}
+
+// String returns the timetable in the form Parse reads
+func (tt Timetable) String() string {
+	switch {
+	case len(tt) == 0:
+		return "off"
+	case len(tt) == 1 && tt[0].Start == 0:
+		return tt[0].Limits.String()
+	}
+	slots := make([]string, len(tt))
+	for i, slot := range tt {
+		slots[i] = formatStart(slot.Start) + "," + slot.Limits.String()
+	}
+	return strings.Join(slots, " ")
+}
+
+// LimitsAt returns the limits which apply at t, in t's location
+func (tt Timetable) LimitsAt(t time.Time) Limits {
+	if len(tt) == 0 {
+		return Limits{}
+	}
+	sinceMidnight := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	// Before the first slot the last one from the day before applies
+	limits := tt[len(tt)-1].Limits
+	for _, slot := range tt {
+		if slot.Start > sinceMidnight {
+			break
+		}
+		limits = slot.Limits
+	}
+	return limits
+}
+
+// bucket is a token bucket holding up to a second's worth of bytes
+type bucket struct {
+	rate   fs.SizeSuffix // bytes added per second, 0 for no limit
+	tokens float64       // bytes in the bucket, negative if reads are waiting
+	filled time.Time     // when tokens was last brought up to date
+}
+
+// setRate changes the rate, keeping the bytes already used
+func (b *bucket) setRate(rate fs.SizeSuffix) {
+	if rate == b.rate {
+		return
+	}
+	b.rate = rate
+	if b.tokens > float64(rate) {
+		b.tokens = float64(rate)
+	}
+}
+
+// reserve takes n bytes from the bucket at now, returning how long to
+// wait until they are there
+func (b *bucket) reserve(now time.Time, n int) time.Duration {
+	if b.rate <= 0 {
+		b.filled = time.Time{}
+		return 0
+	}
+	rate := float64(b.rate)
+	if b.filled.IsZero() {
+		b.tokens = rate
+	} else {
+		b.tokens += now.Sub(b.filled).Seconds() * rate
+		if b.tokens > rate {
+			b.tokens = rate
+		}
+	}
+	b.filled = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
+
+// Limiter limits the bandwidth of all the transfers it wraps, with one
+// token bucket for uploads and one for downloads shared between them.
+//
+// The limits follow a Timetable and can be changed at any time, also
+// affecting transfers already running. A nil *Limiter doesn't limit
+// anything.
+type Limiter struct {
+	mu        sync.Mutex
+	timetable Timetable
+	upload    bucket
+	download  bucket
+	now       func() time.Time // the time, replaceable for tests
+}
+
+// New creates a Limiter following tt
+func New(tt Timetable) *Limiter {
+	return &Limiter{
+		timetable: tt,
+		now:       time.Now,
+	}
+}
+
+// SetTimetable makes the limiter follow tt from now on
+func (l *Limiter) SetTimetable(tt Timetable) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.timetable = tt
+}
+
+// SetLimits sets limits which apply at all times, replacing the
+// timetable
+func (l *Limiter) SetLimits(limits Limits) {
+	l.SetTimetable(Timetable{{Limits: limits}})
+}
+
+// Timetable returns the timetable the limiter follows
+func (l *Limiter) Timetable() Timetable {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.timetable
+}
+
+// Limits returns the limits which apply now
+func (l *Limiter) Limits() Limits {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.timetable.LimitsAt(l.now())
+}
+
+// reserve takes n bytes from the upload or download bucket at the
+// current limits, returning how long to wait until they are there
+func (l *Limiter) reserve(upload bool, n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	limits := l.timetable.LimitsAt(now)
+	if upload {
+		l.upload.setRate(limits.Upload)
+		return l.upload.reserve(now, n)
+	}
+	l.download.setRate(limits.Download)
+	return l.download.reserve(now, n)
+}
+
+// wait waits until n more bytes can be transferred, or ctx is done
+func (l *Limiter) wait(ctx context.Context, upload bool, n int) error {
+	delay := l.reserve(upload, n)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reader limits the bandwidth of the data read through it
+type reader struct {
+	ctx    context.Context
+	in     io.Reader
+	l      *Limiter
+	upload bool
+}
+
+// Read reads from the underlying reader, waiting for the bandwidth
+// used afterwards
+func (r *reader) Read(p []byte) (n int, err error) {
+	if len(p) > maxRead {
+		p = p[:maxRead]
+	}
+	n, err = r.in.Read(p)
+	if n > 0 {
+		if waitErr := r.l.wait(r.ctx, r.upload, n); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+// readCloser is a reader which closes the underlying reader
+type readCloser struct {
+	reader
+	io.Closer
+}
+
+// Upload wraps in so reading it counts against the upload limit.
+// Waiting for the bandwidth stops when ctx is done.
+func (l *Limiter) Upload(ctx context.Context, in io.Reader) io.Reader {
+	if l == nil {
+		return in
+	}
+	return &reader{ctx: ctx, in: in, l: l, upload: true}
+}
+
+// Download wraps rc so reading it counts against the download limit.
+// Waiting for the bandwidth stops when ctx is done.
+func (l *Limiter) Download(ctx context.Context, rc io.ReadCloser) io.ReadCloser {
+	if l == nil {
+		return rc
+	}
+	return &readCloser{reader: reader{ctx: ctx, in: rc, l: l}, Closer: rc}
+}
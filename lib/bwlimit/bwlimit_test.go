@@ -0,0 +1,168 @@
+package bwlimit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/standalone-gdrive/fs"
+)
+
+func TestParse(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want string
+	}{
+		{"", "off"},
+		{"off", "off"},
+		{"512k", "512k"},
+		{"10", "10k"},
+		{"1.5M", "1536k"},
+		{"100B", "100B"},
+		{"1M:off", "1M:off"},
+		{"19:00,off 08:00,512k", "08:00,512k 19:00,off"},
+		{"00:00,1G 12:30,256k:2M", "00:00,1G 12:30,256k:2M"},
+	} {
+		tt, err := Parse(test.in)
+		if err != nil {
+			t.Errorf("%q: %v", test.in, err)
+			continue
+		}
+		if got := tt.String(); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.in, got, test.want)
+		}
+	}
+	for _, bad := range []string{"fast", "-1M", "08:00,", "25:00,1M", "08:00,1M 08:00,2M", "08:00,1M:2M:3M"} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestLimitsAt(t *testing.T) {
+	tt, err := Parse("08:00,512k 19:00,off")
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2024, 1, 2, 0, 0, 0, 0, time.Local)
+	for at, want := range map[time.Duration]fs.SizeSuffix{
+		3 * time.Hour:                    0, // still off from 19:00 the day before
+		8 * time.Hour:                    512 * fs.KiByte,
+		18*time.Hour + 59*time.Minute:    512 * fs.KiByte,
+		19 * time.Hour:                   0,
+		23*time.Hour + 59*time.Minute:    0,
+		7*time.Hour + 59*time.Minute + 1: 0,
+	} {
+		limits := tt.LimitsAt(day.Add(at))
+		if limits.Upload != want || limits.Download != want {
+			t.Errorf("at %v got %v, want %v", at, limits, want)
+		}
+	}
+}
+
+func TestBucket(t *testing.T) {
+	b := &bucket{rate: 1000}
+	now := time.Unix(1000, 0)
+	if got := b.reserve(now, 1000); got != 0 {
+		t.Errorf("the first second's worth waited %v", got)
+	}
+	if got := b.reserve(now, 500); got != 500*time.Millisecond {
+		t.Errorf("got %v, want 500ms", got)
+	}
+	if got := b.reserve(now.Add(time.Second), 500); got != 0 {
+		t.Errorf("after refilling got %v, want 0", got)
+	}
+	// Lowering the rate doesn't let through more than the new second's worth
+	b.setRate(100)
+	if got := b.reserve(now.Add(time.Hour), 200); got != time.Second {
+		t.Errorf("after lowering the rate got %v, want 1s", got)
+	}
+	b.setRate(0)
+	if got := b.reserve(now.Add(time.Hour), 1e9); got != 0 {
+		t.Errorf("without a limit got %v", got)
+	}
+}
+
+func TestLimiterSharedAndScheduled(t *testing.T) {
+	tt, err := Parse("08:00,1k:2k 19:00,off")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := New(tt)
+	now := time.Date(2024, 1, 2, 7, 0, 0, 0, time.Local)
+	l.now = func() time.Time { return now }
+
+	// Off before 08:00
+	if got := l.reserve(true, 1<<30); got != 0 {
+		t.Errorf("got %v before 08:00", got)
+	}
+
+	// At 08:00 all the uploads share the 1k bucket, while downloads
+	// have their own
+	now = now.Add(time.Hour)
+	if got := l.Limits(); got != (Limits{Upload: fs.KiByte, Download: 2 * fs.KiByte}) {
+		t.Errorf("got limits %v", got)
+	}
+	first := l.reserve(true, 1024)
+	download := l.reserve(false, 1024)
+	second := l.reserve(true, 512)
+	if first != 0 || download != 0 || second != 500*time.Millisecond {
+		t.Errorf("got waits %v %v %v", first, download, second)
+	}
+
+	// Changing the limits at runtime affects the next reads
+	l.SetLimits(Limits{Upload: 512 * fs.Byte})
+	if got := l.Timetable().String(); got != "512B:off" {
+		t.Errorf("got timetable %q", got)
+	}
+	if got := l.reserve(true, 512); got != 2*time.Second {
+		t.Errorf("after lowering the limit got %v, want 2s", got)
+	}
+	l.SetLimits(Limits{})
+	if got := l.reserve(true, 1<<30); got != 0 {
+		t.Errorf("got %v without limits", got)
+	}
+}
+
+func TestReader(t *testing.T) {
+	l := New(Timetable{{Limits: Limits{Upload: 64 * fs.KiByte}}})
+	data := make([]byte, 96*1024)
+	start := time.Now()
+	got, err := io.ReadAll(l.Upload(context.Background(), bytes.NewReader(data)))
+	if err != nil || len(got) != len(data) {
+		t.Fatalf("read %d bytes: %v", len(got), err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("read 96k at 64k/s in %v", elapsed)
+	}
+
+	// Downloads aren't limited
+	start = time.Now()
+	rc := l.Download(context.Background(), io.NopCloser(bytes.NewReader(data)))
+	if _, err := io.Copy(io.Discard, rc); err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("unlimited download took %v", elapsed)
+	}
+
+	// Waiting stops with the context
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = io.ReadAll(l.Upload(ctx, bytes.NewReader(make([]byte, 1<<20))))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the deadline", err)
+	}
+
+	var nilLimiter *Limiter
+	in := bytes.NewReader(data)
+	if nilLimiter.Upload(context.Background(), in) != in {
+		t.Error("a nil Limiter wrapped the reader")
+	}
+}
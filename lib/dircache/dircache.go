@@ -9,6 +9,8 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+
+	"github.com/standalone-gdrive/fs"
 )
 
 // DirCacher describes an interface for doing the low level directory work
@@ -20,6 +22,16 @@ type DirCacher interface {
 	CreateDir(ctx context.Context, pathID, leaf string) (newID string, err error)
 }
 
+// AliasFinder is an optional interface for a DirCacher whose FindLeaf
+// can find a directory through an alias, such as a shortcut, leading
+// to a directory which lives somewhere else.
+//
+// Directories found through an alias are cached with PutAlias so the
+// alias path isn't given as the directory's path.
+type AliasFinder interface {
+	IsAlias(pathID, leaf string) bool
+}
+
 // DirCache caches paths to directory IDs and vice versa
 type DirCache struct {
 	cacheMu  sync.RWMutex // protects cache and invCache
@@ -78,6 +90,76 @@ func (dc *DirCache) Put(path, id string) {
 	dc.cacheMu.Unlock()
 }
 
+// PutAlias puts a path, id in the cache where path is an alias for the
+// directory, such as a shortcut to it. Get finds id from path but
+// GetInv and FindPath don't return path for id.
+func (dc *DirCache) PutAlias(path, id string) {
+	dc.cacheMu.Lock()
+	dc.cache[path] = id
+	if dc.invCache[id] == path {
+		delete(dc.invCache, id)
+	}
+	dc.cacheMu.Unlock()
+}
+
+// isUnder returns true if p is dir or inside dir
+func isUnder(dir, p string) bool {
+	if dir == "" {
+		return true
+	}
+	return p == dir || strings.HasPrefix(p, dir+"/")
+}
+
+// FlushDir removes dir and every cached path under it from the cache
+func (dc *DirCache) FlushDir(dir string) {
+	dc.cacheMu.Lock()
+	for p, id := range dc.cache {
+		if isUnder(dir, p) {
+			delete(dc.cache, p)
+			if dc.invCache[id] == p {
+				delete(dc.invCache, id)
+			}
+		}
+	}
+	dc.cacheMu.Unlock()
+}
+
+// MoveDir rewrites every cached path at or under srcDir so that it is
+// under dstDir instead, keeping the directory IDs.
+//
+// This should be called after a directory has been moved or renamed
+// so the cache doesn't need to be rebuilt for the subtree.
+func (dc *DirCache) MoveDir(srcDir, dstDir string) {
+	dc.cacheMu.Lock()
+	type movedDir struct {
+		id    string
+		alias bool // whether the path is an alias for id
+	}
+	moved := make(map[string]movedDir)
+	for p, id := range dc.cache {
+		if !isUnder(srcDir, p) {
+			continue
+		}
+		delete(dc.cache, p)
+		alias := dc.invCache[id] != p
+		newPath := dstDir
+		if rel := strings.TrimPrefix(strings.TrimPrefix(p, srcDir), "/"); rel != "" {
+			if newPath != "" {
+				newPath += "/"
+			}
+			newPath += rel
+		}
+		moved[newPath] = movedDir{id: id, alias: alias}
+	}
+	for p, dir := range moved {
+		dc.cache[p] = dir.id
+		if !dir.alias {
+			dc.invCache[dir.id] = p
+		}
+	}
+	dc.cacheMu.Unlock()
+}
+
 // Finds the actual root from the root path and rootID
 //
 // # Call this first and call the functions given back
@@ -198,11 +280,17 @@ func (dc *DirCache) FindDir(ctx context.Context, path string) (string, error) {
 			return "", err
 		}
 		if !found {
-			return "", fmt.Errorf("couldn't find directory %q", path)
+			return "", fmt.Errorf("couldn't find directory %q: %w", path, fs.ErrorDirNotFound)
 		}
+		aliases, ok := dc.fs.(AliasFinder)
+		isAlias := ok && aliases.IsAlias(parentID, part)
 		parentID = dirID
 		dirPath = filepath.Join(dirPath, part)
-		dc.Put(dirPath, dirID)
+		if isAlias {
+			dc.PutAlias(dirPath, dirID)
+		} else {
+			dc.Put(dirPath, dirID)
+		}
 	}
 	return parentID, nil
 }
@@ -0,0 +1,86 @@
+package dircache
+
+import (
+	"testing"
+)
+
+func newTestCache() *DirCache {
+	dc := New("", "root", nil)
+	dc.Put("a", "id-a")
+	dc.Put("a/b", "id-b")
+	dc.Put("a/b/c", "id-c")
+	dc.Put("ab", "id-ab")
+	dc.Put("x", "id-x")
+	return dc
+}
+
+func TestMoveDir(t *testing.T) {
+	dc := newTestCache()
+	dc.MoveDir("a", "x/y")
+
+	for path, id := range map[string]string{
+		"x/y":     "id-a",
+		"x/y/b":   "id-b",
+		"x/y/b/c": "id-c",
+		"ab":      "id-ab",
+		"x":       "id-x",
+	} {
+		if got, ok := dc.Get(path); !ok || got != id {
+			t.Errorf("Get(%q) = %q, %v; want %q", path, got, ok, id)
+		}
+		if got, ok := dc.GetInv(id); !ok || got != path {
+			t.Errorf("GetInv(%q) = %q, %v; want %q", id, got, ok, path)
+		}
+	}
+	for _, path := range []string{"a", "a/b", "a/b/c"} {
+		if _, ok := dc.Get(path); ok {
+			t.Errorf("Get(%q) should have been moved", path)
+		}
+	}
+}
+
+func TestFlushDir(t *testing.T) {
+	dc := newTestCache()
+	dc.FlushDir("a")
+
+	for _, path := range []string{"a", "a/b", "a/b/c"} {
+		if _, ok := dc.Get(path); ok {
+			t.Errorf("Get(%q) should have been flushed", path)
+		}
+	}
+	for _, id := range []string{"id-a", "id-b", "id-c"} {
+		if _, ok := dc.GetInv(id); ok {
+			t.Errorf("GetInv(%q) should have been flushed", id)
+		}
+	}
+	for _, path := range []string{"ab", "x"} {
+		if _, ok := dc.Get(path); !ok {
+			t.Errorf("Get(%q) should not have been flushed", path)
+		}
+	}
+}
+
+func TestPutAlias(t *testing.T) {
+	dc := newTestCache()
+	dc.PutAlias("link", "id-a")
+	dc.PutAlias("x/link", "id-b")
+
+	if got, ok := dc.Get("link"); !ok || got != "id-a" {
+		t.Errorf("Get(link) = %q, %v; want id-a", got, ok)
+	}
+	if got, _ := dc.GetInv("id-a"); got != "a" {
+		t.Errorf("GetInv(id-a) = %q; want the real path a", got)
+	}
+
+	// Moving or flushing an alias leaves the real path alone
+	dc.MoveDir("link", "renamed")
+	dc.FlushDir("x")
+	if got, ok := dc.Get("renamed"); !ok || got != "id-a" {
+		t.Errorf("Get(renamed) = %q, %v; want id-a", got, ok)
+	}
+	for id, want := range map[string]string{"id-a": "a", "id-b": "a/b"} {
+		if got, _ := dc.GetInv(id); got != want {
+			t.Errorf("GetInv(%q) = %q; want %q", id, got, want)
+		}
+	}
+}
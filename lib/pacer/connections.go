@@ -0,0 +1,148 @@
+package pacer
+
+import (
+	"context"
+	"time"
+)
+
+// connections limits the number of calls running at once. Unlike a
+// channel of tokens its limit can be changed while calls are waiting.
+type connections struct {
+	limit      int           // maximum calls at once, 0 for no limit
+	inUse      int           // calls running
+	changed    chan struct{} // closed and replaced when a call ends or the limit changes
+	generation int           // incremented each time the adaptive limit is cut
+	successes  int           // calls which succeeded since the limit was last changed
+}
+
+// Stats is a snapshot of the state of a Pacer for monitoring
+type Stats struct {
+	MaxConnections     int           // current limit on calls at once, 0 for no limit
+	InUse              int           // calls running now
+	FreeConnections    int           // calls which could start now, -1 for no limit
+	Tokens             float64       // tokens in the GoogleDrive bucket, negative if calls are waiting for them
+	Burst              int           // size of the GoogleDrive bucket, 0 if calls aren't paced by one
+	SleepTime          time.Duration // current time between calls
+	ConsecutiveRetries int           // retries since the last call which wasn't retried
+	Calls              int64         // calls made, including retries
+	Retries            int64         // calls which were retried
+	RateLimited        int64         // calls which were rate limited
+}
+
+// AdaptiveOption makes the limit on concurrent connections adapt to
+// rate limiting, AIMD style.
+//
+// The limit starts at the MaxConnectionsOption. Whenever a call is
+// rate limited it is halved, but not below minConnections or the
+// maximum if that is lower, and while
+// calls succeed it is raised by one for each limit's worth of them,
+// back up to the maximum. It has no effect without a maximum.
+func AdaptiveOption(minConnections int) Option {
+	return func(p *pacerOptions) {
+		if minConnections < 1 {
+			minConnections = 1
+		}
+		p.minConnections = minConnections
+	}
+}
+
+// broadcast wakes the calls waiting for a connection - call with mu held
+func (p *Pacer) broadcast() {
+	close(p.conns.changed)
+	p.conns.changed = make(chan struct{})
+}
+
+// acquire waits for a connection, returning the generation of the
+// limit it was given in, or an error if ctx is done first
+func (p *Pacer) acquire(ctx context.Context) (int, error) {
+	p.mu.Lock()
+	for p.conns.limit > 0 && p.conns.inUse >= p.conns.limit {
+		changed := p.conns.changed
+		p.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+		p.mu.Lock()
+	}
+	p.conns.inUse++
+	generation := p.conns.generation
+	p.mu.Unlock()
+	return generation, nil
+}
+
+// release gives back a connection from a call started in generation
+// which returned err, adapting the limit - call with mu held
+func (p *Pacer) release(generation int, err error) {
+	p.conns.inUse--
+	if p.minConnections > 0 && p.maxConnections > 0 {
+		p.adapt(generation, err)
+	}
+	p.broadcast()
+}
+
+// adapt cuts the limit if err is a rate limit, and raises it after
+// enough successes - call with mu held
+func (p *Pacer) adapt(generation int, err error) {
+	c := &p.conns
+	switch {
+	case IsRateLimited(err):
+		// Calls already running when the limit was cut don't cut it again
+		if generation != c.generation {
+			return
+		}
+		c.limit /= 2
+		if floor := min(p.minConnections, p.maxConnections); c.limit < floor {
+			c.limit = floor
+		}
+		c.generation++
+		c.successes = 0
+	case err == nil:
+		c.successes++
+		if c.successes >= c.limit && c.limit < p.maxConnections {
+			c.limit++
+			c.successes = 0
+		}
+	}
+}
+
+// SetMaxConnections sets the maximum number of concurrent connections,
+// 0 for no limit. It is safe to call while calls are running: waiting
+// calls start if the limit is raised, and if it is lowered no new calls
+// start until enough running ones have finished.
+//
+// In adaptive mode this sets the maximum the limit can be raised to,
+// lowering the current limit if it is above it.
+func (p *Pacer) SetMaxConnections(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.maxConnections = n
+	switch {
+	case p.minConnections == 0 || n <= 0:
+		p.conns.limit = n
+	case p.conns.limit > n || p.conns.limit <= 0:
+		p.conns.limit = n
+	}
+	p.broadcast()
+}
+
+// Stats returns a snapshot of the state of the Pacer
+func (p *Pacer) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := p.stats
+	stats.MaxConnections = p.conns.limit
+	stats.InUse = p.conns.inUse
+	stats.FreeConnections = -1
+	if p.conns.limit > 0 {
+		stats.FreeConnections = max(p.conns.limit-p.conns.inUse, 0)
+	}
+	if c, ok := p.calculator.(*GoogleDrive); ok && c.minSleep > 0 {
+		stats.Tokens = c.level(time.Now())
+		stats.Burst = int(c.size())
+	}
+	stats.SleepTime = p.state.SleepTime
+	stats.ConsecutiveRetries = p.state.ConsecutiveRetries
+	return stats
+}
@@ -2,6 +2,9 @@
 package pacer
 
 import (
+	"context"
+	"errors"
+	"math/rand"
 	"sync"
 	"time"
 )
@@ -25,23 +28,30 @@ type Calculator interface {
 // with a configurable delay in between.
 type Pacer struct {
 	pacerOptions
-	mu         sync.Mutex    // Protecting read/writes
-	pacer      chan struct{} // To pace the operations
-	connTokens chan struct{} // Connection tokens
-	state      State
+	mu    sync.Mutex    // Protecting read/writes
+	pacer chan struct{} // To pace the operations
+	conns connections   // Limit on the calls running at once - use with mu held
+	state State
+	stats Stats // Counters - use with mu held
 }
 
 type pacerOptions struct {
-	maxConnections int         // Maximum number of concurrent connections
-	retries        int         // Max number of retries
-	calculator     Calculator  // switchable pacing algorithm - call with mu held
-	invoker        InvokerFunc // wrapper function used to invoke the target function
+	maxConnections int             // Maximum number of concurrent connections, 0 for no limit
+	minConnections int             // Minimum the adaptive limit is cut to, 0 if not adaptive
+	retries        int             // Max number of retries
+	calculator     Calculator      // switchable pacing algorithm - call with mu held
+	invoker        InvokerFunc     // wrapper function used to invoke the target function
+	shouldRetry    ShouldRetryFunc // decides which errors from Call are retried
 }
 
 // InvokerFunc is the signature of the wrapper function used to invoke the
 // target function in Pacer.
 type InvokerFunc func(try, tries int, f Paced) (bool, error)
 
+// ShouldRetryFunc decides whether err is worth retrying. It may replace
+// err, and the replacement is what the Calculator sees and Call returns.
+type ShouldRetryFunc func(ctx context.Context, err error) (bool, error)
+
 // Option can be used in New to configure the Pacer.
 type Option func(*pacerOptions)
 
@@ -56,7 +66,7 @@ func RetriesOption(retries int) Option {
 }
 
 // MaxConnectionsOption sets the number of concurrent connections for the new
-// Pacer.
+// Pacer. 0 or less means no limit.
 func MaxConnectionsOption(maxConnections int) Option {
 	return func(p *pacerOptions) { p.maxConnections = maxConnections }
 }
@@ -67,6 +77,12 @@ func InvokerOption(i InvokerFunc) Option {
 	return func(p *pacerOptions) { p.invoker = i }
 }
 
+// ShouldRetryOption sets the function deciding which errors Call
+// retries. Without one every error is retried.
+func ShouldRetryOption(shouldRetry ShouldRetryFunc) Option {
+	return func(p *pacerOptions) { p.shouldRetry = shouldRetry }
+}
+
 // Paced in the internal interface for the calls to the pacer.
 type Paced func() (bool, error)
 
@@ -89,18 +105,45 @@ func New(options ...Option) *Pacer {
 	p.pacer = make(chan struct{}, 1)
 	// Fill the channel with 1 token
 	p.pacer <- struct{}{}
-	p.connTokens = make(chan struct{}, p.maxConnections)
-	// Fill the channel with maxConnections tokens
-	for i := 0; i < p.maxConnections; i++ {
-		p.connTokens <- struct{}{}
-	}
+	p.conns.limit = p.maxConnections
+	p.conns.changed = make(chan struct{})
 
 	return p
 }
 
-// NewGoogleDrive creates a Google Drive specific pacer
+// NewGoogleDrive creates a Google Drive specific pacer, using a
+// GoogleDrive Calculator unless the options set another one
 func NewGoogleDrive(options ...Option) *Pacer {
-	return New(options...)
+	return New(append([]Option{CalculatorOption(NewGoogleDriveCalculator())}, options...)...)
+}
+
+// retryDelayer is implemented by errors which say how long to wait
+// before the call which returned them is retried
+type retryDelayer interface {
+	RetryDelay() time.Duration
+}
+
+// IsRetryAfter returns how long err asks to wait before retrying, and
+// whether it asks at all
+func IsRetryAfter(err error) (time.Duration, bool) {
+	var r retryDelayer
+	if errors.As(err, &r) && r.RetryDelay() > 0 {
+		return r.RetryDelay(), true
+	}
+	return 0, false
+}
+
+// rateLimiteder is implemented by errors which say whether the call
+// which returned them was rate limited
+type rateLimiteder interface {
+	RateLimited() bool
+}
+
+// IsRateLimited returns true if err says the call which returned it
+// was rate limited
+func IsRateLimited(err error) bool {
+	var r rateLimiteder
+	return errors.As(err, &r) && r.RateLimited()
 }
 
 // DefaultCalculator is a Calculator implementation that provide the default
@@ -115,7 +158,10 @@ type DefaultCalculator struct {
 // MinSleep sets the minimum sleep time
 func MinSleep(t time.Duration) Option {
 	return func(p *pacerOptions) {
-		if c, ok := p.calculator.(*DefaultCalculator); ok {
+		switch c := p.calculator.(type) {
+		case *DefaultCalculator:
+			c.minSleep = t
+		case *GoogleDrive:
 			c.minSleep = t
 		}
 	}
@@ -124,7 +170,10 @@ func MinSleep(t time.Duration) Option {
 // MaxSleep sets the maximum sleep time
 func MaxSleep(t time.Duration) Option {
 	return func(p *pacerOptions) {
-		if c, ok := p.calculator.(*DefaultCalculator); ok {
+		switch c := p.calculator.(type) {
+		case *DefaultCalculator:
+			c.maxSleep = t
+		case *GoogleDrive:
 			c.maxSleep = t
 		}
 	}
@@ -142,7 +191,10 @@ func DecayConstant(t uint) Option {
 // Burst sets the burst count
 func Burst(t int) Option {
 	return func(p *pacerOptions) {
-		if c, ok := p.calculator.(*DefaultCalculator); ok {
+		switch c := p.calculator.(type) {
+		case *DefaultCalculator:
+			c.burst = t
+		case *GoogleDrive:
 			c.burst = t
 		}
 	}
@@ -150,6 +202,9 @@ func Burst(t int) Option {
 
 // Calculate calculates the next sleep time based on the State
 func (c *DefaultCalculator) Calculate(state State) time.Duration {
+	if t, ok := IsRetryAfter(state.LastError); ok {
+		return t
+	}
 	if state.ConsecutiveRetries == 0 {
 		return 0
 	}
@@ -166,6 +221,99 @@ func (c *DefaultCalculator) Calculate(state State) time.Duration {
 	return sleepTime
 }
 
+// GoogleDrive is a Calculator for Google Drive.
+//
+// Calls are paced with a token bucket holding burst tokens, with one
+// added every minSleep, so bursts of calls aren't slowed down but the
+// rate over time is.
+//
+// After errors it backs off exponentially with jitter, sleeping 2^n
+// seconds plus a random fraction of a second for the nth consecutive
+// retry, up to maxSleep, unless the error says how long to wait.
+//
+// See https://developers.google.com/drive/api/guides/limits#exponential
+type GoogleDrive struct {
+	minSleep time.Duration // time to add a token to the bucket
+	maxSleep time.Duration // maximum backoff
+	burst    int           // size of the bucket
+	tokens   float64       // tokens in the bucket, negative if calls are waiting
+	filled   time.Time     // when tokens was last brought up to date
+}
+
+// Default GoogleDrive settings
+const (
+	googleDriveMinSleep = 100 * time.Millisecond
+	googleDriveMaxSleep = 16 * time.Second
+	googleDriveBurst    = 100
+)
+
+// NewGoogleDriveCalculator creates a GoogleDrive Calculator with the
+// default settings
+func NewGoogleDriveCalculator() *GoogleDrive {
+	return &GoogleDrive{
+		minSleep: googleDriveMinSleep,
+		maxSleep: googleDriveMaxSleep,
+		burst:    googleDriveBurst,
+	}
+}
+
+// size returns the number of tokens the bucket holds
+func (c *GoogleDrive) size() float64 {
+	if c.burst < 1 {
+		return 1
+	}
+	return float64(c.burst)
+}
+
+// level returns the tokens in the bucket at now without taking any
+func (c *GoogleDrive) level(now time.Time) float64 {
+	if c.filled.IsZero() {
+		return c.size()
+	}
+	tokens := c.tokens + float64(now.Sub(c.filled))/float64(c.minSleep)
+	if tokens > c.size() {
+		tokens = c.size()
+	}
+	return tokens
+}
+
+// reserve takes a token from the bucket, returning how long to wait
+// until it is there
+func (c *GoogleDrive) reserve(now time.Time) time.Duration {
+	if c.minSleep <= 0 {
+		return 0
+	}
+	c.tokens = c.level(now)
+	c.filled = now
+	c.tokens--
+	if c.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-c.tokens * float64(c.minSleep))
+}
+
+// Calculate calculates the next sleep time based on the State
+func (c *GoogleDrive) Calculate(state State) time.Duration {
+	if t, ok := IsRetryAfter(state.LastError); ok {
+		if t < c.minSleep {
+			return c.minSleep
+		}
+		return t
+	}
+	if state.ConsecutiveRetries == 0 {
+		return c.reserve(time.Now())
+	}
+	retries := state.ConsecutiveRetries
+	if retries > 30 {
+		retries = 30 // don't overflow
+	}
+	sleepTime := time.Second << uint(retries-1)
+	if c.maxSleep > 0 && sleepTime > c.maxSleep {
+		sleepTime = c.maxSleep
+	}
+	return sleepTime + time.Duration(rand.Int63n(int64(time.Second)))
+}
+
 // DefaultInvoker is the default InvokerFunc used by Pacer
 func DefaultInvoker(try, tries int, paced Paced) (bool, error) {
 	again, err := paced()
@@ -175,79 +323,118 @@ func DefaultInvoker(try, tries int, paced Paced) (bool, error) {
 	return again, err
 }
 
-// Call runs f in a paced way
-//
-// It calls f and then waits the appropriate time before continuing.
-// If the function f returns true then another call will be scheduled
-// after the pacing sleep.
-//
-// This is useful for rate limiting and error handling.
-//
-// This implements calls with retries in a different way to the
-// existing call, Call.
-//
-// If the function f returns an error then after a short delay Call
-// will retry the operation. By default it will retry 10 times, but
-// this can be changed with the Retries method.
-//
-// If the function f returns true then Call will sleep for the
-// calculated time and then repeat the operation (not counting it as a
-// retry).
-//
-// The error return from Call is the error (if any) returned from the
-// last call of f.
-func (p *Pacer) Call(f Paced) error {
-	var (
-		err                error
-		again              bool
-		consecutiveRetries int
-	)
-
-	for try := 0; try <= p.retries; try++ {
-		p.mu.Lock()
-		// Get a pacer token
-		<-p.pacer
-		// Get a connection token
-		<-p.connTokens
-		// Do the operation
-		again, err = p.invoker(consecutiveRetries, p.retries, f)
-		// Return the connection token
-		p.connTokens <- struct{}{}
-		// If ok or no more retries, restore the pacer and return
-		if !again || try >= p.retries {
-			p.pacer <- struct{}{}
-			p.state.ConsecutiveRetries = 0
-			p.state.LastError = nil
-			p.mu.Unlock()
-			return err
-		}
+// beginCall waits for the pacer and a connection, returning an error
+// if ctx is done first. It returns the generation of the connection
+// limit the call started in.
+func (p *Pacer) beginCall(ctx context.Context) (int, error) {
+	// The pacer starts with a token in it, and whenever one is taken
+	// out another is put back in after the sleep time
+	select {
+	case <-p.pacer:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
 
-		// Calculate delay
-		p.state.ConsecutiveRetries++
-		p.state.LastError = err
-		sleepTime := p.calculator.Calculate(p.state)
-		p.state.SleepTime = sleepTime
-		p.mu.Unlock()
-
-		// If the retry function returned true, indicate consecutive retries
-		if again {
-			consecutiveRetries++
-		} else {
-			consecutiveRetries = 0
-		}
+	generation, err := p.acquire(ctx)
+	if err != nil {
+		p.pacer <- struct{}{}
+		return 0, err
+	}
 
-		// Sleep for the required time
-		time.Sleep(sleepTime)
-		p.mu.Lock()
-		// Return a token to the pacer
+	p.mu.Lock()
+	sleepTime := p.state.SleepTime
+	p.mu.Unlock()
+	time.AfterFunc(sleepTime, func() {
 		p.pacer <- struct{}{}
-		p.mu.Unlock()
+	})
+	return generation, nil
+}
 
+// endCall releases the connection, adapting the limit to err, and
+// works out the sleep time before the next call, which it returns
+func (p *Pacer) endCall(generation int, retry bool, err error) time.Duration {
+	p.mu.Lock()
+	p.release(generation, err)
+	p.stats.Calls++
+	if IsRateLimited(err) {
+		p.stats.RateLimited++
+	}
+	if retry {
+		p.stats.Retries++
+		p.state.ConsecutiveRetries++
+	} else {
+		p.state.ConsecutiveRetries = 0
+	}
+	p.state.LastError = err
+	p.state.SleepTime = p.calculator.Calculate(p.state)
+	sleepTime := p.state.SleepTime
+	p.mu.Unlock()
+	return sleepTime
+}
+
+// call runs f in a paced way, up to tries times
+func (p *Pacer) call(ctx context.Context, f Paced, tries int) (err error) {
+	var again bool
+	for try := 1; try <= tries; try++ {
+		generation, ctxErr := p.beginCall(ctx)
+		if ctxErr != nil {
+			if err == nil {
+				err = ctxErr
+			}
+			return err
+		}
+		again, err = p.invoker(try, tries, f)
+		sleepTime := p.endCall(generation, again, err)
 		if !again {
+			break
+		}
+		// Back off before retrying
+		select {
+		case <-time.After(sleepTime):
+		case <-ctx.Done():
 			return err
 		}
 	}
-
-	// Unreachable
 	return err
 }
+
+// Call runs fn in a paced way
+//
+// Calls wait for the sleep time calculated after the last call, and
+// for a connection token if MaxConnections is set, or until ctx is
+// done.
+//
+// If fn returns an error which the ShouldRetryFunc says is worth
+// retrying, Call retries it after the calculated sleep. By default it
+// will retry 10 times, but this can be changed with RetriesOption.
+//
+// The error return from Call is the error (if any) returned from the
+// last call of fn, as replaced by the ShouldRetryFunc.
+func (p *Pacer) Call(ctx context.Context, fn func() error) error {
+	return p.CallPaced(ctx, func() (bool, error) {
+		err := fn()
+		if err == nil {
+			return false, nil
+		}
+		if p.shouldRetry == nil {
+			return true, err
+		}
+		return p.shouldRetry(ctx, err)
+	})
+}
+
+// CallPaced runs f in a paced way
+//
+// It is like Call except f decides itself whether it is retried: if
+// f returns true then Call will sleep for the calculated time and
+// then repeat the operation.
+func (p *Pacer) CallPaced(ctx context.Context, f Paced) error {
+	return p.call(ctx, f, p.retries+1)
+}
+
+// CallNoRetry runs fn in a paced way once, without retrying it
+func (p *Pacer) CallNoRetry(ctx context.Context, fn func() error) error {
+	return p.call(ctx, func() (bool, error) {
+		return false, fn()
+	}, 1)
+}
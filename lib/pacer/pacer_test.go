@@ -0,0 +1,317 @@
+package pacer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// delayError asks to be retried after a delay
+type delayError time.Duration
+
+func (e delayError) Error() string             { return "retry later" }
+func (e delayError) RetryDelay() time.Duration { return time.Duration(e) }
+
+func TestGoogleDriveBurst(t *testing.T) {
+	c := &GoogleDrive{minSleep: time.Second, burst: 3}
+	now := time.Unix(1000, 0)
+	for i, want := range []time.Duration{0, 0, 0, time.Second, 2 * time.Second} {
+		if got := c.reserve(now); got != want {
+			t.Errorf("call %d: got %v, want %v", i, got, want)
+		}
+	}
+	// The bucket refills over time, but never beyond burst
+	if got := c.reserve(now.Add(time.Hour)); got != 0 {
+		t.Errorf("after an hour got %v, want 0", got)
+	}
+	c.reserve(now.Add(time.Hour))
+	c.reserve(now.Add(time.Hour))
+	if got := c.reserve(now.Add(time.Hour)); got != time.Second {
+		t.Errorf("after the burst got %v, want 1s", got)
+	}
+	if got := c.level(now.Add(time.Hour + 2*time.Second)); got != 1 {
+		t.Errorf("got %v tokens, want 1", got)
+	}
+}
+
+func TestStatsTokens(t *testing.T) {
+	p := New(CalculatorOption(&GoogleDrive{minSleep: time.Hour, burst: 3}), RetriesOption(0))
+	if stats := p.Stats(); stats.Tokens != 3 || stats.Burst != 3 {
+		t.Errorf("before any calls got stats %+v", stats)
+	}
+	_ = p.Call(context.Background(), func() error { return nil })
+	if stats := p.Stats(); stats.Tokens < 1.99 || stats.Tokens > 2.01 || stats.Burst != 3 {
+		t.Errorf("after a call got stats %+v", stats)
+	}
+	if stats := New(RetriesOption(0)).Stats(); stats.Burst != 0 {
+		t.Errorf("without a bucket got stats %+v", stats)
+	}
+}
+
+func TestGoogleDriveBackoff(t *testing.T) {
+	c := NewGoogleDriveCalculator()
+	err := errors.New("failed")
+	for retries, base := range map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		4:  8 * time.Second,
+		5:  16 * time.Second,
+		9:  16 * time.Second,
+		70: 16 * time.Second,
+	} {
+		got := c.Calculate(State{ConsecutiveRetries: retries, LastError: err})
+		if got < base || got >= base+time.Second {
+			t.Errorf("retry %d: got %v, want %v plus jitter", retries, got, base)
+		}
+	}
+	if got := c.Calculate(State{ConsecutiveRetries: 1, LastError: delayError(time.Minute)}); got != time.Minute {
+		t.Errorf("got %v, want the minute asked for", got)
+	}
+}
+
+func TestCallShouldRetry(t *testing.T) {
+	errRetry := errors.New("retry")
+	errStop := errors.New("stop")
+	p := New(
+		MinSleep(time.Millisecond),
+		RetriesOption(3),
+		ShouldRetryOption(func(ctx context.Context, err error) (bool, error) {
+			return err == errRetry, err
+		}),
+	)
+
+	calls := 0
+	err := p.Call(context.Background(), func() error {
+		calls++
+		return errRetry
+	})
+	if err != errRetry || calls != 4 {
+		t.Errorf("got %v after %d calls, want 4 calls", err, calls)
+	}
+
+	calls = 0
+	err = p.Call(context.Background(), func() error {
+		calls++
+		if calls == 1 {
+			return errRetry
+		}
+		return errStop
+	})
+	if err != errStop || calls != 2 {
+		t.Errorf("got %v after %d calls, want errStop after 2", err, calls)
+	}
+
+	calls = 0
+	err = p.CallNoRetry(context.Background(), func() error {
+		calls++
+		return errRetry
+	})
+	if err != errRetry || calls != 1 {
+		t.Errorf("CallNoRetry made %d calls", calls)
+	}
+}
+
+func TestCallHonoursRetryAfter(t *testing.T) {
+	p := New(MinSleep(time.Millisecond))
+	calls := 0
+	start := time.Now()
+	err := p.Call(context.Background(), func() error {
+		calls++
+		if calls == 1 {
+			return delayError(100 * time.Millisecond)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("retried after %v, want at least 100ms", elapsed)
+	}
+}
+
+func TestCallContext(t *testing.T) {
+	p := New(MinSleep(time.Hour), MaxSleep(time.Hour))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	calls := 0
+	err := p.Call(ctx, func() error {
+		calls++
+		return errors.New("failed")
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("got %v after %d calls, want the error after 1", err, calls)
+	}
+
+	// Waiting for a connection is cancelled too
+	p = New(MaxConnectionsOption(1))
+	release := make(chan struct{})
+	go func() {
+		_ = p.Call(context.Background(), func() error {
+			<-release
+			return nil
+		})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = p.Call(ctx, func() error { return nil })
+	close(release)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the deadline", err)
+	}
+}
+
+func TestMaxConnections(t *testing.T) {
+	p := New(MaxConnectionsOption(2))
+	var running, most int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = p.Call(context.Background(), func() error {
+				n := atomic.AddInt32(&running, 1)
+				for {
+					m := atomic.LoadInt32(&most)
+					if n <= m || atomic.CompareAndSwapInt32(&most, m, n) {
+						break
+					}
+				}
+				time.Sleep(5 * time.Millisecond)
+				atomic.AddInt32(&running, -1)
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+	if most != 2 {
+		t.Errorf("got %d calls at once, want 2", most)
+	}
+}
+
+// rateLimitError is a rate limit
+type rateLimitError struct{}
+
+func (rateLimitError) Error() string     { return "rate limited" }
+func (rateLimitError) RateLimited() bool { return true }
+
+func TestAdaptive(t *testing.T) {
+	p := New(MaxConnectionsOption(8), AdaptiveOption(2), RetriesOption(0))
+	limited := func() error { return rateLimitError{} }
+	ok := func() error { return nil }
+
+	_ = p.Call(context.Background(), limited)
+	if got := p.Stats().MaxConnections; got != 4 {
+		t.Fatalf("after a rate limit got %d, want 4", got)
+	}
+	_ = p.Call(context.Background(), limited)
+	_ = p.Call(context.Background(), limited)
+	if got := p.Stats().MaxConnections; got != 2 {
+		t.Fatalf("got %d, want the minimum 2", got)
+	}
+
+	// Additive increase: one more for each limit's worth of successes
+	for i := 0; i < 2; i++ {
+		_ = p.Call(context.Background(), ok)
+	}
+	if got := p.Stats().MaxConnections; got != 3 {
+		t.Errorf("after 2 successes got %d, want 3", got)
+	}
+	for i := 0; i < 100; i++ {
+		_ = p.Call(context.Background(), ok)
+	}
+	stats := p.Stats()
+	if stats.MaxConnections != 8 {
+		t.Errorf("got %d, want the maximum 8", stats.MaxConnections)
+	}
+	if stats.Calls != 105 || stats.RateLimited != 3 || stats.FreeConnections != 8 || stats.InUse != 0 {
+		t.Errorf("got stats %+v", stats)
+	}
+}
+
+func TestAdaptiveKeepsMinimum(t *testing.T) {
+	p := New(MaxConnectionsOption(8), AdaptiveOption(4), RetriesOption(0))
+	p.SetMaxConnections(2)
+	p.SetMaxConnections(8)
+	_ = p.Call(context.Background(), func() error { return rateLimitError{} })
+	_ = p.Call(context.Background(), func() error { return rateLimitError{} })
+	if got := p.Stats().MaxConnections; got != 4 {
+		t.Errorf("got %d, want the configured minimum 4", got)
+	}
+}
+
+func TestAdaptiveCutsOncePerGeneration(t *testing.T) {
+	p := New(MaxConnectionsOption(8), AdaptiveOption(1), RetriesOption(0))
+	var started, release sync.WaitGroup
+	started.Add(8)
+	release.Add(1)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = p.Call(context.Background(), func() error {
+				started.Done()
+				release.Wait()
+				return rateLimitError{}
+			})
+		}()
+	}
+	started.Wait()
+	release.Done()
+	wg.Wait()
+	if got := p.Stats().MaxConnections; got != 4 {
+		t.Errorf("got %d, want one cut to 4", got)
+	}
+}
+
+func TestSetMaxConnectionsWhileWaiting(t *testing.T) {
+	p := New(MaxConnectionsOption(1))
+	release := make(chan struct{})
+	running := make(chan struct{}, 3)
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = p.Call(context.Background(), func() error {
+				running <- struct{}{}
+				<-release
+				return nil
+			})
+		}()
+	}
+	<-running
+	select {
+	case <-running:
+		t.Fatal("a second call started with a limit of 1")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if stats := p.Stats(); stats.InUse != 1 || stats.FreeConnections != 0 {
+		t.Errorf("got stats %+v", stats)
+	}
+
+	p.SetMaxConnections(3)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-running:
+		case <-time.After(time.Second):
+			t.Fatal("waiting calls didn't start when the limit was raised")
+		}
+	}
+
+	// Lowering the limit doesn't disturb the running calls
+	p.SetMaxConnections(1)
+	if stats := p.Stats(); stats.InUse != 3 || stats.FreeConnections != 0 {
+		t.Errorf("got stats %+v", stats)
+	}
+	close(release)
+	wg.Wait()
+	if stats := p.Stats(); stats.InUse != 0 || stats.FreeConnections != 1 {
+		t.Errorf("got stats %+v", stats)
+	}
+}
@@ -0,0 +1,140 @@
+package readers
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// OpenRangeFn opens data at offset, returning limit bytes, or up to
+// the end of the data if limit is -1
+type OpenRangeFn func(offset, limit int64) (io.ReadCloser, error)
+
+// ReopenReadSeeker makes an io.ReadSeekCloser and io.ReaderAt from
+// data which can be opened at any offset, such as a remote object
+// supporting ranged reads.
+//
+// Seeking is free: the data is reopened at the new offset on the next
+// Read. ReadAt opens the range it needs each time so it may be called
+// concurrently with itself and with Read.
+type ReopenReadSeeker struct {
+	mu     sync.Mutex
+	open   OpenRangeFn
+	size   int64
+	offset int64
+	rc     io.ReadCloser // open reader at offset, nil if not open
+	closed bool
+}
+
+// errClosed is returned when using a closed ReopenReadSeeker
+var errClosed = errors.New("read on closed reader")
+
+// errNegativeOffset is returned when seeking before the start
+var errNegativeOffset = errors.New("seek to negative offset")
+
+// NewReopenReadSeeker makes a ReopenReadSeeker reading data of size
+// bytes with open. size may be -1 if it isn't known, in which case
+// seeking relative to the end isn't possible.
+func NewReopenReadSeeker(size int64, open OpenRangeFn) *ReopenReadSeeker {
+	return &ReopenReadSeeker{
+		open: open,
+		size: size,
+	}
+}
+
+// Read implements io.Reader
+func (r *ReopenReadSeeker) Read(p []byte) (n int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return 0, errClosed
+	}
+	if r.size >= 0 && r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.rc == nil {
+		r.rc, err = r.open(r.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err = r.rc.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+// Seek implements io.Seeker
+//
+// The underlying reader is closed if the offset changes and reopened
+// on the next Read.
+func (r *ReopenReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return 0, errClosed
+	}
+	var newOffset int64
+	switch whence {
+	case io.SeekStart:
+		newOffset = offset
+	case io.SeekCurrent:
+		newOffset = r.offset + offset
+	case io.SeekEnd:
+		if r.size < 0 {
+			return 0, errors.New("can't seek from end of data of unknown size")
+		}
+		newOffset = r.size + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if newOffset < 0 {
+		return 0, errNegativeOffset
+	}
+	if newOffset != r.offset && r.rc != nil {
+		_ = r.rc.Close()
+		r.rc = nil
+	}
+	r.offset = newOffset
+	return newOffset, nil
+}
+
+// ReadAt implements io.ReaderAt
+func (r *ReopenReadSeeker) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errNegativeOffset
+	}
+	if r.size >= 0 && off >= r.size {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	rc, err := r.open(off, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+	n, err = io.ReadFull(rc, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Close implements io.Closer
+func (r *ReopenReadSeeker) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return errClosed
+	}
+	r.closed = true
+	if r.rc != nil {
+		err := r.rc.Close()
+		r.rc = nil
+		return err
+	}
+	return nil
+}